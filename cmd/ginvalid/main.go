@@ -8,7 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"strings"
+	"path/filepath"
 
 	"github.com/G-Node/gin-cli/ginclient"
 	cliconfig "github.com/G-Node/gin-cli/ginclient/config"
@@ -16,6 +16,7 @@ import (
 	"github.com/G-Node/gin-valid/internal/config"
 	"github.com/G-Node/gin-valid/internal/helpers"
 	"github.com/G-Node/gin-valid/internal/log"
+	"github.com/G-Node/gin-valid/internal/validators"
 	"github.com/G-Node/gin-valid/internal/web"
 	"github.com/docopt/docopt-go"
 	"github.com/gorilla/handlers"
@@ -26,9 +27,17 @@ const usage = `Server validating BIDS files
 
 Usage:
   ginvalid [--listen=<port>] [--config=<path>]
+  ginvalid migrate-tokens [--config=<path>]
+  ginvalid reencrypt-tokens [--config=<path>]
   ginvalid -h | --help
   ginvalid --version
 
+Commands:
+  migrate-tokens      Import the token files and their session and repository
+                      links into the configured token store and exit.
+  reencrypt-tokens    Encrypt all stored tokens and webhook secrets with the
+                      current token key and exit.
+
 Options:
   -h --help           Show this screen.
   --version           Print version.
@@ -45,13 +54,32 @@ func registerRoutes(r *mux.Router) {
 	r.HandleFunc("/status/{validator}/{user}/{repo}", web.Status).Methods("GET")
 	r.HandleFunc("/results/{validator}/{user}/{repo}", web.Results).Methods("GET")
 	r.HandleFunc("/results/{validator}/{user}/{repo}/{id}", web.Results).Methods("GET")
+	r.HandleFunc("/history/{validator}/{user}/{repo}", web.History).Methods("GET")
+	r.HandleFunc("/compare/{validator}/{user}/{repo}/{base}/{head}", web.Compare).Methods("GET")
+	r.HandleFunc("/api/v1/results/{validator}/{user}/{repo}", web.ResultsAPI).Methods("GET")
+	r.HandleFunc("/api/v1/results/{validator}/{user}/{repo}/{id}", web.ResultsAPI).Methods("GET")
+	r.HandleFunc("/api/v1/status/{validator}/{user}/{repo}", web.StatusAPI).Methods("GET")
+	r.HandleFunc("/api/v1/status/{validator}/{user}/{repo}/{id}", web.StatusAPI).Methods("GET")
+	r.HandleFunc("/api/v1/shields/{validator}/{user}/{repo}", web.ShieldsAPI).Methods("GET")
+	r.HandleFunc("/api/v1/history/{validator}/{user}/{repo}", web.HistoryAPI).Methods("GET")
+	r.HandleFunc("/api/v1/compare/{validator}/{user}/{repo}/{base}/{head}", web.CompareAPI).Methods("GET")
 	r.HandleFunc("/login", web.LoginGet).Methods("GET")
 	r.HandleFunc("/login", web.LoginPost).Methods("POST")
+	r.HandleFunc("/oauth/login", web.OAuthLogin).Methods("GET")
+	r.HandleFunc("/oauth/callback", web.OAuthCallback).Methods("GET")
+	r.HandleFunc("/logout", web.Logout).Methods("POST")
+	r.HandleFunc("/sessions", web.Sessions).Methods("GET")
+	r.HandleFunc("/sessions/{key}/revoke", web.RevokeSession).Methods("POST")
 	r.HandleFunc("/repos", web.ListRepos).Methods("GET")
 	r.HandleFunc("/repos/{user}", web.ListRepos).Methods("GET")
-	r.HandleFunc("/repos/{user}/{repo}/{validator}/enable", web.EnableHook).Methods("GET")
-	r.HandleFunc("/repos/{user}/{repo}/{hookid}/disable", web.DisableHook).Methods("GET")
+	r.HandleFunc("/repos/{user}/{repo}/{validator}/enable", web.EnableHook).Methods("POST")
+	r.HandleFunc("/repos/{user}/{repo}/{hookid}/disable", web.DisableHook).Methods("POST")
 	r.HandleFunc("/repos/{user}/{repo}/hooks", web.ShowRepo).Methods("GET")
+	r.HandleFunc("/repos/{user}/{repo}/{validator}/revalidate", web.Revalidate).Methods("POST")
+	r.HandleFunc("/repos/{user}/{repo}/webhooks", web.AddWebhook).Methods("POST")
+	r.HandleFunc("/repos/{user}/{repo}/webhooks/{hookid}/remove", web.RemoveWebhook).Methods("POST")
+	r.HandleFunc("/admin", web.Admin).Methods("GET")
+	r.HandleFunc("/admin/revalidate", web.RevalidateAll).Methods("POST")
 	r.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", http.FileServer(http.Dir("/assets"))))
 }
 
@@ -64,13 +92,45 @@ func startupCheck(srvcfg config.ServerCfg) {
 	log.ShowWrite("[Warmup] using temp directory: '%s'", srvcfg.Dir.Temp)
 	log.ShowWrite("[Warmup] using results directory '%s'", srvcfg.Dir.Result)
 
-	// Check bids-validator is installed
-	outstr, err := helpers.AppVersionCheck(srvcfg.Exec.BIDS)
+	// Check the execution backend of the validators
+	backend, err := validators.CheckSandbox()
 	if err != nil {
-		log.ShowWrite("[Error] checking bids-validator '%s'", err.Error())
+		log.ShowWrite("[Warning] sandbox unavailable, validators run without isolation: %s", err.Error())
+	}
+	log.ShowWrite("[Warmup] running validators with the %q backend", backend)
+
+	// Load the token key once, generating it if necessary
+	if err := web.LoadTokenKeys(); err != nil {
+		log.ShowWrite("[Error] loading token key: %s", err.Error())
 		os.Exit(-1)
 	}
-	log.ShowWrite("[Warmup] using bids-validator v%s", strings.TrimSpace(outstr))
+	if srvcfg.TokenStore.KeyFile == "" {
+		log.ShowWrite("[Warning] no token key configured, access tokens are stored unencrypted")
+	}
+	log.ShowWrite("[Warmup] storing tokens with the %q backend", srvcfg.TokenStore.Backend)
+
+	// Check the login mode
+	switch srvcfg.Settings.LoginMode {
+	case "", "password":
+		log.ShowWrite("[Warmup] users log in with their GIN password")
+	case "oauth":
+		if srvcfg.Settings.OAuth.ClientID == "" || srvcfg.Settings.RootURL == "" {
+			log.ShowWrite("[Error] OAuth login requires settings.oauth.clientid and settings.rooturl")
+			os.Exit(-1)
+		}
+		log.ShowWrite("[Warmup] users log in with OAuth as client %q", srvcfg.Settings.OAuth.ClientID)
+	default:
+		log.ShowWrite("[Error] unknown login mode %q", srvcfg.Settings.LoginMode)
+		os.Exit(-1)
+	}
+
+	// Record the versions of the enabled validators for their reports
+	versions := validators.CheckVersions()
+	for _, name := range srvcfg.Settings.Validators {
+		if version, ok := versions[name]; ok {
+			log.ShowWrite("[Warmup] using %s validator %s", name, version)
+		}
+	}
 
 	commcheck(srvcfg)
 }
@@ -111,6 +171,33 @@ func commcheck(srvcfg config.ServerCfg) {
 	log.ShowWrite("[Warmup] GIN server configuration OK")
 }
 
+// absPaths returns the server configuration with the directories and the
+// token store files as absolute paths.
+func absPaths(srvcfg config.ServerCfg) (config.ServerCfg, error) {
+	paths := []*string{
+		&srvcfg.Dir.Temp, &srvcfg.Dir.Log, &srvcfg.Dir.Result, &srvcfg.Dir.Tokens,
+		&srvcfg.Dir.Queue, &srvcfg.Dir.Webhooks, &srvcfg.TokenStore.Path, &srvcfg.TokenStore.KeyFile,
+	}
+	oldkeyfiles := make([]string, len(srvcfg.TokenStore.OldKeyFiles))
+	copy(oldkeyfiles, srvcfg.TokenStore.OldKeyFiles)
+	srvcfg.TokenStore.OldKeyFiles = oldkeyfiles
+	for idx := range oldkeyfiles {
+		paths = append(paths, &oldkeyfiles[idx])
+	}
+	for _, path := range paths {
+		if *path == "" {
+			// unset paths keep their default
+			continue
+		}
+		abspath, err := filepath.Abs(*path)
+		if err != nil {
+			return srvcfg, err
+		}
+		*path = abspath
+	}
+	return srvcfg, nil
+}
+
 func main() {
 
 	// Initialize and read the default server config
@@ -141,6 +228,38 @@ func main() {
 		config.Set(srvcfg)
 	}
 
+	// Resolve paths, which jobs pass to commands running in other directories
+	srvcfg, err = absPaths(srvcfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[Error] resolving configured paths: %s\n", err.Error())
+		os.Exit(-1)
+	}
+	config.Set(srvcfg)
+
+	// Register the validators defined in the server config
+	err = validators.RegisterExternal(srvcfg.External)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[Error] registering external validators: %s\n", err.Error())
+		os.Exit(-1)
+	}
+
+	if args["migrate-tokens"] == true {
+		err = web.MigrateTokens()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[Error] migrating tokens: %s\n", err.Error())
+			os.Exit(-1)
+		}
+		os.Exit(0)
+	}
+	if args["reencrypt-tokens"] == true {
+		err = web.ReencryptTokens()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[Error] re-encrypting tokens: %s\n", err.Error())
+			os.Exit(-1)
+		}
+		os.Exit(0)
+	}
+
 	// TODO: Create missing directories defined in cfg
 
 	err = log.Init()
@@ -152,6 +271,12 @@ func main() {
 
 	startupCheck(srvcfg)
 
+	// Start the validation workers and restore jobs queued before a restart
+	web.StartQueue()
+
+	// Remove expired login sessions periodically
+	web.StartSessionSweeper()
+
 	// Log cli arguments
 	log.Write("[Warmup] cli arguments: %v\n", args)
 
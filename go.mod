@@ -17,6 +17,7 @@ require (
 	github.com/spf13/afero v1.8.2 // indirect
 	github.com/spf13/jwalterweatherman v1.1.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
+	go.etcd.io/bbolt v1.3.6
 	gopkg.in/yaml.v2 v2.2.8
 )
 
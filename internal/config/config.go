@@ -1,23 +1,46 @@
 package config
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
 )
 
-// Executables used by the server.
-type Executables struct {
-	BIDS string `json:"bids"`
-	NIX  string `json:"nix"`
-	ODML string `json:"odml"`
+// Executable is the command of a validator. "Path" is the executable on the
+// host or, if "Image" is set, the executable within the container image
+// "Image" that the validator runs in. "Version" is the command line that
+// prints the version of the validator; if it is not set, "Path" is run with
+// the argument "--version". In the configuration file, an executable on the
+// host can be given as a plain string.
+type Executable struct {
+	Path    string   `json:"path"`
+	Image   string   `json:"image"`
+	Version []string `json:"version"`
 }
 
+// UnmarshalJSON reads an executable from either a path or an object.
+func (e *Executable) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*e = Executable{Path: path}
+		return nil
+	}
+	type executable Executable
+	return json.Unmarshal(data, (*executable)(e))
+}
+
+// Executables used by the server, keyed by the name of the validator that
+// runs them.
+type Executables map[string]Executable
+
 // Directories used by the server for temporary and long term storage.
 type Directories struct {
-	Temp   string `json:"temp"`
-	Result string `json:"result"`
-	Log    string `json:"log"`
-	Tokens string `json:"tokens"`
+	Temp     string `json:"temp"`
+	Result   string `json:"result"`
+	Log      string `json:"log"`
+	Tokens   string `json:"tokens"`
+	Queue    string `json:"queue"`
+	Webhooks string `json:"webhooks"`
 }
 
 // Denotations provide any frequently used file names or other denotations
@@ -27,6 +50,7 @@ type Denotations struct {
 	ResultsFolder        string `json:"resultsfolder"`
 	ResultsFile          string `json:"resultsfile"`
 	ResultsBadge         string `json:"resultsbadge"`
+	ResultsReport        string `json:"resultsreport"`
 	ValidationConfigFile string `json:"valcfgfile"`
 }
 
@@ -36,67 +60,268 @@ type GINAddresses struct {
 }
 
 // Settings provide the default server settings.
-// "Validators" currently only supports "BIDS".
 type Settings struct {
-	RootURL     string   `json:"rooturl"`
-	Port        string   `json:"port"`
-	LogSize     int      `json:"logsize"`
-	GINUser     string   `json:"ginuser"`
-	GINPassword string   `json:"ginpassword"`
-	ClientID    string   `json:"clientid"`
-	HookSecret  string   `json:"hooksecret"`
-	CookieName  string   `json:"cookiename"`
-	Validators  []string `json:"validators"`
+	RootURL     string `json:"rooturl"`
+	Port        string `json:"port"`
+	LogSize     int    `json:"logsize"`
+	GINUser     string `json:"ginuser"`
+	GINPassword string `json:"ginpassword"`
+	ClientID    string `json:"clientid"`
+	HookSecret  string `json:"hooksecret"`
+	CookieName  string `json:"cookiename"`
+	// Validators lists the names of the enabled validators; each name must
+	// belong to a validator registered in the validators package.
+	Validators []string `json:"validators"`
+	// Workers is the number of validation jobs that run at the same time.
+	Workers int `json:"workers"`
+	// ValidatorWorkers optionally limits the number of concurrent jobs for
+	// individual validators.
+	ValidatorWorkers map[string]int `json:"validatorworkers"`
+	// ContentTimeout is the number of seconds a job waits for annexed
+	// content that is not yet available on the server, 30 minutes by
+	// default; 0 disables waiting.
+	ContentTimeout int `json:"contenttimeout"`
+	// ContentRetry is the number of seconds before the first new attempt to
+	// download missing content. The interval doubles after each attempt.
+	ContentRetry int `json:"contentretry"`
+	// CommitStatus enables posting the state of hook triggered validations
+	// as commit statuses to the GIN server.
+	CommitStatus bool `json:"commitstatus"`
+	// WebhookAttempts is the number of times a webhook delivery is
+	// attempted.
+	WebhookAttempts int `json:"webhookattempts"`
+	// WebhookRetry is the number of seconds before the first retry of a
+	// webhook delivery. The interval doubles after each attempt.
+	WebhookRetry int `json:"webhookretry"`
+	// WebhookAllowedNets lists networks in CIDR notation that webhooks may
+	// be delivered to even though they are private, loopback or link-local.
+	WebhookAllowedNets []string `json:"webhookallowednets"`
+	// Admins lists the GIN users that may revalidate all repositories, e.g.
+	// after a validator was upgraded.
+	Admins []string `json:"admins"`
+	// SessionLifetime is the number of hours after login at which a session
+	// expires.
+	SessionLifetime int `json:"sessionlifetime"`
+	// SessionIdle is the number of hours without requests after which a
+	// session expires earlier; 0 disables the idle expiry.
+	SessionIdle int `json:"sessionidle"`
+	// SessionSweep is the interval in minutes at which expired sessions are
+	// removed.
+	SessionSweep int `json:"sessionsweep"`
+	// LoginMode selects how users log in: "password" forwards the GIN
+	// credentials entered in the login form to GIN to create an access
+	// token, "oauth" redirects users to the authorization server configured
+	// in OAuth instead, so the service never sees their password.
+	LoginMode string `json:"loginmode"`
+	OAuth     OAuth  `json:"oauth"`
+}
+
+// OAuth configures the OAuth2 authorization code login with the client
+// registered for the service on the authorization server. The endpoints
+// default to the OAuth2 endpoints of the GIN server at GINAddresses.WebURL.
+// The username of a user is read from the "preferred_username" or "login"
+// field of the user info response. The access token is used for all requests
+// to GIN on behalf of the user, including the validations triggered by hooks.
+type OAuth struct {
+	ClientID     string   `json:"clientid"`
+	ClientSecret string   `json:"clientsecret"`
+	AuthURL      string   `json:"authurl"`
+	TokenURL     string   `json:"tokenurl"`
+	UserInfoURL  string   `json:"userinfourl"`
+	Scopes       []string `json:"scopes"`
+}
+
+// ExternalValidator defines a validator that runs an arbitrary executable and
+// is configured entirely in the server config.
+// "Args" are passed to the executable in order; the argument "{files}" is
+// replaced by the list of matched files and "{root}" within any argument is
+// replaced by the repository root. If no argument is "{files}", the files are
+// appended at the end.
+// "Patterns" are glob patterns selecting the files to validate. Patterns
+// containing a "/" are matched against the path relative to the repository
+// root, all others against the file name.
+// "ErrorPattern" and "WarningPattern" are regular expressions matched against
+// the validator output, "ErrorCodes" and "WarningCodes" are exit codes of the
+// executable that denote errors or warnings. Exit code 0 is always a success.
+type ExternalValidator struct {
+	Name           string   `json:"name"`
+	Title          string   `json:"title"`
+	Executable     string   `json:"executable"`
+	Args           []string `json:"args"`
+	Patterns       []string `json:"patterns"`
+	ErrorPattern   string   `json:"errorpattern"`
+	WarningPattern string   `json:"warningpattern"`
+	ErrorCodes     []int    `json:"errorcodes"`
+	WarningCodes   []int    `json:"warningcodes"`
+}
+
+// Notifications configure the emails sent when a hook triggered validation
+// finds errors. Emails are sent from "From" through the SMTP server at "Host"
+// and "Port", authenticating with "Username" and "Password" if a username is
+// set. They go to the owner of the repository and to the addresses listed for
+// the repository path in "Recipients". Notifications are disabled if no host
+// is set.
+type Notifications struct {
+	Host       string              `json:"host"`
+	Port       int                 `json:"port"`
+	Username   string              `json:"username"`
+	Password   string              `json:"password"`
+	From       string              `json:"from"`
+	Recipients map[string][]string `json:"recipients"`
+}
+
+// Limits restrict the resources of a single validator run. "Timeout" is the
+// wall-clock time in seconds after which the validator is stopped, "Memory"
+// the size of its virtual memory in MiB, "CPU" its processor time in seconds
+// and "Output" the number of bytes it may write to stdout and stderr each.
+// Memory and CPU limits are applied as resource limits (rlimits) of the
+// validator process where the operating system supports them. A limit of 0
+// disables it.
+type Limits struct {
+	Timeout int `json:"timeout"`
+	Memory  int `json:"memory"`
+	CPU     int `json:"cpu"`
+	Output  int `json:"output"`
+}
+
+// Sandbox selects how validators are executed. "Backend" is either "direct",
+// which runs validators as the server user, or "bwrap", which runs them with
+// the bubblewrap executable "Bwrap" in separate namespaces without network
+// access. Sandboxed validators see a read-only file system in which the
+// server directories, the configuration directories of the server user and
+// the paths listed in "Hide" are empty; the repository clone is the only
+// server directory they can read. Validators with a container image in
+// Executables run in a container of the image started with the container
+// runtime "Runtime", e.g. docker or podman, regardless of "Backend". If the
+// selected backend is not available, validators are run directly.
+type Sandbox struct {
+	Backend string   `json:"backend"`
+	Bwrap   string   `json:"bwrap"`
+	Runtime string   `json:"runtime"`
+	Hide    []string `json:"hide"`
+}
+
+// TokenStore selects where the GIN access tokens of users and the links of
+// login sessions and hooked repositories to them are stored. "Backend" is
+// either "files", which keeps them as files and symbolic links in
+// Dir.Tokens, or "bolt", which keeps them in the embedded database file
+// "Path", by default tokens.db in Dir.Tokens. The database is only opened
+// while it is accessed, so several servers on the same host can share it.
+// Tokens are encrypted with the key in "KeyFile", which is generated if it
+// does not exist and should not be readable by anyone but the server. To
+// rotate the key, list the current key file in "OldKeyFiles" and set a new
+// "KeyFile"; tokens are re-encrypted with the new key when they are read or
+// by the reencrypt-tokens command. An empty "KeyFile" stores tokens
+// unencrypted.
+type TokenStore struct {
+	Backend     string   `json:"backend"`
+	Path        string   `json:"path"`
+	KeyFile     string   `json:"keyfile"`
+	OldKeyFiles []string `json:"oldkeyfiles"`
 }
 
 // ServerCfg holds the config used to setup the gin validation server and
 // the paths to all required executables, temporary and permanent folders.
 type ServerCfg struct {
-	Settings     Settings     `json:"settings"`
-	Exec         Executables  `json:"executables"`
-	Dir          Directories  `json:"directories"`
-	Label        Denotations  `json:"denotations"`
-	GINAddresses GINAddresses `json:"ginaddresses"`
+	Settings     Settings            `json:"settings"`
+	Exec         Executables         `json:"executables"`
+	Dir          Directories         `json:"directories"`
+	Label        Denotations         `json:"denotations"`
+	GINAddresses GINAddresses        `json:"ginaddresses"`
+	External     []ExternalValidator `json:"externalvalidators"`
+	Notify       Notifications       `json:"notifications"`
+	// Limits apply to all validators; ValidatorLimits override individual
+	// limits for the validator with the given name.
+	Limits          Limits            `json:"limits"`
+	ValidatorLimits map[string]Limits `json:"validatorlimits"`
+	Sandbox         Sandbox           `json:"sandbox"`
+	TokenStore      TokenStore        `json:"tokenstore"`
 }
 
 var defaultCfg = ServerCfg{
 	Settings{
-		Port:        "3033",
-		LogSize:     1048576,
-		GINUser:     "ServiceWaiter",
-		GINPassword: "",
-		ClientID:    "gin-valid",
-		HookSecret:  "",
-		CookieName:  "gin-valid-session",
-		Validators:  []string{"bids", "nix", "odml"},
+		Port:            "3033",
+		LogSize:         1048576,
+		GINUser:         "ServiceWaiter",
+		GINPassword:     "",
+		ClientID:        "gin-valid",
+		HookSecret:      "",
+		CookieName:      "gin-valid-session",
+		Validators:      []string{"bids", "nix", "odml"},
+		Workers:         2,
+		ContentTimeout:  1800,
+		ContentRetry:    60,
+		CommitStatus:    true,
+		WebhookAttempts: 5,
+		WebhookRetry:    10,
+		SessionLifetime: 168,
+		SessionSweep:    60,
+		LoginMode:       "password",
+		OAuth:           OAuth{Scopes: []string{"openid", "profile"}},
 	},
 	Executables{
-		BIDS: "bids-validator",
-		NIX:  "nixio-validate",
-		ODML: "odml-validate",
+		"bids": {Path: "bids-validator"},
+		"nix": {
+			Path:    "nixio-validate",
+			Version: []string{"python3", "-c", "from importlib.metadata import version; print(version('nixio'))"},
+		},
+		"odml": {
+			Path:    "odml-validate",
+			Version: []string{"python3", "-c", "from importlib.metadata import version; print(version('odML'))"},
+		},
 	},
 	Directories{
-		Temp:   filepath.Join(os.Getenv("GINVALIDHOME"), "tmp"),
-		Log:    filepath.Join(os.Getenv("GINVALIDHOME"), "log"),
-		Result: filepath.Join(os.Getenv("GINVALIDHOME"), "results"),
-		Tokens: filepath.Join(os.Getenv("GINVALIDHOME"), "tokens"),
+		Temp:     filepath.Join(os.Getenv("GINVALIDHOME"), "tmp"),
+		Log:      filepath.Join(os.Getenv("GINVALIDHOME"), "log"),
+		Result:   filepath.Join(os.Getenv("GINVALIDHOME"), "results"),
+		Tokens:   filepath.Join(os.Getenv("GINVALIDHOME"), "tokens"),
+		Queue:    filepath.Join(os.Getenv("GINVALIDHOME"), "queue"),
+		Webhooks: filepath.Join(os.Getenv("GINVALIDHOME"), "webhooks"),
 	},
 	Denotations{
 		LogFile:              "ginvalid.log",
 		ResultsFolder:        "latest",
 		ResultsFile:          "results.json",
 		ResultsBadge:         "results.svg",
+		ResultsReport:        "report.json",
 		ValidationConfigFile: "ginvalidation.yaml",
 	},
 	GINAddresses{
 		WebURL: "https://gin.g-node.org:443",
 		GitURL: "git@gin.g-node.org:22",
 	},
+	nil,
+	Notifications{
+		Port: 25,
+		From: "gin-valid@g-node.org",
+	},
+	Limits{
+		Timeout: 3600,
+		Output:  64 * 1024 * 1024,
+	},
+	nil,
+	Sandbox{
+		Backend: "direct",
+		Bwrap:   "bwrap",
+		Runtime: "docker",
+	},
+	TokenStore{
+		Backend: "files",
+		KeyFile: filepath.Join(os.Getenv("GINVALIDHOME"), "tokens.key"),
+	},
 }
 
-// Read returns the default server configuration.
+// Read returns the server configuration. The executables are copied, so that
+// callers cannot change the configuration by modifying them.
 func Read() ServerCfg {
-	return defaultCfg
+	cfg := defaultCfg
+	if defaultCfg.Exec != nil {
+		cfg.Exec = make(Executables, len(defaultCfg.Exec))
+		for name, executable := range defaultCfg.Exec {
+			cfg.Exec[name] = executable
+		}
+	}
+	return cfg
 }
 
 // Set sets the server configuration.
@@ -5,6 +5,7 @@ import (
 
 	"github.com/G-Node/gin-valid/internal/config"
 	"github.com/G-Node/gin-valid/internal/log"
+	"github.com/G-Node/gin-valid/internal/validators"
 )
 
 // ValidDirectory checks whether a given path exists and refers to a valid directory.
@@ -22,11 +23,15 @@ func ValidDirectory(path string) bool {
 }
 
 // SupportedValidator checks whether a string matches
-// Validators supported by the server.
+// Validators supported by the server. A validator is supported if it is
+// registered and enabled in the server configuration.
 func SupportedValidator(validator string) bool {
-	validators := config.Read().Settings.Validators
+	if _, ok := validators.Get(validator); !ok {
+		return false
+	}
+	enabled := config.Read().Settings.Validators
 
-	for _, val := range validators {
+	for _, val := range enabled {
 		if val == validator {
 			return true
 		}
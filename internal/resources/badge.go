@@ -0,0 +1,84 @@
+package resources
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Colours of generated badges, matching the fixed badges.
+const (
+	BadgeLabelColor  = "#555"
+	BadgeSuccess     = "#4c1"
+	BadgeWarning     = "#dfb317"
+	BadgeError       = "#cb2431"
+	BadgeInactive    = "#9f9f9f"
+	BadgeTimeout     = "#fe7d37"
+	BadgeProgressing = "#007ec6"
+)
+
+// Badge styles supported by Badge.
+const (
+	BadgeFlat       = "flat"
+	BadgeFlatSquare = "flat-square"
+)
+
+// textWidth approximates the width in pixels of 'text' set in 11px Verdana,
+// the font used by the badges.
+func textWidth(text string) int {
+	var width float64
+	for _, c := range text {
+		switch {
+		case strings.ContainsRune("ijl.,:;|!' ", c):
+			width += 3.5
+		case strings.ContainsRune("fIrt()[]/-", c):
+			width += 4.5
+		case strings.ContainsRune("mwMW", c):
+			width += 10
+		case c >= 'A' && c <= 'Z':
+			width += 7.5
+		default:
+			width += 6.5
+		}
+	}
+	return int(width + 0.5)
+}
+
+// Badge returns an SVG badge in the style of the fixed badges showing 'label'
+// on a grey background and 'message' on a background of colour 'color'.
+// 'style' is either BadgeFlat, the default, or BadgeFlatSquare.
+func Badge(label, message, color, style string) string {
+	labelwidth := textWidth(label) + 10
+	msgwidth := textWidth(message) + 10
+	width := labelwidth + msgwidth
+	radius, gradient := "4", `<path fill="url(#b)" d="M0 0 h%[1]d v20 H0 z"/>`
+	if style == BadgeFlatSquare {
+		radius, gradient = "0", ""
+	}
+	if gradient != "" {
+		gradient = fmt.Sprintf(gradient, width)
+	}
+	label = html.EscapeString(label)
+	message = html.EscapeString(message)
+
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="%d" height="20">`, width)
+	svg.WriteString(`<linearGradient id="b" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`)
+	fmt.Fprintf(&svg, `<clipPath id="a"><rect width="%d" height="20" rx="%s" fill="#fff"/></clipPath>`, width, radius)
+	fmt.Fprintf(&svg, `<g clip-path="url(#a)"><path fill="%s" d="M0 0 h%d v20 H0 z"/><path fill="%s" d="M%d 0 h%d v20 H%d z"/>%s</g>`,
+		BadgeLabelColor, labelwidth, html.EscapeString(color), labelwidth, msgwidth, labelwidth, gradient)
+	svg.WriteString(`<g fill="#fff" text-anchor="middle" font-family="DejaVu Sans,Verdana,Geneva,sans-serif" font-size="110">`)
+	for _, part := range []struct {
+		text   string
+		center int
+		length int
+	}{
+		{label, labelwidth * 5, (labelwidth - 10) * 10},
+		{message, (labelwidth*2 + msgwidth) * 5, (msgwidth - 10) * 10},
+	} {
+		fmt.Fprintf(&svg, `<text x="%d" y="150" fill="#010101" fill-opacity=".3" transform="scale(.1)" textLength="%d">%s</text>`, part.center, part.length, part.text)
+		fmt.Fprintf(&svg, `<text x="%d" y="140" transform="scale(.1)" textLength="%d">%s</text>`, part.center, part.length, part.text)
+	}
+	svg.WriteString(`</g></svg>`)
+	return svg.String()
+}
@@ -17,3 +17,7 @@ const ProcessingBadge = `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="ht
 
 // FailureBadge contains the svg corresponding to a failure to run the validator
 const FailureBadge = `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="170" height="20"><linearGradient id="b" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient><clipPath id="a"><rect width="170" height="20" rx="4" fill="#fff"/></clipPath><g clip-path="url(#a)"><path fill="#555" d="M0 0 h83 v20 H0 z"/><path fill="#9f9f9f" d="M83 0 h87 v20 H83 z"/><path fill="url(#b)" d="M0 0 h170 v20 H0 z"/></g><g fill="#fff" text-anchor="middle" font-family="DejaVu Sans,Verdana,Geneva,sans-serif" font-size="115"><text x="400" y="150" fill="#010101" fill-opacity=".3" transform="scale(.1)" textLength="750">validation</text><text x="400" y="140" transform="scale(.1)" textLength="750">validation</text><text x="1250" y="150" fill="#010101" fill-opacity=".3" transform="scale(.1)" textLength="750">failure</text><text x="1250" y="140" transform="scale(.1)" textLength="750">failure</text></g></svg>`
+
+// TimeoutBadge contains the svg corresponding to a validator that was stopped
+// after exceeding its time limit
+const TimeoutBadge = `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="170" height="20"><linearGradient id="b" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient><clipPath id="a"><rect width="170" height="20" rx="4" fill="#fff"/></clipPath><g clip-path="url(#a)"><path fill="#555" d="M0 0 h83 v20 H0 z"/><path fill="#fe7d37" d="M83 0 h87 v20 H83 z"/><path fill="url(#b)" d="M0 0 h170 v20 H0 z"/></g><g fill="#fff" text-anchor="middle" font-family="DejaVu Sans,Verdana,Geneva,sans-serif" font-size="115"><text x="400" y="150" fill="#010101" fill-opacity=".3" transform="scale(.1)" textLength="750">validation</text><text x="400" y="140" transform="scale(.1)" textLength="750">validation</text><text x="1250" y="150" fill="#010101" fill-opacity=".3" transform="scale(.1)" textLength="750">timed out</text><text x="1250" y="140" transform="scale(.1)" textLength="750">timed out</text></g></svg>`
@@ -0,0 +1,56 @@
+package templates
+
+// Admin lists the enabled validators with the versions recorded for their
+// reports and lets administrators revalidate all hooked repositories.
+const Admin = `
+{{define "content"}}
+	<div class="repository file list">
+		<div class="header-wrapper">
+			<div class="ui container">
+				<div class="ui vertically padded grid head">
+					<div class="column">
+						<div class="ui header">
+							<div class="ui huge breadcrumb">
+								<i class="mega-octicon octicon-tools"></i>
+								Validators
+							</div>
+						</div>
+					</div>
+				</div>
+			</div>
+			<div class="ui tabs container">
+			</div>
+			<div class="ui tabs divider"></div>
+		</div>
+		<div class="ui container">
+			{{if .Message}}<div class="ui info message">{{.Message}}</div>{{end}}
+			<table class="ui unstackable very basic table">
+				<thead>
+					<tr><th>Validator</th><th>Version</th><th></th></tr>
+				</thead>
+				<tbody>
+				{{range $val := .Validators}}
+					<tr>
+						<td>{{$val.Name}}</td>
+						<td>{{if $val.Version}}{{$val.Version}}{{else}}unknown{{end}}</td>
+						<td>
+							<form class="ui form" action="/admin/revalidate" method="post">
+								<input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+								<input type="hidden" name="validator" value="{{$val.Name}}">
+								<button class="ui mini basic button">REVALIDATE ALL REPOSITORIES</button>
+							</form>
+						</td>
+					</tr>
+				{{end}}
+				</tbody>
+			</table>
+			<form class="ui form" action="/admin/revalidate" method="post">
+				<input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+				<input type="hidden" name="validator" value="all">
+				<button class="ui basic button">Revalidate all repositories with all validators</button>
+			</form>
+			<p>Revalidating checks the versions of the validators again and validates the latest validated commit of every repository with an active hook.</p>
+		</div>
+	</div>
+{{end}}
+`
@@ -0,0 +1,59 @@
+package templates
+
+// Compare lists the issues added, resolved and unchanged between two
+// validation runs of a repository.
+const Compare = `
+{{define "issues"}}
+			<table class="ui very basic table">
+				<thead>
+					<tr><th>Severity</th><th>Code</th><th>File</th><th>Message</th></tr>
+				</thead>
+				<tbody>
+				{{range $issue := .}}
+					<tr>
+						<td>{{$issue.Severity}}</td>
+						<td>{{$issue.Code}}</td>
+						<td>{{$issue.File}}</td>
+						<td>{{$issue.Message}}</td>
+					</tr>
+				{{else}}
+					<tr><td colspan="4">None</td></tr>
+				{{end}}
+				</tbody>
+			</table>
+{{end}}
+{{define "content"}}
+	<div class="repository file list">
+		<div class="header-wrapper">
+			<div class="ui container">
+				<div class="ui vertically padded grid head">
+					<div class="column">
+						<div class="ui header">
+							<div class="ui huge breadcrumb">
+								<i class="mega-octicon octicon-repo"></i>
+								{{.Header}}
+							</div>
+						</div>
+					</div>
+				</div>
+			</div>
+			<div class="ui tabs container">
+			</div>
+			<div class="ui tabs divider"></div>
+		</div>
+		<div class="ui container">
+			<div>
+				From <a href="/results/{{.Validator}}/{{.Repository}}/{{.Base.ID}}">{{.Base.ID}}</a> ({{.Base.Errors}} errors, {{.Base.Warnings}} warnings)
+				to <a href="/results/{{.Validator}}/{{.Repository}}/{{.Head.ID}}">{{.Head.ID}}</a> ({{.Head.Errors}} errors, {{.Head.Warnings}} warnings)
+			</div>
+			<hr>
+			<h3>Added issues ({{len .Added}})</h3>
+			{{template "issues" .Added}}
+			<h3>Resolved issues ({{len .Resolved}})</h3>
+			{{template "issues" .Resolved}}
+			<h3>Unchanged issues ({{len .Unchanged}})</h3>
+			{{template "issues" .Unchanged}}
+		</div>
+	</div>
+{{end}}
+`
@@ -0,0 +1,52 @@
+package templates
+
+// History lists all validation runs of a repository with links to their
+// results.
+const History = `
+{{define "content"}}
+	<div class="repository file list">
+		<div class="header-wrapper">
+			<div class="ui container">
+				<div class="ui vertically padded grid head">
+					<div class="column">
+						<div class="ui header">
+							<div class="ui huge breadcrumb">
+								<i class="mega-octicon octicon-repo"></i>
+								{{.Header}}
+							</div>
+						</div>
+					</div>
+				</div>
+			</div>
+			<div class="ui tabs container">
+			</div>
+			<div class="ui tabs divider"></div>
+		</div>
+		<div class="ui container">
+			<table class="ui unstackable very basic table">
+				<thead>
+					<tr><th>Date</th><th>Commit</th><th>Validator version</th><th>Result</th><th>Errors</th><th>Warnings</th><th>Trend</th></tr>
+				</thead>
+				<tbody>
+				{{range $run := .Runs}}
+					<tr>
+						<td><a href="/results/{{$.Validator}}/{{$.Repository}}/{{$run.ID}}">{{$run.Date.Format "2006-01-02 15:04:05 MST"}}</a>{{if $run.Latest}} (latest){{end}}</td>
+						<td>{{if $run.Commit}}{{$run.Commit}}{{else}}{{$run.ID}}{{end}}</td>
+						<td>{{$run.Version}}</td>
+						<td>{{$run.Badge}}</td>
+						<td>{{if eq $run.State "done"}}{{$run.Errors}}{{end}}</td>
+						<td>{{if eq $run.State "done"}}{{$run.Warnings}}{{end}}</td>
+						<td>
+							{{if eq $run.Trend "better"}}&#9650; better{{else if eq $run.Trend "worse"}}&#9660; worse{{else}}{{$run.Trend}}{{end}}
+							{{if $run.Previous}}(<a href="/compare/{{$.Validator}}/{{$.Repository}}/{{$run.Previous}}/{{$run.ID}}">changes</a>){{end}}
+						</td>
+					</tr>
+				{{else}}
+					<tr><td colspan="7">No validation results available</td></tr>
+				{{end}}
+				</tbody>
+			</table>
+		</div>
+	</div>
+{{end}}
+`
@@ -1,7 +1,18 @@
 package templates
 
+import "html/template"
+
 // TODO: Switch Login || Logout
 
+// LayoutFuncs returns the functions used by the Layout template, which
+// provide the CSRF token of the session a page is rendered for to the logout
+// form. Pages rendered without a session use an empty token.
+func LayoutFuncs(csrftoken string) template.FuncMap {
+	return template.FuncMap{
+		"CSRFToken": func() string { return csrftoken },
+	}
+}
+
 // Layout is the main site template. It includes the header and footer and
 // embeds the content for every other page.
 var Layout = `
@@ -36,6 +47,11 @@ var Layout = `
 								<a class="item" href="/repos">Repositories</a>
 								<a class="item" href="/pubvalidate">One-time validation</a>
 								<a class="item" href="/login">Login</a>
+								<a class="item" href="/sessions">Sessions</a>
+								<form class="item" action="/logout" method="post">
+									<input type="hidden" name="csrf_token" value="{{ CSRFToken }}">
+									<button class="ui basic button" type="submit">Logout</button>
+								</form>
 							</div>
 						</div>
 					</div>
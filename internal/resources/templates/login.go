@@ -6,6 +6,15 @@ var Login = `
 			<div class="user signin">
 				<div class="ui middle very relaxed page grid">
 					<div class="column">
+					{{if .OAuth}}
+						<h3 class="ui top attached header">
+							Sign In using your GIN account
+						</h3>
+						<div class="ui attached segment">
+							<p>You will be asked on GIN to allow the validation service to access your repositories.</p>
+							<a class="ui green button" href="/oauth/login">Sign In with GIN</a>
+						</div>
+					{{else}}
 						<form class="ui form" action="/login" method="post">
 							<input type="hidden" name="_csrf" value="">
 							<h3 class="ui top attached header">
@@ -26,6 +35,7 @@ var Login = `
 								</div>
 							</div>
 						</form>
+					{{end}}
 					</div>
 				</div>
 			</div>
@@ -0,0 +1,29 @@
+package templates
+
+// NotificationMail is the plain text body of the email sent when a hook
+// triggered validation of a repository finds errors or fails to run.
+const NotificationMail = `Hello,
+
+{{if .Failed -}}
+the {{.Title}} validator failed to run on commit {{.Commit}} of the repository {{.Repository}}.
+{{- else -}}
+the {{.Title}} validation of commit {{.Commit}} of the repository {{.Repository}} found {{.Errors}} errors and {{.Warnings}} warnings.
+{{- end}}
+{{- if .WasPassing}}
+The previous validation of the repository passed.
+{{- end}}
+{{if .Issues}}
+Errors:
+{{range $issue := .Issues}}
+  - {{if $issue.File}}{{$issue.File}}: {{end}}{{$issue.Message}}{{if $issue.Code}} ({{$issue.Code}}){{end}}
+{{- end}}
+{{- if .MoreIssues}}
+  ... and {{.MoreIssues}} more
+{{- end}}
+{{end}}
+The full results are available at
+{{.URL}}
+
+--
+This email was sent by the GIN validation service.
+`
@@ -85,16 +85,77 @@ const RepoPage = `
 						<tr>
 							<td class="name text bold four wide"><a href="">{{$hookname | ToUpper}}</a></td>
 							{{if eq $hook.State 0}}
-								<td class="name nine wide"><a href="/results/{{$hookname | ToLower}}/{{$.FullName}}">RESULTS</a></td>
-								<td class="name three wide"><a href="/repos/{{$.FullName}}/{{$hook.ID}}/disable">DEACTIVATE</a></td>
+								<td class="name nine wide">
+									<a href="/results/{{$hookname | ToLower}}/{{$.FullName}}">RESULTS</a>
+									| <a href="/history/{{$hookname | ToLower}}/{{$.FullName}}">HISTORY</a>
+									<form class="ui form" style="display: inline" action="/repos/{{$.FullName}}/{{$hookname | ToLower}}/revalidate" method="post">
+										<input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+										| <button class="ui mini basic button">REVALIDATE</button>
+									</form>
+								</td>
+								<td class="name three wide">
+									<form class="ui form" style="display: inline" action="/repos/{{$.FullName}}/{{$hook.ID}}/disable" method="post">
+										<input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+										<button class="ui mini basic button">DEACTIVATE</button>
+									</form>
+								</td>
 							{{else}}
 								<td class="name nine wide">N/A</td>
-								<td class="name three wide"><a href="/repos/{{$.FullName}}/{{$hookname | ToLower}}/enable">ACTIVATE</a></td>
+								<td class="name three wide">
+									<form class="ui form" style="display: inline" action="/repos/{{$.FullName}}/{{$hookname | ToLower}}/enable" method="post">
+										<input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+										<button class="ui mini basic button">ACTIVATE</button>
+									</form>
+								</td>
 							{{end}}
 						</tr>
 					{{end}}
 				</tbody>
 			</table>
+			{{if .IsOwner}}
+				<h4 class="ui top attached header">Webhooks</h4>
+				<div class="ui attached segment">
+					<p>Webhooks receive a signed JSON payload when a validation of this repository finishes. The X-GinValid-Signature header holds the hex encoded HMAC-SHA256 of the payload, keyed with the secret of the webhook.</p>
+					<table class="ui unstackable fixed single line table">
+						<tbody>
+							{{range .Webhooks}}
+								<tr>
+									<td class="name thirteen wide">{{.URL}}</td>
+									<td class="name three wide">
+										<form class="ui form" style="display: inline" action="/repos/{{$.FullName}}/webhooks/{{.ID}}/remove" method="post">
+											<input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+											<button class="ui mini basic button">REMOVE</button>
+										</form>
+									</td>
+								</tr>
+							{{end}}
+						</tbody>
+					</table>
+					<form class="ui form" action="/repos/{{.FullName}}/webhooks" method="post">
+						<input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+						<div class="inline fields">
+							<div class="field"><input name="url" type="url" placeholder="https://example.org/hook" required></div>
+							<div class="field"><input name="secret" type="password" placeholder="Secret"></div>
+							<button class="ui green button">Add webhook</button>
+						</div>
+					</form>
+				</div>
+				{{if .Deliveries}}
+					<h4 class="ui top attached header">Recent deliveries</h4>
+					<table class="ui attached unstackable fixed single line table">
+						<tbody>
+							{{range .Deliveries}}
+								<tr>
+									<td class="name four wide">{{.Date.Format "2006-01-02 15:04:05"}}</td>
+									<td class="name six wide">{{.URL}}</td>
+									<td class="name two wide">{{.Validator | ToUpper}}</td>
+									<td class="name four wide">{{if .Delivered}}delivered{{else}}failed{{end}} after {{.Attempts}} attempts{{if .Error}}: {{.Error}}{{end}}</td>
+								</tr>
+							{{end}}
+						</tbody>
+					</table>
+				{{end}}
+			{{end}}
 		</div>
 	</div>
 {{end}}
@@ -0,0 +1,50 @@
+package templates
+
+// Sessions lists the active login sessions of a user and lets the user
+// revoke them.
+const Sessions = `
+{{define "content"}}
+	<div class="repository file list">
+		<div class="header-wrapper">
+			<div class="ui container">
+				<div class="ui vertically padded grid head">
+					<div class="column">
+						<div class="ui header">
+							<div class="ui huge breadcrumb">
+								<i class="mega-octicon octicon-key"></i>
+								Sessions of {{.Username}}
+							</div>
+						</div>
+					</div>
+				</div>
+			</div>
+			<div class="ui tabs container">
+			</div>
+			<div class="ui tabs divider"></div>
+		</div>
+		<div class="ui container">
+			<table class="ui unstackable very basic table">
+				<thead>
+					<tr><th>Logged in</th><th>Last seen</th><th>Expires</th><th></th></tr>
+				</thead>
+				<tbody>
+				{{range $s := .Sessions}}
+					<tr>
+						<td>{{$s.Created.Format "2006-01-02 15:04 MST"}}</td>
+						<td>{{$s.LastSeen.Format "2006-01-02 15:04 MST"}}</td>
+						<td>{{$s.Expires.Format "2006-01-02 15:04 MST"}}</td>
+						<td>
+							<form class="ui form" action="/sessions/{{$s.Key}}/revoke" method="post">
+								<input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+								<button class="ui mini basic button">{{if $s.Current}}LOG OUT{{else}}REVOKE{{end}}</button>
+							</form>
+						</td>
+					</tr>
+				{{end}}
+				</tbody>
+			</table>
+			<p>Revoking a session logs out the browser it belongs to.</p>
+		</div>
+	</div>
+{{end}}
+`
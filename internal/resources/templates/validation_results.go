@@ -0,0 +1,68 @@
+package templates
+
+// ValidationResults renders the report of a validation run. It requires a
+// header text, a badge, the report, and optionally a list of details and the
+// plain text output of the validator.
+const ValidationResults = `
+{{define "content"}}
+	<div class="repository file list">
+		<div class="header-wrapper">
+			<div class="ui container">
+				<div class="ui vertically padded grid head">
+					<div class="column">
+						<div class="ui header">
+							<div class="ui huge breadcrumb">
+								<i class="mega-octicon octicon-repo"></i>
+								{{.Header}}
+								{{.Badge}}
+							</div>
+						</div>
+					</div>
+				</div>
+			</div>
+			<div class="ui tabs container">
+			</div>
+			<div class="ui tabs divider"></div>
+		</div>
+		<div class="ui container">
+			<div>
+				{{.Report.Errors}} errors, {{.Report.Warnings}} warnings
+				{{if .Report.Files}}in {{.Report.Files}} validated paths{{end}}
+			</div>
+			{{if .Report.Commit}}<div>Commit: {{.Report.Commit}}</div>{{end}}
+			{{if .Report.Version}}<div>Validator version: {{.Report.Version}}</div>{{end}}
+			{{if not .Report.Finished.IsZero}}<div>Finished: {{.Report.Finished.Format "2006-01-02 15:04:05 MST"}} ({{printf "%.1f" .Report.Duration}} s)</div>{{end}}
+			{{if .Report.Issues}}
+			<table class="ui very basic table">
+				<thead>
+					<tr><th>Severity</th><th>Code</th><th>File</th><th>Message</th></tr>
+				</thead>
+				<tbody>
+				{{range $issue := .Report.Issues}}
+					<tr class="{{if eq $issue.Severity "error"}}negative{{else if eq $issue.Severity "warning"}}warning{{end}}">
+						<td>{{$issue.Severity}}</td>
+						<td>{{$issue.Code}}</td>
+						<td>{{$issue.File}}</td>
+						<td>{{$issue.Message}}</td>
+					</tr>
+				{{end}}
+				</tbody>
+			</table>
+			{{end}}
+			{{if .Details}}
+			<hr>
+			<div>Summary</div>
+			{{range $line := .Details}}
+			<div>{{$line}}</div>
+			{{end}}
+			{{end}}
+			{{if .Output}}
+			<hr>
+			<div>
+				<pre>{{.Output}}</pre>
+			</div>
+			{{end}}
+		</div>
+	</div>
+{{end}}
+`
@@ -0,0 +1,242 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/G-Node/gin-valid/internal/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// lockTimeout is the time to wait for another process to release the
+// database.
+const lockTimeout = 10 * time.Second
+
+// Buckets of the token database. Tokens are stored gob encoded by username,
+// sessions map to their JSON encoded record and repositories to a username.
+var (
+	tokensBucket   = []byte("tokens")
+	sessionsBucket = []byte("sessions")
+	reposBucket    = []byte("repos")
+)
+
+// Bolt stores tokens encrypted with Keys and links in the bbolt database
+// file at Path. The database is opened for every operation and closed again,
+// since only one process can hold it open at a time.
+type Bolt struct {
+	Path string
+	Keys *Keyring
+}
+
+// update runs 'fn' in a read-write transaction in which all buckets exist.
+func (db Bolt) update(fn func(tx *bolt.Tx) error) error {
+	if err := os.MkdirAll(filepath.Dir(db.Path), 0700); err != nil {
+		return err
+	}
+	bdb, err := bolt.Open(db.Path, 0600, &bolt.Options{Timeout: lockTimeout})
+	if err != nil {
+		return err
+	}
+	defer bdb.Close()
+	return bdb.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{tokensBucket, sessionsBucket, reposBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return fn(tx)
+	})
+}
+
+// view runs 'fn' in a read-only transaction. Buckets that were never written
+// to are nil.
+func (db Bolt) view(fn func(tx *bolt.Tx) error) error {
+	if _, err := os.Stat(db.Path); err != nil {
+		// a read-only open would fail on a missing database
+		return fn(nil)
+	}
+	bdb, err := bolt.Open(db.Path, 0600, &bolt.Options{Timeout: lockTimeout, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer bdb.Close()
+	return bdb.View(fn)
+}
+
+// bucket returns the bucket of a read-only transaction or nil if it does not
+// exist.
+func bucket(tx *bolt.Tx, name []byte) *bolt.Bucket {
+	if tx == nil {
+		return nil
+	}
+	return tx.Bucket(name)
+}
+
+func (db Bolt) SaveToken(ut UserToken) error {
+	data, err := db.Keys.encodeToken(ut)
+	if err != nil {
+		return err
+	}
+	return db.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(tokensBucket).Put([]byte(ut.Username), data)
+	})
+}
+
+func (db Bolt) Token(username string) (UserToken, error) {
+	return db.linkedToken(nil, username)
+}
+
+func (db Bolt) SaveSession(s Session) error {
+	data, err := encodeSession(s)
+	if err != nil {
+		return err
+	}
+	return db.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Put([]byte(s.ID), data)
+	})
+}
+
+func (db Bolt) Session(sessionid string) (Session, error) {
+	var s Session
+	err := db.view(func(tx *bolt.Tx) error {
+		b := bucket(tx, sessionsBucket)
+		if b == nil || b.Get([]byte(sessionid)) == nil {
+			return fmt.Errorf("no session %q", sessionid)
+		}
+		var err error
+		s, err = decodeSession(sessionid, b.Get([]byte(sessionid)))
+		return err
+	})
+	return s, err
+}
+
+func (db Bolt) RemoveSession(sessionid string) error {
+	return db.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(sessionsBucket)
+		if b.Get([]byte(sessionid)) == nil {
+			return fmt.Errorf("no session %q", sessionid)
+		}
+		return b.Delete([]byte(sessionid))
+	})
+}
+
+func (db Bolt) SessionToken(sessionid string) (UserToken, error) {
+	return db.linkedToken(sessionsBucket, sessionid)
+}
+
+func (db Bolt) LinkRepo(username, repopath string) error {
+	return db.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(reposBucket).Put([]byte(repopath), []byte(username))
+	})
+}
+
+func (db Bolt) RepoToken(repopath string) (UserToken, error) {
+	return db.linkedToken(reposBucket, repopath)
+}
+
+func (db Bolt) UnlinkRepo(repopath string) error {
+	return db.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(reposBucket)
+		if b.Get([]byte(repopath)) == nil {
+			return fmt.Errorf("no token linked to repository %q", repopath)
+		}
+		return b.Delete([]byte(repopath))
+	})
+}
+
+func (db Bolt) Users() ([]string, error) {
+	users := make([]string, 0)
+	err := db.view(func(tx *bolt.Tx) error {
+		b := bucket(tx, tokensBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			users = append(users, string(k))
+			return nil
+		})
+	})
+	return users, err
+}
+
+func (db Bolt) Sessions() ([]Session, error) {
+	sessions := make([]Session, 0)
+	err := db.view(func(tx *bolt.Tx) error {
+		b := bucket(tx, sessionsBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			s, err := decodeSession(string(k), v)
+			if err != nil {
+				log.Write("[Warning] ignoring invalid session record: %s", err.Error())
+				return nil
+			}
+			sessions = append(sessions, s)
+			return nil
+		})
+	})
+	return sessions, err
+}
+
+func (db Bolt) Repos() (map[string]string, error) {
+	return db.links(reposBucket)
+}
+
+// linkedToken returns the token of the user linked to 'key' in the bucket
+// 'links' or, if 'links' is nil, the token of the user named 'key'. Tokens
+// that are not encrypted with the current key are saved again.
+func (db Bolt) linkedToken(links []byte, key string) (UserToken, error) {
+	ut := UserToken{}
+	var stale bool
+	err := db.view(func(tx *bolt.Tx) error {
+		username := []byte(key)
+		if links != nil {
+			b := bucket(tx, links)
+			if b == nil || b.Get(username) == nil {
+				return fmt.Errorf("no token linked to %q", key)
+			}
+			username = b.Get(username)
+			if bytes.Equal(links, sessionsBucket) {
+				s, err := decodeSession(key, username)
+				if err != nil {
+					return err
+				}
+				username = []byte(s.Username)
+			}
+		}
+		b := bucket(tx, tokensBucket)
+		if b == nil || b.Get(username) == nil {
+			return fmt.Errorf("no token for user %q", username)
+		}
+		var err error
+		ut, stale, err = db.Keys.decodeToken(b.Get(username), string(username))
+		return err
+	})
+	if err == nil && stale {
+		// the read-only database is closed again at this point
+		if err := db.SaveToken(ut); err != nil {
+			log.ShowWrite("[Error] re-encrypting token of user %q: %s", ut.Username, err.Error())
+		}
+	}
+	return ut, err
+}
+
+// links returns the usernames linked to all keys in a bucket.
+func (db Bolt) links(name []byte) (map[string]string, error) {
+	links := make(map[string]string)
+	err := db.view(func(tx *bolt.Tx) error {
+		b := bucket(tx, name)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			links[string(k)] = string(v)
+			return nil
+		})
+	})
+	return links, err
+}
@@ -0,0 +1,204 @@
+package store
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// keySize is the size of token keys in bytes, selecting AES-256.
+const keySize = 32
+
+// sealedMagic starts every encrypted token, followed by the ID of the key it
+// was encrypted with, the nonce and the ciphertext. The magic and the key of
+// the record holding the token, e.g. the username, are authenticated with the
+// ciphertext, so that a token cannot be moved to another record. Tokens
+// without it are legacy plaintext gob data.
+var sealedMagic = []byte("GVTK2")
+
+// legacyMagic starts tokens that were encrypted before the record key was
+// authenticated. They are read like tokens encrypted with an old key.
+var legacyMagic = []byte("GVTK1")
+
+// keyIDSize is the number of bytes of the key hash used as key ID.
+const keyIDSize = 8
+
+// tokenKey is a key for encrypting tokens.
+type tokenKey struct {
+	id   []byte
+	aead cipher.AEAD
+}
+
+// Keyring encrypts tokens with its current key and decrypts tokens encrypted
+// with the current or any of the old keys. A nil keyring leaves tokens
+// unencrypted.
+type Keyring struct {
+	current tokenKey
+	old     []tokenKey
+}
+
+// LoadKeyring reads the current key from 'keyfile' and the old keys from
+// 'oldkeyfiles'. If 'generate' is set, a new current key is generated if the
+// file does not exist. Tokens encrypted with a lost key cannot be read again,
+// so keys should only be generated when the server is set up.
+func LoadKeyring(keyfile string, oldkeyfiles []string, generate bool) (*Keyring, error) {
+	current, err := readKey(keyfile, generate)
+	if err != nil {
+		return nil, err
+	}
+	kr := &Keyring{current: current}
+	for _, path := range oldkeyfiles {
+		old, err := readKey(path, false)
+		if err != nil {
+			return nil, err
+		}
+		kr.old = append(kr.old, old)
+	}
+	return kr, nil
+}
+
+// readKey reads a base64 encoded key from a file. If 'generate' is set and
+// the file does not exist, a new random key is written to it first.
+func readKey(path string, generate bool) (tokenKey, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && generate {
+		if err = generateKey(path); err != nil {
+			return tokenKey{}, fmt.Errorf("generating token key %q: %s", path, err.Error())
+		}
+		content, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return tokenKey{}, fmt.Errorf("reading token key: %s", err.Error())
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil || len(raw) != keySize {
+		return tokenKey{}, fmt.Errorf("invalid token key %q: expected %d base64 encoded bytes", path, keySize)
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return tokenKey{}, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return tokenKey{}, err
+	}
+	hash := sha256.Sum256(raw)
+	return tokenKey{id: hash[:keyIDSize], aead: aead}, nil
+}
+
+// generateKey writes a new random key to 'path'. An existing file, e.g.
+// written by another server at the same time, is left unchanged.
+func generateKey(path string) error {
+	raw := make([]byte, keySize)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	keyfile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer keyfile.Close()
+	_, err = keyfile.WriteString(base64.StdEncoding.EncodeToString(raw) + "\n")
+	return err
+}
+
+// Seal encrypts an encoded token or another secret with the current key for
+// the record with the key 'record'.
+func (kr *Keyring) Seal(plain []byte, record string) ([]byte, error) {
+	if kr == nil {
+		return plain, nil
+	}
+	nonce := make([]byte, kr.current.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	sealed := append(append(append([]byte{}, sealedMagic...), kr.current.id...), nonce...)
+	return kr.current.aead.Seal(sealed, nonce, plain, recordData(record)), nil
+}
+
+// Open decrypts a token or secret stored in the record with the key 'record'.
+// It also returns whether it should be stored again because it is
+// unencrypted or encrypted with an old key or format.
+func (kr *Keyring) Open(data []byte, record string) ([]byte, bool, error) {
+	var aad []byte
+	legacy := false
+	switch {
+	case bytes.HasPrefix(data, sealedMagic):
+		aad = recordData(record)
+	case bytes.HasPrefix(data, legacyMagic):
+		aad = legacyMagic
+		legacy = true
+	default:
+		return data, kr != nil, nil
+	}
+	if kr == nil {
+		return nil, false, fmt.Errorf("token is encrypted but no token key is configured")
+	}
+	data = data[len(sealedMagic):]
+	if len(data) < keyIDSize {
+		return nil, false, fmt.Errorf("invalid encrypted token")
+	}
+	id, data := data[:keyIDSize], data[keyIDSize:]
+	for idx, key := range append([]tokenKey{kr.current}, kr.old...) {
+		if !bytes.Equal(id, key.id) {
+			continue
+		}
+		size := key.aead.NonceSize()
+		if len(data) < size {
+			return nil, false, fmt.Errorf("invalid encrypted token")
+		}
+		plain, err := key.aead.Open(nil, data[:size], data[size:], aad)
+		if err != nil {
+			return nil, false, fmt.Errorf("decrypting token: %s", err.Error())
+		}
+		return plain, legacy || idx > 0, nil
+	}
+	return nil, false, fmt.Errorf("token is encrypted with an unknown key")
+}
+
+// recordData returns the additional data authenticated with a token stored
+// in the record with the key 'record'.
+func recordData(record string) []byte {
+	return append(append([]byte{}, sealedMagic...), record...)
+}
+
+// encodeToken gob encodes a token and encrypts it with the current key for
+// the record of its user.
+func (kr *Keyring) encodeToken(ut UserToken) ([]byte, error) {
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(ut); err != nil {
+		return nil, err
+	}
+	return kr.Seal(data.Bytes(), ut.Username)
+}
+
+// decodeToken decrypts and decodes a token stored for 'username'. It also
+// returns whether the token should be stored again with the current key.
+// Tokens of other users are rejected.
+func (kr *Keyring) decodeToken(data []byte, username string) (UserToken, bool, error) {
+	ut := UserToken{}
+	plain, stale, err := kr.Open(data, username)
+	if err != nil {
+		return ut, false, err
+	}
+	err = gob.NewDecoder(bytes.NewReader(plain)).Decode(&ut)
+	if err == nil && ut.Username != username {
+		return UserToken{}, false, fmt.Errorf("token of user %q stored for %q", ut.Username, username)
+	}
+	return ut, stale, err
+}
@@ -0,0 +1,233 @@
+package store
+
+import (
+	"encoding/base32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/G-Node/gin-valid/internal/log"
+)
+
+// Files stores every token gob encoded in a file named after the user in
+// Dir, encrypted with Keys. Links are symbolic links to the token files in
+// the "by-sessionid" and "by-repo" subdirectories, named after the base 32
+// encoded session ID or repository path. The timestamps of a session are
+// stored JSON encoded next to its link, in a file with the extension ".json".
+type Files struct {
+	Dir  string
+	Keys *Keyring
+}
+
+const (
+	sessionsdir = "by-sessionid"
+	reposdir    = "by-repo"
+	sessionext  = ".json"
+)
+
+func (fs Files) SaveToken(ut UserToken) error {
+	data, err := fs.Keys.encodeToken(ut)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(fs.Dir, ut.Username)
+	if err = ioutil.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	// legacy token files were created readable by everyone
+	return os.Chmod(path, 0600)
+}
+
+func (fs Files) Token(username string) (UserToken, error) {
+	return fs.loadToken(filepath.Join(fs.Dir, username))
+}
+
+func (fs Files) SaveSession(s Session) error {
+	if err := fs.link(s.Username, sessionsdir, s.ID); err != nil {
+		return err
+	}
+	data, err := encodeSession(s)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fs.sessionFile(s.ID)+sessionext, data, 0600)
+}
+
+func (fs Files) Session(sessionid string) (Session, error) {
+	linkfile := fs.sessionFile(sessionid)
+	target, err := os.Readlink(linkfile)
+	if err != nil {
+		return Session{}, err
+	}
+	data, err := ioutil.ReadFile(linkfile + sessionext)
+	if os.IsNotExist(err) {
+		// sessions linked before their timestamps were recorded are as old
+		// as their link
+		fi, err := os.Lstat(linkfile)
+		if err != nil {
+			return Session{}, err
+		}
+		return Session{ID: sessionid, Username: filepath.Base(target), Created: fi.ModTime(), LastSeen: fi.ModTime()}, nil
+	} else if err != nil {
+		return Session{}, err
+	}
+	s, err := decodeSession(sessionid, data)
+	// the link is authoritative for the user of the session
+	s.Username = filepath.Base(target)
+	return s, err
+}
+
+func (fs Files) RemoveSession(sessionid string) error {
+	linkfile := fs.sessionFile(sessionid)
+	if err := os.Remove(linkfile + sessionext); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Remove(linkfile)
+}
+
+func (fs Files) SessionToken(sessionid string) (UserToken, error) {
+	return fs.loadToken(fs.sessionFile(sessionid))
+}
+
+func (fs Files) LinkRepo(username, repopath string) error {
+	return fs.link(username, reposdir, repopath)
+}
+
+func (fs Files) RepoToken(repopath string) (UserToken, error) {
+	return fs.loadToken(filepath.Join(fs.Dir, reposdir, b32(repopath)))
+}
+
+func (fs Files) UnlinkRepo(repopath string) error {
+	return os.Remove(filepath.Join(fs.Dir, reposdir, b32(repopath)))
+}
+
+func (fs Files) Users() ([]string, error) {
+	files, err := ioutil.ReadDir(fs.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	users := make([]string, 0, len(files))
+	for _, fi := range files {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		// the directory may hold other files, like the token key or the
+		// database of the bolt store
+		data, err := ioutil.ReadFile(filepath.Join(fs.Dir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if _, _, err := fs.Keys.decodeToken(data, fi.Name()); err != nil {
+			log.Write("[Warning] ignoring file %q in token directory: not a token", fi.Name())
+			continue
+		}
+		users = append(users, fi.Name())
+	}
+	return users, nil
+}
+
+func (fs Files) Sessions() ([]Session, error) {
+	files, err := ioutil.ReadDir(filepath.Join(fs.Dir, sessionsdir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	sessions := make([]Session, 0, len(files))
+	for _, fi := range files {
+		if strings.HasSuffix(fi.Name(), sessionext) {
+			continue
+		}
+		sessionid, err := base32.StdEncoding.DecodeString(fi.Name())
+		if err != nil {
+			log.Write("[Warning] ignoring invalid session link %q", fi.Name())
+			continue
+		}
+		s, err := fs.Session(string(sessionid))
+		if err != nil {
+			log.Write("[Warning] ignoring invalid session link %q: %s", fi.Name(), err.Error())
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
+
+func (fs Files) Repos() (map[string]string, error) {
+	return fs.links(reposdir)
+}
+
+// sessionFile returns the path of the link of a session.
+func (fs Files) sessionFile(sessionid string) string {
+	return filepath.Join(fs.Dir, sessionsdir, b32(sessionid))
+}
+
+// link links the name in the subdirectory 'dir' to the token of a user.
+func (fs Files) link(username, dir, name string) error {
+	utfile := filepath.Join(fs.Dir, username)
+	linkfile := filepath.Join(fs.Dir, dir, b32(name))
+	// if it's already linked, this will fail; remove existing and relink
+	// this will also fix outdated tokens
+	os.Remove(linkfile)
+	return os.Symlink(utfile, linkfile)
+}
+
+// links returns the usernames linked to the names in the subdirectory 'dir'.
+func (fs Files) links(dir string) (map[string]string, error) {
+	files, err := ioutil.ReadDir(filepath.Join(fs.Dir, dir))
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	links := make(map[string]string, len(files))
+	for _, fi := range files {
+		name, err := base32.StdEncoding.DecodeString(fi.Name())
+		if err != nil {
+			log.Write("[Warning] ignoring invalid token link %q", fi.Name())
+			continue
+		}
+		target, err := os.Readlink(filepath.Join(fs.Dir, dir, fi.Name()))
+		if err != nil {
+			log.Write("[Warning] ignoring invalid token link %q: %s", fi.Name(), err.Error())
+			continue
+		}
+		links[string(name)] = filepath.Base(target)
+	}
+	return links, nil
+}
+
+// loadToken loads a token from the provided path, which is the token file of
+// a user or a link to it. Tokens that are not encrypted with the current key
+// are saved again.
+func (fs Files) loadToken(path string) (UserToken, error) {
+	tokenfile, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		log.Write("[Error] Failed to load token from %s", path)
+		return UserToken{}, err
+	}
+	data, err := ioutil.ReadFile(tokenfile)
+	if err != nil {
+		log.Write("[Error] Failed to load token from %s", path)
+		return UserToken{}, err
+	}
+	ut, stale, err := fs.Keys.decodeToken(data, filepath.Base(tokenfile))
+	if err != nil {
+		return ut, err
+	}
+	if stale {
+		if err := fs.SaveToken(ut); err != nil {
+			log.ShowWrite("[Error] re-encrypting token of user %q: %s", ut.Username, err.Error())
+		}
+	}
+	return ut, nil
+}
+
+// b32 encodes a string to base 32. Use this to make strings such as IDs or
+// repopaths filename friendly.
+func b32(s string) string {
+	return base32.StdEncoding.EncodeToString([]byte(s))
+}
@@ -0,0 +1,195 @@
+/*
+Package store keeps the GIN access tokens of the users that logged in to the
+service and the links of their login sessions and of the repositories they
+enabled validation hooks for to these tokens.
+*/
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	gweb "github.com/G-Node/gin-cli/web"
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/log"
+)
+
+// Session is the server-side record of a login session. The session ID is
+// the value of the session cookie.
+type Session struct {
+	ID       string
+	Username string
+	Created  time.Time
+	LastSeen time.Time
+}
+
+// UserToken is the GIN access token of a user. Tokens issued by an OAuth
+// login expire and are stored with the refresh token to renew them. Tokens
+// created with a password never expire and have a zero expiry.
+type UserToken struct {
+	Username     string
+	Token        string
+	RefreshToken string
+	Expiry       time.Time
+}
+
+// GIN returns the token for use with the GIN client.
+func (ut UserToken) GIN() gweb.UserToken {
+	return gweb.UserToken{Username: ut.Username, Token: ut.Token}
+}
+
+// TokenStore stores access tokens by username. Session IDs and repository
+// paths link to the token of a user, so a link always resolves to the latest
+// token saved for the user.
+type TokenStore interface {
+	// SaveToken stores a token, replacing an existing token of the user.
+	SaveToken(ut UserToken) error
+	// Token returns the token of a user.
+	Token(username string) (UserToken, error)
+	// SaveSession links the ID of a session to the token of its user and
+	// stores its timestamps, replacing an existing record of the session.
+	SaveSession(s Session) error
+	// Session returns the record of a session.
+	Session(sessionid string) (Session, error)
+	// RemoveSession removes a session and its link.
+	RemoveSession(sessionid string) error
+	// SessionToken returns the token linked to a session ID.
+	SessionToken(sessionid string) (UserToken, error)
+	// LinkRepo links a repository path to the token of a user.
+	LinkRepo(username, repopath string) error
+	// RepoToken returns the token linked to a repository path.
+	RepoToken(repopath string) (UserToken, error)
+	// UnlinkRepo removes the link of a repository path.
+	UnlinkRepo(repopath string) error
+	// Users returns the names of all users with a token.
+	Users() ([]string, error)
+	// Sessions returns the records of all sessions.
+	Sessions() ([]Session, error)
+	// Repos returns the usernames linked to all repository paths.
+	Repos() (map[string]string, error)
+}
+
+// New returns the token store selected in the server configuration, which
+// encrypts tokens with 'keys'.
+func New(srvcfg config.ServerCfg, keys *Keyring) (TokenStore, error) {
+	tokendir, err := filepath.Abs(srvcfg.Dir.Tokens)
+	if err != nil {
+		return nil, err
+	}
+	switch srvcfg.TokenStore.Backend {
+	case "", "files":
+		return Files{Dir: tokendir, Keys: keys}, nil
+	case "bolt":
+		path := srvcfg.TokenStore.Path
+		if path == "" {
+			path = filepath.Join(tokendir, "tokens.db")
+		}
+		return Bolt{Path: path, Keys: keys}, nil
+	default:
+		return nil, fmt.Errorf("unknown token store backend %q", srvcfg.TokenStore.Backend)
+	}
+}
+
+// Migrate copies all tokens and the session and repository links to them
+// from one store to another. Links to users without a token are skipped.
+// It returns the number of copied tokens, sessions and repositories.
+func Migrate(from, to TokenStore) (int, int, int, error) {
+	users, err := from.Users()
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("listing users: %s", err.Error())
+	}
+	migrated := make(map[string]bool, len(users))
+	for _, username := range users {
+		ut, err := from.Token(username)
+		if err != nil {
+			log.ShowWrite("[Warning] skipping token of user %q: %s", username, err.Error())
+			continue
+		}
+		if err = to.SaveToken(ut); err != nil {
+			return len(migrated), 0, 0, fmt.Errorf("saving token of user %q: %s", username, err.Error())
+		}
+		migrated[username] = true
+	}
+
+	sessions, err := from.Sessions()
+	if err != nil {
+		return len(migrated), 0, 0, fmt.Errorf("listing sessions: %s", err.Error())
+	}
+	nsessions := 0
+	for _, s := range sessions {
+		if !migrated[s.Username] {
+			continue
+		}
+		if err = to.SaveSession(s); err != nil {
+			return len(migrated), nsessions, 0, fmt.Errorf("linking session of user %q: %s", s.Username, err.Error())
+		}
+		nsessions++
+	}
+
+	repos, err := from.Repos()
+	if err != nil {
+		return len(migrated), nsessions, 0, fmt.Errorf("listing repositories: %s", err.Error())
+	}
+	nrepos := 0
+	for repopath, username := range repos {
+		if !migrated[username] {
+			log.ShowWrite("[Warning] skipping repository %q linked to user %q without token", repopath, username)
+			continue
+		}
+		if err = to.LinkRepo(username, repopath); err != nil {
+			return len(migrated), nsessions, nrepos, fmt.Errorf("linking repository %q: %s", repopath, err.Error())
+		}
+		nrepos++
+	}
+	return len(migrated), nsessions, nrepos, nil
+}
+
+// Reencrypt stores every token of a store again, encrypting it with the
+// current key. It returns the number of tokens stored.
+func Reencrypt(ts TokenStore) (int, error) {
+	users, err := ts.Users()
+	if err != nil {
+		return 0, fmt.Errorf("listing users: %s", err.Error())
+	}
+	count := 0
+	for _, username := range users {
+		ut, err := ts.Token(username)
+		if err != nil {
+			return count, fmt.Errorf("reading token of user %q: %s", username, err.Error())
+		}
+		if err = ts.SaveToken(ut); err != nil {
+			return count, fmt.Errorf("saving token of user %q: %s", username, err.Error())
+		}
+		count++
+	}
+	return count, nil
+}
+
+// sessionRecord is the stored form of a session, which is named after its ID.
+type sessionRecord struct {
+	Username string    `json:"username"`
+	Created  time.Time `json:"created"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// encodeSession JSON encodes the record of a session.
+func encodeSession(s Session) ([]byte, error) {
+	return json.Marshal(sessionRecord{Username: s.Username, Created: s.Created, LastSeen: s.LastSeen})
+}
+
+// decodeSession decodes the stored record of a session. Sessions stored
+// before their timestamps were recorded consist of the plain username and
+// have zero timestamps.
+func decodeSession(sessionid string, data []byte) (Session, error) {
+	if !bytes.HasPrefix(data, []byte("{")) {
+		return Session{ID: sessionid, Username: string(data)}, nil
+	}
+	var rec sessionRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return Session{}, fmt.Errorf("invalid record of session: %s", err.Error())
+	}
+	return Session{ID: sessionid, Username: rec.Username, Created: rec.Created, LastSeen: rec.LastSeen}, nil
+}
@@ -0,0 +1,322 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	gweb "github.com/G-Node/gin-cli/web"
+	"github.com/G-Node/gin-valid/internal/config"
+	bolt "go.etcd.io/bbolt"
+)
+
+// newFiles returns a file token store in a new directory with the link
+// subdirectories the server creates on setup.
+func newFiles(t *testing.T, dir string) Files {
+	for _, sub := range []string{sessionsdir, reposdir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return Files{Dir: dir}
+}
+
+// checkStore runs the operations of the token store interface on an empty
+// store. File stores get the other files the server keeps in the token
+// directory, which must not be taken for tokens.
+func checkStore(t *testing.T, ts TokenStore) {
+	if fs, ok := ts.(Files); ok {
+		ioutil.WriteFile(filepath.Join(fs.Dir, "tokens.key"), []byte("c2VjcmV0IGtleSB0aGF0IGlzIG5vdCBhIHRva2VuIQ==\n"), 0600)
+		ioutil.WriteFile(filepath.Join(fs.Dir, "tokens.db"), []byte("bolt database"), 0600)
+	}
+	if _, err := ts.Token("alice"); err == nil {
+		t.Fatal("empty store returned a token")
+	}
+	if users, err := ts.Users(); err != nil || len(users) != 0 {
+		t.Fatalf("unexpected users in empty store %v: %v", users, err)
+	}
+	if repos, err := ts.Repos(); err != nil || len(repos) != 0 {
+		t.Fatalf("unexpected repositories in empty store %v: %v", repos, err)
+	}
+
+	expiry := time.Date(2020, 3, 1, 13, 0, 0, 0, time.UTC)
+	for _, ut := range []UserToken{{Username: "alice", Token: "a1"}, {Username: "bob", Token: "b1", RefreshToken: "r1", Expiry: expiry}} {
+		if err := ts.SaveToken(ut); err != nil {
+			t.Fatalf("failed to save token: %s", err.Error())
+		}
+	}
+	created := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	session := Session{ID: "session+/=", Username: "alice", Created: created, LastSeen: created}
+	if err := ts.SaveSession(session); err != nil {
+		t.Fatalf("failed to save session: %s", err.Error())
+	}
+	if err := ts.SaveSession(Session{ID: "other", Username: "bob", Created: created, LastSeen: created}); err != nil {
+		t.Fatalf("failed to save session: %s", err.Error())
+	}
+	session.LastSeen = created.Add(time.Hour)
+	if err := ts.SaveSession(session); err != nil {
+		t.Fatalf("failed to update session: %s", err.Error())
+	}
+	if s, err := ts.Session("session+/="); err != nil || !s.LastSeen.Equal(session.LastSeen) || !s.Created.Equal(created) || s.Username != "alice" {
+		t.Fatalf("unexpected session %+v: %v", s, err)
+	}
+	if err := ts.LinkRepo("bob", "bob/data"); err != nil {
+		t.Fatalf("failed to link repository: %s", err.Error())
+	}
+	// links resolve to the latest token of the user
+	if err := ts.SaveToken(UserToken{Username: "alice", Token: "a2"}); err != nil {
+		t.Fatalf("failed to replace token: %s", err.Error())
+	}
+	if ut, err := ts.SessionToken("session+/="); err != nil || ut.Token != "a2" {
+		t.Fatalf("unexpected session token %+v: %v", ut, err)
+	}
+	if ut, err := ts.RepoToken("bob/data"); err != nil || ut.Username != "bob" || ut.Token != "b1" || ut.RefreshToken != "r1" || !ut.Expiry.Equal(expiry) {
+		t.Fatalf("unexpected repository token %+v: %v", ut, err)
+	}
+	if _, err := ts.SessionToken("unknown"); err == nil {
+		t.Fatal("unknown session returned a token")
+	}
+
+	users, err := ts.Users()
+	sort.Strings(users)
+	if err != nil || !reflect.DeepEqual(users, []string{"alice", "bob"}) {
+		t.Fatalf("unexpected users %v: %v", users, err)
+	}
+	if err := ts.RemoveSession("other"); err != nil {
+		t.Fatalf("failed to remove session: %s", err.Error())
+	}
+	if err := ts.RemoveSession("other"); err == nil {
+		t.Fatal("removing a missing session should fail")
+	}
+	if _, err := ts.SessionToken("other"); err == nil {
+		t.Fatal("removed session returned a token")
+	}
+	if sessions, err := ts.Sessions(); err != nil || len(sessions) != 1 || sessions[0].ID != "session+/=" || sessions[0].Username != "alice" {
+		t.Fatalf("unexpected sessions %v: %v", sessions, err)
+	}
+	if repos, err := ts.Repos(); err != nil || !reflect.DeepEqual(repos, map[string]string{"bob/data": "bob"}) {
+		t.Fatalf("unexpected repositories %v: %v", repos, err)
+	}
+
+	if err := ts.UnlinkRepo("bob/data"); err != nil {
+		t.Fatalf("failed to unlink repository: %s", err.Error())
+	}
+	if err := ts.UnlinkRepo("bob/data"); err == nil {
+		t.Fatal("unlinking a missing repository should fail")
+	}
+	if _, err := ts.RepoToken("bob/data"); err == nil {
+		t.Fatal("unlinked repository returned a token")
+	}
+}
+
+func TestFiles(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir("", "tokens")
+	defer os.RemoveAll(tmpdir)
+	checkStore(t, newFiles(t, tmpdir))
+}
+
+func TestBolt(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir("", "tokens")
+	defer os.RemoveAll(tmpdir)
+	checkStore(t, Bolt{Path: filepath.Join(tmpdir, "db", "tokens.db")})
+}
+
+func TestNew(t *testing.T) {
+	srvcfg := config.Read()
+	srvcfg.Dir.Tokens = "/srv/tokens"
+	if ts, err := New(srvcfg, nil); err != nil || ts != (Files{Dir: "/srv/tokens"}) {
+		t.Fatalf("unexpected default token store %+v: %v", ts, err)
+	}
+	srvcfg.TokenStore.Backend = "bolt"
+	if ts, err := New(srvcfg, nil); err != nil || ts != (Bolt{Path: "/srv/tokens/tokens.db"}) {
+		t.Fatalf("unexpected bolt token store %+v: %v", ts, err)
+	}
+	srvcfg.TokenStore.Path = "/var/lib/tokens.db"
+	if ts, err := New(srvcfg, nil); err != nil || ts != (Bolt{Path: "/var/lib/tokens.db"}) {
+		t.Fatalf("unexpected bolt token store %+v: %v", ts, err)
+	}
+	srvcfg.TokenStore.Backend = "redis"
+	if _, err := New(srvcfg, nil); err == nil {
+		t.Fatal("opening an unknown token store should fail")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir("", "tokens")
+	defer os.RemoveAll(tmpdir)
+	from := newFiles(t, filepath.Join(tmpdir, "files"))
+	from.SaveToken(UserToken{Username: "alice", Token: "a1"})
+	now := time.Now()
+	from.SaveSession(Session{ID: "s1", Username: "alice", Created: now, LastSeen: now})
+	from.SaveSession(Session{ID: "s2", Username: "alice", Created: now, LastSeen: now})
+	from.LinkRepo("alice", "alice/data")
+	// links to missing token files are skipped
+	from.LinkRepo("carol", "carol/data")
+
+	to := Bolt{Path: filepath.Join(tmpdir, "tokens.db")}
+	tokens, sessions, repos, err := Migrate(from, to)
+	if err != nil {
+		t.Fatalf("migration failed: %s", err.Error())
+	}
+	if tokens != 1 || sessions != 2 || repos != 1 {
+		t.Fatalf("unexpected migration counts %d, %d, %d", tokens, sessions, repos)
+	}
+	if ut, err := to.RepoToken("alice/data"); err != nil || ut.Token != "a1" {
+		t.Fatalf("unexpected migrated token %+v: %v", ut, err)
+	}
+	if _, err := to.RepoToken("carol/data"); err == nil {
+		t.Fatal("migrated repository without token")
+	}
+	if s, err := to.Session("s1"); err != nil || !s.Created.Equal(now) {
+		t.Fatalf("unexpected migrated session %+v: %v", s, err)
+	}
+}
+
+func TestLegacySessions(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir("", "tokens")
+	defer os.RemoveAll(tmpdir)
+
+	// session links without a record are as old as the link
+	fs := newFiles(t, filepath.Join(tmpdir, "files"))
+	fs.SaveToken(UserToken{Username: "alice", Token: "a1"})
+	fs.link("alice", sessionsdir, "s1")
+	if s, err := fs.Session("s1"); err != nil || s.Username != "alice" || s.Created.IsZero() {
+		t.Fatalf("unexpected legacy session %+v: %v", s, err)
+	}
+
+	// database sessions stored as plain usernames have no timestamps
+	db := Bolt{Path: filepath.Join(tmpdir, "tokens.db")}
+	db.SaveToken(UserToken{Username: "alice", Token: "a1"})
+	db.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Put([]byte("s1"), []byte("alice"))
+	})
+	if s, err := db.Session("s1"); err != nil || s.Username != "alice" || !s.Created.IsZero() {
+		t.Fatalf("unexpected legacy session %+v: %v", s, err)
+	}
+	if ut, err := db.SessionToken("s1"); err != nil || ut.Token != "a1" {
+		t.Fatalf("unexpected legacy session token %+v: %v", ut, err)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir("", "tokens")
+	defer os.RemoveAll(tmpdir)
+	oldkey := filepath.Join(tmpdir, "keys", "old.key")
+	newkey := filepath.Join(tmpdir, "keys", "new.key")
+
+	srvcfg := config.Read()
+	srvcfg.Dir.Tokens = filepath.Join(tmpdir, "files")
+	srvcfg.TokenStore.KeyFile = oldkey
+	open := func(generate bool) (TokenStore, error) {
+		keys, err := LoadKeyring(srvcfg.TokenStore.KeyFile, srvcfg.TokenStore.OldKeyFiles, generate)
+		if err != nil {
+			return nil, err
+		}
+		return New(srvcfg, keys)
+	}
+
+	// a missing key is only generated when requested and only readable by
+	// the server
+	if _, err := open(false); err == nil {
+		t.Fatal("missing token key did not fail")
+	}
+	ts, err := open(true)
+	if err != nil {
+		t.Fatalf("failed to open token store: %s", err.Error())
+	}
+	if info, err := os.Stat(oldkey); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("token key not generated: %v", err)
+	}
+	checkStore(t, newFiles(t, srvcfg.Dir.Tokens).withKeys(ts.(Files).Keys))
+
+	// tokens are not stored in plain text
+	content, _ := ioutil.ReadFile(filepath.Join(srvcfg.Dir.Tokens, "alice"))
+	if !bytes.HasPrefix(content, sealedMagic) || bytes.Contains(content, []byte("a2")) {
+		t.Fatalf("token stored unencrypted: %q", content)
+	}
+
+	// a token copied to the record of another user cannot be read
+	copied := filepath.Join(srvcfg.Dir.Tokens, "mallory")
+	ioutil.WriteFile(copied, content, 0600)
+	if ut, err := ts.Token("mallory"); err == nil {
+		t.Fatalf("token copied to another user was read: %+v", ut)
+	}
+	os.Remove(copied)
+
+	// legacy plaintext tokens of the GIN client are encrypted when they are
+	// read
+	var legacy bytes.Buffer
+	gob.NewEncoder(&legacy).Encode(gweb.UserToken{Username: "carol", Token: "c1"})
+	ioutil.WriteFile(filepath.Join(srvcfg.Dir.Tokens, "carol"), legacy.Bytes(), 0600)
+	plain := Files{Dir: srvcfg.Dir.Tokens}
+	if ut, err := ts.Token("carol"); err != nil || ut.Token != "c1" {
+		t.Fatalf("failed to read legacy token %+v: %v", ut, err)
+	}
+	if _, err := plain.Token("carol"); err == nil {
+		t.Fatal("legacy token not encrypted on first read")
+	}
+
+	// after rotating the key, tokens are encrypted with the new key
+	srvcfg.TokenStore.KeyFile = newkey
+	srvcfg.TokenStore.OldKeyFiles = []string{oldkey}
+	rotated, err := open(true)
+	if err != nil {
+		t.Fatalf("failed to open token store with rotated key: %s", err.Error())
+	}
+	if count, err := Reencrypt(rotated); err != nil || count != 3 {
+		t.Fatalf("unexpected re-encryption of %d tokens: %v", count, err)
+	}
+	if _, err := ts.Token("alice"); err == nil {
+		t.Fatal("token readable with the old key after re-encryption")
+	}
+	srvcfg.TokenStore.OldKeyFiles = nil
+	current, _ := open(false)
+	if ut, err := current.SessionToken("session+/="); err != nil || ut.Token != "a2" {
+		t.Fatalf("unexpected token with the new key %+v: %v", ut, err)
+	}
+
+	// the database encrypts tokens the same way
+	keys := current.(Files).Keys
+	db := Bolt{Path: filepath.Join(tmpdir, "tokens.db")}
+	db.SaveToken(UserToken{Username: "dave", Token: "d1"})
+	if ut, err := db.withKeys(keys).Token("dave"); err != nil || ut.Token != "d1" {
+		t.Fatalf("failed to read legacy token from database %+v: %v", ut, err)
+	}
+	if _, err := db.Token("dave"); err == nil {
+		t.Fatal("legacy token in database not encrypted on first read")
+	}
+
+	// tokens encrypted without their record key are encrypted again when
+	// they are read
+	var encoded bytes.Buffer
+	gob.NewEncoder(&encoded).Encode(UserToken{Username: "erin", Token: "e1"})
+	nonce := make([]byte, keys.current.aead.NonceSize())
+	sealed := append(append(append([]byte{}, legacyMagic...), keys.current.id...), nonce...)
+	sealed = keys.current.aead.Seal(sealed, nonce, encoded.Bytes(), legacyMagic)
+	ioutil.WriteFile(filepath.Join(srvcfg.Dir.Tokens, "erin"), sealed, 0600)
+	if ut, err := current.Token("erin"); err != nil || ut.Token != "e1" {
+		t.Fatalf("failed to read token without record key %+v: %v", ut, err)
+	}
+	content, _ = ioutil.ReadFile(filepath.Join(srvcfg.Dir.Tokens, "erin"))
+	if !bytes.HasPrefix(content, sealedMagic) {
+		t.Fatalf("token without record key not encrypted again: %q", content)
+	}
+}
+
+// withKeys returns the file token store encrypting tokens with 'keys'.
+func (fs Files) withKeys(keys *Keyring) Files {
+	fs.Keys = keys
+	return fs
+}
+
+// withKeys returns the database token store encrypting tokens with 'keys'.
+func (db Bolt) withKeys(keys *Keyring) Bolt {
+	db.Keys = keys
+	return db
+}
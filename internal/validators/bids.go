@@ -0,0 +1,211 @@
+package validators
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/log"
+)
+
+// BidsResultStruct is the struct to parse a full BIDS validation json.
+type BidsResultStruct struct {
+	Issues struct {
+		Errors []struct {
+			Key      string `json:"key"`
+			Severity string `json:"severity"`
+			Reason   string `json:"reason"`
+			Files    []struct {
+				Key  string `json:"key"`
+				Code int    `json:"code"`
+				File struct {
+					Name         string `json:"name"`
+					Path         string `json:"path"`
+					RelativePath string `json:"relativePath"`
+				} `json:"file"`
+				Evidence  interface{} `json:"evidence"`
+				Line      interface{} `json:"line"`
+				Character interface{} `json:"character"`
+				Severity  string      `json:"severity"`
+				Reason    string      `json:"reason"`
+			} `json:"files"`
+			AdditionalFileCount int `json:"additionalFileCount"`
+			Code                int `json:"code"`
+		} `json:"errors"`
+		Warnings []struct {
+			Key      string `json:"key"`
+			Severity string `json:"severity"`
+			Reason   string `json:"reason"`
+			Files    []struct {
+				Key  string `json:"key"`
+				Code int    `json:"code"`
+				File struct {
+					Name         string `json:"name"`
+					Path         string `json:"path"`
+					RelativePath string `json:"relativePath"`
+					Stats        struct {
+						Dev         int       `json:"dev"`
+						Mode        int       `json:"mode"`
+						Nlink       int       `json:"nlink"`
+						UID         int       `json:"uid"`
+						Gid         int       `json:"gid"`
+						Rdev        int       `json:"rdev"`
+						Blksize     int       `json:"blksize"`
+						Ino         int       `json:"ino"`
+						Size        int       `json:"size"`
+						Blocks      int       `json:"blocks"`
+						AtimeMs     float64   `json:"atimeMs"`
+						MtimeMs     float64   `json:"mtimeMs"`
+						CtimeMs     float64   `json:"ctimeMs"`
+						BirthtimeMs float64   `json:"birthtimeMs"`
+						Atime       time.Time `json:"atime"`
+						Mtime       time.Time `json:"mtime"`
+						Ctime       time.Time `json:"ctime"`
+						Birthtime   time.Time `json:"birthtime"`
+					} `json:"stats"`
+				} `json:"file"`
+				Evidence  interface{} `json:"evidence"`
+				Line      interface{} `json:"line"`
+				Character interface{} `json:"character"`
+				Severity  string      `json:"severity"`
+				Reason    string      `json:"reason"`
+			} `json:"files"`
+			AdditionalFileCount int `json:"additionalFileCount"`
+			Code                int `json:"code"`
+		} `json:"warnings"`
+		Ignored []interface{} `json:"ignored"`
+	} `json:"issues"`
+	Summary struct {
+		Sessions   []interface{} `json:"sessions"`
+		Subjects   []string      `json:"subjects"`
+		Tasks      []string      `json:"tasks"`
+		Modalities []string      `json:"modalities"`
+		TotalFiles int           `json:"totalFiles"`
+		Size       int           `json:"size"`
+	} `json:"summary"`
+}
+
+// bids runs the BIDS validator on a repository or on the BIDS root directory
+// specified in the validation config.
+type bids struct{}
+
+func init() {
+	Register(bids{})
+}
+
+func (bids) Name() string {
+	return "bids"
+}
+
+// Files returns the directory the BIDS validator should run on. This is the
+// 'bidsroot' directory from the validation config if it exists and the
+// repository root otherwise.
+func (bids) Files(valroot string, valcfg Validationcfg) ([]string, error) {
+	if valcfg.Bidscfg.BidsRoot == "" {
+		return []string{valroot}, nil
+	}
+	checkdir := filepath.Join(valroot, valcfg.Bidscfg.BidsRoot)
+	fi, err := os.Stat(checkdir)
+	if err != nil {
+		log.ShowWrite("[Error] reading validation root directory: %s", err.Error())
+		return []string{valroot}, nil
+	}
+	if !fi.IsDir() {
+		log.ShowWrite("[Error] validation root %q is not a directory", checkdir)
+		return []string{valroot}, nil
+	}
+	log.ShowWrite("[Info] using validation root directory: %s", checkdir)
+	return []string{checkdir}, nil
+}
+
+func (bids) Command(valroot string, files []string, valcfg Validationcfg) *exec.Cmd {
+	// Make sure the validator arguments are in the right order
+	var args []string
+	// Ignoring NiftiHeaders by default, since it seems to be a common error
+	if !valcfg.Bidscfg.ValidateNifti {
+		args = append(args, "--ignoreNiftiHeaders")
+	}
+	args = append(args, "--json")
+	args = append(args, files...)
+	return exec.Command(config.Read().Exec["bids"].Path, args...)
+}
+
+func (bids) Parse(output []byte) (interface{}, error) {
+	var res BidsResultStruct
+	err := json.Unmarshal(output, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Issues returns an issue for every file listed in the BIDS validator issues.
+// File paths are relative to the validated BIDS root.
+func (bids) Issues(results interface{}) []Issue {
+	return bidsIssues(results.(*BidsResultStruct), "")
+}
+
+// ConfigIssues returns an issue for every file listed in the BIDS validator
+// issues, with file paths relative to the repository root instead of the BIDS
+// root configured in the validation config.
+func (bids) ConfigIssues(results interface{}, valcfg Validationcfg) []Issue {
+	return bidsIssues(results.(*BidsResultStruct), valcfg.Bidscfg.BidsRoot)
+}
+
+// bidsIssues returns an issue for every file listed in the BIDS validator
+// issues, with file paths joined onto 'bidsroot'.
+func bidsIssues(res *BidsResultStruct, bidsroot string) []Issue {
+	issues := make([]Issue, 0)
+	add := func(severity Severity, key, reason, file, filereason string) {
+		if filereason != "" {
+			reason = filereason
+		}
+		if file != "" {
+			file = path.Join(filepath.ToSlash(bidsroot), strings.TrimPrefix(file, "/"))
+		}
+		issues = append(issues, Issue{
+			Severity: severity,
+			Code:     key,
+			File:     file,
+			Message:  reason,
+		})
+	}
+	for _, val := range res.Issues.Errors {
+		if len(val.Files) == 0 {
+			add(SeverityError, val.Key, val.Reason, "", "")
+		}
+		for _, file := range val.Files {
+			add(SeverityError, val.Key, val.Reason, file.File.RelativePath, file.Reason)
+		}
+	}
+	for _, val := range res.Issues.Warnings {
+		if len(val.Files) == 0 {
+			add(SeverityWarning, val.Key, val.Reason, "", "")
+		}
+		for _, file := range val.Files {
+			add(SeverityWarning, val.Key, val.Reason, file.File.RelativePath, file.Reason)
+		}
+	}
+	return issues
+}
+
+func (bids) Render(w io.Writer, badge []byte, rep *Report, results interface{}, user, repo, csrftoken string) error {
+	head := fmt.Sprintf("BIDS validation for %s/%s", user, repo)
+	summary := results.(*BidsResultStruct).Summary
+	details := []string{
+		fmt.Sprintf("Sessions: %v", summary.Sessions),
+		fmt.Sprintf("Subjects: %v", summary.Subjects),
+		fmt.Sprintf("Tasks: %v", summary.Tasks),
+		fmt.Sprintf("Modalities: %v", summary.Modalities),
+		fmt.Sprintf("Total files: %d", summary.TotalFiles),
+		fmt.Sprintf("Size: %d", summary.Size),
+	}
+	return renderReport(w, badge, head, rep, details, "", csrftoken)
+}
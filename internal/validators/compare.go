@@ -0,0 +1,52 @@
+package validators
+
+// Comparison lists the differences between the issues of two reports.
+type Comparison struct {
+	// Added are the issues of the newer report that the older one does not
+	// have.
+	Added []Issue `json:"added"`
+	// Resolved are the issues of the older report that the newer one does
+	// not have.
+	Resolved []Issue `json:"resolved"`
+	// Unchanged are the issues both reports have, as listed in the newer
+	// report.
+	Unchanged []Issue `json:"unchanged"`
+}
+
+// issueKey identifies an issue across validation runs by its code and file
+// path. Issues without a code are identified by their message instead.
+func issueKey(issue Issue) string {
+	code := issue.Code
+	if code == "" {
+		code = issue.Message
+	}
+	return code + "\x00" + issue.File
+}
+
+// Compare compares the issues of the report 'base' with those of the newer
+// report 'head'. Issues with the same key that occur several times in a
+// report are matched one by one.
+func Compare(base, head *Report) Comparison {
+	cmp := Comparison{Added: []Issue{}, Resolved: []Issue{}, Unchanged: []Issue{}}
+	remaining := make(map[string]int)
+	for _, issue := range base.Issues {
+		remaining[issueKey(issue)]++
+	}
+	for _, issue := range head.Issues {
+		key := issueKey(issue)
+		if remaining[key] > 0 {
+			remaining[key]--
+			cmp.Unchanged = append(cmp.Unchanged, issue)
+			continue
+		}
+		cmp.Added = append(cmp.Added, issue)
+	}
+	for _, issue := range base.Issues {
+		key := issueKey(issue)
+		if remaining[key] > 0 {
+			remaining[key]--
+			cmp.Resolved = append(cmp.Resolved, issue)
+		}
+	}
+	return cmp
+}
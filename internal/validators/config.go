@@ -0,0 +1,90 @@
+package validators
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/log"
+)
+
+// Validationcfg is used to unmarshall a config file
+// holding information specific for running the
+// various validations. e.g. where the root
+// folder of a bids directory can be found or
+// whether the NiftiHeaders should be ignored.
+// The content section lists additional paths or
+// patterns whose annexed content should be downloaded
+// and patterns of paths whose content should not be
+// downloaded.
+// Timeout is the number of seconds after which the
+// validator is stopped. A timeout of 0 means no limit.
+type Validationcfg struct {
+	Bidscfg struct {
+		BidsRoot      string `yaml:"bidsroot"`
+		ValidateNifti bool   `yaml:"validatenifti"`
+	} `yaml:"bidsconfig"`
+	Nixcfg  Filecfg `yaml:"nixconfig"`
+	Odmlcfg struct {
+		Filecfg    `yaml:",inline"`
+		Extensions []string `yaml:"extensions"`
+	} `yaml:"odmlconfig"`
+	Contentcfg struct {
+		Include []string `yaml:"include"`
+		Exclude []string `yaml:"exclude"`
+	} `yaml:"content"`
+	Timeout int `yaml:"timeout"`
+}
+
+// Filecfg selects the files a validator runs on.
+// Files lists paths or glob patterns relative to the
+// repository root. Matching files are validated as
+// they are, matching directories are searched for
+// files the validator supports. Without any files,
+// the whole repository is searched. Paths matching
+// one of the Exclude patterns are skipped.
+type Filecfg struct {
+	Files   []string `yaml:"files"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// handleValidationConfig unmarshalles a yaml config file
+// from file and returns the resulting Validationcfg struct.
+func handleValidationConfig(cfgpath string) (Validationcfg, error) {
+	valcfg := Validationcfg{}
+
+	content, err := ioutil.ReadFile(cfgpath)
+	if err != nil {
+		return valcfg, err
+	}
+
+	err = yaml.Unmarshal(content, &valcfg)
+	if err != nil {
+		return valcfg, err
+	}
+
+	return valcfg, nil
+}
+
+// ReadValidationConfig reads the validation config file from the root of the
+// repository at 'valroot'. If the file does not exist or cannot be parsed, the
+// empty default configuration is returned.
+func ReadValidationConfig(valroot string) Validationcfg {
+	srvcfg := config.Read()
+	cfgpath := filepath.Join(valroot, srvcfg.Label.ValidationConfigFile)
+	log.ShowWrite("[Info] looking for config file at '%s'", cfgpath)
+	fi, err := os.Stat(cfgpath)
+	if err != nil || fi.IsDir() {
+		log.ShowWrite("[Info] no validation config file found or processed, running from repo root")
+		return Validationcfg{}
+	}
+	valcfg, err := handleValidationConfig(cfgpath)
+	if err != nil {
+		log.ShowWrite("[Error] unmarshalling validation config file: %s", err.Error())
+		return Validationcfg{}
+	}
+	return valcfg
+}
@@ -0,0 +1,106 @@
+package validators
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/G-Node/gin-valid/internal/log"
+)
+
+// ContentPaths returns the paths in the repository at 'valroot' whose annexed
+// content is needed to run the validator, relative to 'valroot'. These are the
+// files the validator would validate and the paths listed in the include
+// section of the validation config. If the config excludes any paths,
+// directories are expanded to the files they contain that are not excluded.
+// Returned directories must have their complete content downloaded.
+func ContentPaths(v Validator, valroot string, valcfg Validationcfg) ([]string, error) {
+	files, err := v.Files(valroot, valcfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, pattern := range valcfg.Contentcfg.Include {
+		matches, err := filepath.Glob(filepath.Join(valroot, pattern))
+		if err != nil {
+			log.ShowWrite("[Warning] invalid include pattern %q: %s", pattern, err.Error())
+			continue
+		}
+		if len(matches) == 0 {
+			log.ShowWrite("[Info] include pattern %q did not match any paths", pattern)
+		}
+		files = append(files, matches...)
+	}
+
+	exclude := valcfg.Contentcfg.Exclude
+	seen := make(map[string]bool)
+	paths := make([]string, 0, len(files))
+	add := func(relpath string) {
+		if !seen[relpath] {
+			seen[relpath] = true
+			paths = append(paths, relpath)
+		}
+	}
+	for _, file := range files {
+		relpath, err := filepath.Rel(valroot, file)
+		if err != nil || relpath == ".." || strings.HasPrefix(relpath, ".."+string(filepath.Separator)) {
+			log.ShowWrite("[Warning] skipping path %q outside of the repository", file)
+			continue
+		}
+		if isExcluded(relpath, exclude) {
+			continue
+		}
+		fi, err := os.Lstat(file)
+		if err != nil {
+			log.ShowWrite("[Warning] skipping path %q: %s", relpath, err.Error())
+			continue
+		}
+		if !fi.IsDir() || len(exclude) == 0 {
+			add(relpath)
+			continue
+		}
+		dirfiles, err := findFilesIn(valroot, file, exclude, func(string) bool { return true })
+		if err != nil {
+			return nil, err
+		}
+		for _, dirfile := range dirfiles {
+			relpath, err := filepath.Rel(valroot, dirfile)
+			if err != nil {
+				return nil, err
+			}
+			add(relpath)
+		}
+	}
+	return paths, nil
+}
+
+// isExcluded returns whether the path, relative to the repository root,
+// matches one of the exclude patterns. Patterns containing a '/' are matched
+// against the path and each of its parent directories, all other patterns
+// against each of the path's elements.
+func isExcluded(relpath string, patterns []string) bool {
+	relpath = filepath.ToSlash(relpath)
+	if relpath == "." {
+		return false
+	}
+	for _, pattern := range patterns {
+		pattern = strings.Trim(filepath.ToSlash(pattern), "/")
+		if pattern == "" {
+			continue
+		}
+		if strings.Contains(pattern, "/") {
+			for p := relpath; p != "."; p = path.Dir(p) {
+				if match, _ := path.Match(pattern, p); match {
+					return true
+				}
+			}
+			continue
+		}
+		for _, elem := range strings.Split(relpath, "/") {
+			if match, _ := path.Match(pattern, elem); match {
+				return true
+			}
+		}
+	}
+	return false
+}
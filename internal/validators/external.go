@@ -0,0 +1,181 @@
+package validators
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/G-Node/gin-valid/internal/config"
+)
+
+// ExitCodeChecker is implemented by validators whose executables report the
+// outcome of a validation through their exit code. For these validators a
+// non-zero exit code is not treated as a failure to run the validator.
+type ExitCodeChecker interface {
+	// ExitCodeIssues returns the issues found in the output together with
+	// an issue for the exit code, if needed. It returns false if the exit
+	// code denotes a failure to run.
+	ExitCodeIssues(code int, issues []Issue) ([]Issue, bool)
+}
+
+// external is a validator defined in the server configuration.
+type external struct {
+	def       config.ExternalValidator
+	errorre   *regexp.Regexp
+	warningre *regexp.Regexp
+}
+
+// newExternal checks an external validator definition and compiles its
+// output patterns.
+func newExternal(def config.ExternalValidator) (*external, error) {
+	if def.Name == "" || strings.ToLower(def.Name) != def.Name || strings.Contains(def.Name, "/") {
+		return nil, fmt.Errorf("invalid validator name %q: names must be lowercase and must not contain '/'", def.Name)
+	}
+	if def.Executable == "" {
+		return nil, fmt.Errorf("no executable defined for validator %q", def.Name)
+	}
+	v := &external{def: def}
+	var err error
+	if def.ErrorPattern != "" {
+		v.errorre, err = regexp.Compile(def.ErrorPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid error pattern for validator %q: %s", def.Name, err.Error())
+		}
+	}
+	if def.WarningPattern != "" {
+		v.warningre, err = regexp.Compile(def.WarningPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid warning pattern for validator %q: %s", def.Name, err.Error())
+		}
+	}
+	return v, nil
+}
+
+// RegisterExternal registers all validators defined in the server
+// configuration. Definitions must not use the name of a built-in validator
+// or the reserved name "all".
+func RegisterExternal(defs []config.ExternalValidator) error {
+	for _, def := range defs {
+		if _, ok := Get(def.Name); ok {
+			return fmt.Errorf("validator %q is already registered", def.Name)
+		}
+		if def.Name == "all" {
+			// reserved for the combined status badge of a repository
+			return fmt.Errorf("validator name %q is reserved", def.Name)
+		}
+		v, err := newExternal(def)
+		if err != nil {
+			return err
+		}
+		Register(v)
+	}
+	return nil
+}
+
+func (v *external) Name() string {
+	return v.def.Name
+}
+
+// Files returns all files in the repository matching one of the configured
+// patterns.
+func (v *external) Files(valroot string, valcfg Validationcfg) ([]string, error) {
+	return findFiles(valroot, func(path string) bool {
+		relpath, err := filepath.Rel(valroot, path)
+		if err != nil {
+			return false
+		}
+		for _, pattern := range v.def.Patterns {
+			target := filepath.Base(relpath)
+			if strings.Contains(pattern, "/") {
+				target = filepath.ToSlash(relpath)
+			}
+			if match, _ := filepath.Match(pattern, target); match {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Command expands the configured argument template.
+func (v *external) Command(valroot string, files []string, valcfg Validationcfg) *exec.Cmd {
+	args := make([]string, 0, len(v.def.Args)+len(files))
+	var hasfiles bool
+	for _, arg := range v.def.Args {
+		if arg == "{files}" {
+			args = append(args, files...)
+			hasfiles = true
+			continue
+		}
+		args = append(args, strings.ReplaceAll(arg, "{root}", valroot))
+	}
+	if !hasfiles {
+		args = append(args, files...)
+	}
+	cmd := exec.Command(v.def.Executable, args...)
+	cmd.Dir = valroot
+	return cmd
+}
+
+// Parse returns the plain text output of the validator.
+func (v *external) Parse(output []byte) (interface{}, error) {
+	return string(output), nil
+}
+
+// Issues returns an issue for every output line matching the error or warning
+// pattern.
+func (v *external) Issues(results interface{}) []Issue {
+	issues := make([]Issue, 0)
+	for _, line := range strings.Split(results.(string), "\n") {
+		switch {
+		case v.errorre != nil && v.errorre.MatchString(line):
+			issues = append(issues, Issue{Severity: SeverityError, Message: strings.TrimSpace(line)})
+		case v.warningre != nil && v.warningre.MatchString(line):
+			issues = append(issues, Issue{Severity: SeverityWarning, Message: strings.TrimSpace(line)})
+		}
+	}
+	return issues
+}
+
+// ExitCodeIssues adds an issue for an error or warning exit code unless the
+// output already contains an issue of the same or a higher severity.
+func (v *external) ExitCodeIssues(code int, issues []Issue) ([]Issue, bool) {
+	if code == 0 {
+		return issues, true
+	}
+	var errors, warnings bool
+	for _, issue := range issues {
+		errors = errors || issue.Severity == SeverityError
+		warnings = warnings || issue.Severity == SeverityWarning
+	}
+	msg := fmt.Sprintf("%s exited with code %d", v.def.Executable, code)
+	for _, c := range v.def.ErrorCodes {
+		if c == code {
+			if !errors {
+				issues = append(issues, Issue{Severity: SeverityError, Code: "exit-code", Message: msg})
+			}
+			return issues, true
+		}
+	}
+	for _, c := range v.def.WarningCodes {
+		if c == code {
+			if !errors && !warnings {
+				issues = append(issues, Issue{Severity: SeverityWarning, Code: "exit-code", Message: msg})
+			}
+			return issues, true
+		}
+	}
+	return nil, false
+}
+
+func (v *external) Render(w io.Writer, badge []byte, rep *Report, results interface{}, user, repo, csrftoken string) error {
+	title := v.def.Title
+	if title == "" {
+		title = strings.ToUpper(v.def.Name)
+	}
+	head := fmt.Sprintf("%s validation for %s/%s", title, user, repo)
+	return renderReport(w, badge, head, rep, nil, results.(string), csrftoken)
+}
@@ -0,0 +1,77 @@
+package validators
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/G-Node/gin-valid/internal/config"
+)
+
+// ErrTimedOut is wrapped by the error Run returns if the validator was
+// stopped because it did not finish within its time limit.
+var ErrTimedOut = errors.New("validator timed out")
+
+// limitsFor returns the resource limits of the validator with the given name.
+// Limits set for the validator in config.ValidatorLimits replace the
+// corresponding server wide limits.
+func limitsFor(name string) config.Limits {
+	srvcfg := config.Read()
+	limits := srvcfg.Limits
+	override, ok := srvcfg.ValidatorLimits[name]
+	if !ok {
+		return limits
+	}
+	if override.Timeout != 0 {
+		limits.Timeout = override.Timeout
+	}
+	if override.Memory != 0 {
+		limits.Memory = override.Memory
+	}
+	if override.CPU != 0 {
+		limits.CPU = override.CPU
+	}
+	if override.Output != 0 {
+		limits.Output = override.Output
+	}
+	return limits
+}
+
+// limitedBuffer is a buffer for the output of a validator that holds at most
+// 'max' bytes. Once the output exceeds the limit, further writes are
+// discarded and 'exceeded' is called once to stop the validator. A limit of 0
+// disables it. The buffer is not embedded, so that io.Copy cannot bypass the
+// limit through bytes.Buffer.ReadFrom.
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	max      int
+	overflow bool
+	exceeded func()
+}
+
+func (lb *limitedBuffer) Write(p []byte) (int, error) {
+	if lb.max <= 0 {
+		return lb.buf.Write(p)
+	}
+	if lb.overflow {
+		return len(p), nil
+	}
+	if lb.buf.Len()+len(p) > lb.max {
+		lb.overflow = true
+		lb.buf.Write(p[:lb.max-lb.buf.Len()])
+		if lb.exceeded != nil {
+			lb.exceeded()
+		}
+		return len(p), nil
+	}
+	return lb.buf.Write(p)
+}
+
+// Bytes returns the buffered output.
+func (lb *limitedBuffer) Bytes() []byte {
+	return lb.buf.Bytes()
+}
+
+// String returns the buffered output as a string.
+func (lb *limitedBuffer) String() string {
+	return lb.buf.String()
+}
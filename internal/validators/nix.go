@@ -0,0 +1,94 @@
+package validators
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/G-Node/gin-valid/internal/config"
+)
+
+// nix runs the NIX validator on all NIX files found in a repository.
+type nix struct{}
+
+func init() {
+	Register(nix{})
+}
+
+func (nix) Name() string {
+	return "nix"
+}
+
+// Files returns the NIX files (.nix) selected by the nixconfig section of the
+// validation config or all NIX files in the repository.
+func (nix) Files(valroot string, valcfg Validationcfg) ([]string, error) {
+	return selectFiles(valroot, valcfg.Nixcfg, func(path string) bool {
+		return strings.ToLower(filepath.Ext(path)) == ".nix"
+	})
+}
+
+func (nix) Command(valroot string, files []string, valcfg Validationcfg) *exec.Cmd {
+	return exec.Command(config.Read().Exec["nix"].Path, files...)
+}
+
+// Parse returns the plain text output of the NIX validator.
+func (nix) Parse(output []byte) (interface{}, error) {
+	return string(output), nil
+}
+
+// nixFileRe matches the NIX file paths mentioned in the validator output.
+var nixFileRe = regexp.MustCompile(`(\S+\.nix)\b`)
+
+// Issues reads the issues from the NIX validator output. Indented lines
+// following an "Errors:" or "Warnings:" heading are issues of the respective
+// severity in the file that was last mentioned. If the output reports errors
+// or warnings that are not listed under a heading, the summary line is added
+// as an issue instead.
+func (nix) Issues(results interface{}) []Issue {
+	issues := make([]Issue, 0)
+	var file string
+	var severity Severity
+	var errors, warnings bool
+	var errline, warnline string
+	for _, line := range strings.Split(results.(string), "\n") {
+		trimmed := strings.TrimSpace(line)
+		heading := strings.ToLower(strings.TrimSuffix(trimmed, ":"))
+		switch {
+		case trimmed == "":
+			continue
+		case strings.Contains(line, "with errors"):
+			errline = trimmed
+		case strings.Contains(line, "with warnings"):
+			warnline = trimmed
+		case heading == "errors" && trimmed != heading:
+			severity = SeverityError
+		case heading == "warnings" && trimmed != heading:
+			severity = SeverityWarning
+		case trimmed == line:
+			// unindented line: ends the list of issues of the last heading
+			severity = ""
+			if match := nixFileRe.FindStringSubmatch(line); match != nil {
+				file = strings.TrimSuffix(match[1], ":")
+			}
+		case severity != "":
+			issues = append(issues, Issue{Severity: severity, File: file, Message: trimmed})
+			errors = errors || severity == SeverityError
+			warnings = warnings || severity == SeverityWarning
+		}
+	}
+	if errline != "" && !errors {
+		issues = append(issues, Issue{Severity: SeverityError, Message: errline})
+	}
+	if warnline != "" && !warnings {
+		issues = append(issues, Issue{Severity: SeverityWarning, Message: warnline})
+	}
+	return issues
+}
+
+func (nix) Render(w io.Writer, badge []byte, rep *Report, results interface{}, user, repo, csrftoken string) error {
+	head := fmt.Sprintf("NIX validation for %s/%s", user, repo)
+	return renderReport(w, badge, head, rep, nil, results.(string), csrftoken)
+}
@@ -0,0 +1,126 @@
+package validators
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/G-Node/gin-valid/internal/config"
+)
+
+// odml runs the odML validator on all odML files found in a repository.
+type odml struct{}
+
+func init() {
+	Register(odml{})
+}
+
+func (odml) Name() string {
+	return "odml"
+}
+
+// odmlExtensions are the extensions of odML files if the validation config
+// does not list any.
+var odmlExtensions = []string{".odml", ".xml"}
+
+// odmlExtensionsOf returns the lowercase extensions of odML files configured
+// in the validation config, without the leading dot.
+func odmlExtensionsOf(valcfg Validationcfg) []string {
+	extensions := valcfg.Odmlcfg.Extensions
+	if len(extensions) == 0 {
+		extensions = odmlExtensions
+	}
+	exts := make([]string, 0, len(extensions))
+	for _, ext := range extensions {
+		exts = append(exts, strings.TrimPrefix(strings.ToLower(ext), "."))
+	}
+	return exts
+}
+
+// Files returns the odML files selected by the odmlconfig section of the
+// validation config or all odML files in the repository. Files are odML files
+// if they have one of the configured extensions (.odml and .xml by default).
+func (odml) Files(valroot string, valcfg Validationcfg) ([]string, error) {
+	extensions := odmlExtensionsOf(valcfg)
+	return selectFiles(valroot, valcfg.Odmlcfg.Filecfg, func(path string) bool {
+		extension := strings.ToLower(filepath.Ext(path))
+		for _, ext := range extensions {
+			if extension == "."+ext {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (odml) Command(valroot string, files []string, valcfg Validationcfg) *exec.Cmd {
+	return exec.Command(config.Read().Exec["odml"].Path, files...)
+}
+
+// Parse returns the plain text output of the odML validator.
+func (odml) Parse(output []byte) (interface{}, error) {
+	return string(output), nil
+}
+
+// odmlSeverities maps the message tags of the odML validator to severities.
+var odmlSeverities = []struct {
+	tag      string
+	severity Severity
+}{
+	{"[fatal]", SeverityError},
+	{"[error]", SeverityError},
+	{"[warning]", SeverityWarning},
+	{"[info]", SeverityInfo},
+}
+
+// odmlFileRe returns the expression matching the paths of odML files with
+// the given extensions mentioned in the validator output.
+func odmlFileRe(extensions []string) *regexp.Regexp {
+	quoted := make([]string, 0, len(extensions))
+	for _, ext := range extensions {
+		quoted = append(quoted, regexp.QuoteMeta(ext))
+	}
+	return regexp.MustCompile(`(?i)(\S+\.(?:` + strings.Join(quoted, "|") + `))(?:\W|$)`)
+}
+
+// Issues reads the issues from the odML validator output, recognising odML
+// files by the default extensions.
+func (odml) Issues(results interface{}) []Issue {
+	return odmlIssues(results.(string), odmlFileRe(odmlExtensionsOf(Validationcfg{})))
+}
+
+// ConfigIssues reads the issues from the odML validator output, recognising
+// odML files by the extensions configured in the validation config.
+func (odml) ConfigIssues(results interface{}, valcfg Validationcfg) []Issue {
+	return odmlIssues(results.(string), odmlFileRe(odmlExtensionsOf(valcfg)))
+}
+
+// odmlIssues reads the issues from the odML validator output. Every line with
+// a severity tag is an issue in the file matching 'filere' that was last
+// mentioned on a line without a tag.
+func odmlIssues(output string, filere *regexp.Regexp) []Issue {
+	issues := make([]Issue, 0)
+	var file string
+lines:
+	for _, line := range strings.Split(output, "\n") {
+		for _, s := range odmlSeverities {
+			if idx := strings.Index(line, s.tag); idx >= 0 {
+				msg := strings.TrimSpace(line[idx+len(s.tag):])
+				issues = append(issues, Issue{Severity: s.severity, File: file, Message: msg})
+				continue lines
+			}
+		}
+		if match := filere.FindStringSubmatch(line); match != nil {
+			file = strings.Trim(match[1], "'\":")
+		}
+	}
+	return issues
+}
+
+func (odml) Render(w io.Writer, badge []byte, rep *Report, results interface{}, user, repo, csrftoken string) error {
+	head := fmt.Sprintf("odML validation for %s/%s", user, repo)
+	return renderReport(w, badge, head, rep, nil, results.(string), csrftoken)
+}
@@ -0,0 +1,140 @@
+package validators
+
+import (
+	"encoding/json"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/resources"
+	"github.com/G-Node/gin-valid/internal/resources/templates"
+)
+
+// Severity denotes how serious an issue found by a validator is.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityInfo    Severity = "info"
+)
+
+// Issue is a single problem reported by a validator.
+type Issue struct {
+	Severity Severity `json:"severity"`
+	// Code identifies the kind of issue, if the validator reports one.
+	Code string `json:"code,omitempty"`
+	// File is the path of the affected file relative to the repository
+	// root, if the issue concerns a single file.
+	File    string `json:"file,omitempty"`
+	Message string `json:"message"`
+}
+
+// Report is the structured outcome of a validation run. It is the same for
+// all validators and is stored next to the raw validator output in
+// config.Label.ResultsReport.
+type Report struct {
+	Validator string `json:"validator"`
+	// Version is the version of the validator executable, if known.
+	Version string `json:"version,omitempty"`
+	// Commit is the validated commit of the repository, if known.
+	Commit   string    `json:"commit,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// Duration of the validator run in seconds.
+	Duration float64 `json:"duration"`
+	// Files is the number of files or directories passed to the validator.
+	Files    int     `json:"files"`
+	Errors   int     `json:"errors"`
+	Warnings int     `json:"warnings"`
+	Issues   []Issue `json:"issues"`
+}
+
+// NewReport creates a report for the provided issues and counts them.
+func NewReport(validator string, issues []Issue) *Report {
+	rep := &Report{Validator: validator, Issues: issues}
+	if rep.Issues == nil {
+		rep.Issues = []Issue{}
+	}
+	for _, issue := range rep.Issues {
+		switch issue.Severity {
+		case SeverityError:
+			rep.Errors++
+		case SeverityWarning:
+			rep.Warnings++
+		}
+	}
+	return rep
+}
+
+// Badge returns the badge corresponding to the issues in the report.
+func (rep *Report) Badge() string {
+	switch {
+	case rep.Errors > 0:
+		return resources.ErrorBadge
+	case rep.Warnings > 0:
+		return resources.WarningBadge
+	default:
+		return resources.SuccessBadge
+	}
+}
+
+// WriteReport stores the report in the results directory 'resdir'.
+func WriteReport(resdir string, rep *Report) error {
+	content, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	fp := filepath.Join(resdir, config.Read().Label.ResultsReport)
+	return ioutil.WriteFile(fp, content, os.ModePerm)
+}
+
+// ReadReport reads the report stored in the results directory 'resdir'.
+func ReadReport(resdir string) (*Report, error) {
+	fp := filepath.Join(resdir, config.Read().Label.ResultsReport)
+	content, err := ioutil.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+	rep := &Report{}
+	err = json.Unmarshal(content, rep)
+	if err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
+
+// LoadReport returns the report stored in 'resdir'. Results of validations
+// that ran before reports were stored only have the raw output, so for these
+// the report is created from the parsed results without any timings.
+func LoadReport(v Validator, resdir string, results interface{}) *Report {
+	rep, err := ReadReport(resdir)
+	if err == nil {
+		return rep
+	}
+	return NewReport(v.Name(), v.Issues(results))
+}
+
+// reportInfo is the data required by the templates.ValidationResults
+// template.
+type reportInfo struct {
+	Badge  template.HTML
+	Header string
+	Report *Report
+	// Details are validator specific lines shown below the issues.
+	Details []string
+	// Output is the plain text output of the validator, if it is worth
+	// showing.
+	Output string
+}
+
+// renderReport renders the issues of a report, followed by validator
+// specific details and output, using the ValidationResults template.
+func renderReport(w io.Writer, badge []byte, header string, rep *Report, details []string, output, csrftoken string) error {
+	info := reportInfo{template.HTML(badge), header, rep, details, output}
+	return renderTemplate(w, templates.ValidationResults, info, csrftoken)
+}
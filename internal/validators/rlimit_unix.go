@@ -0,0 +1,43 @@
+//go:build !windows
+// +build !windows
+
+package validators
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/log"
+)
+
+// applyRlimits wraps the command in a shell that sets the memory and CPU
+// limits before replacing itself with the validator, so the limits apply to
+// the validator process from its start.
+func applyRlimits(cmd *exec.Cmd, limits config.Limits) {
+	if limits.Memory <= 0 && limits.CPU <= 0 {
+		return
+	}
+	if !strings.ContainsRune(cmd.Path, filepath.Separator) {
+		// the executable was not found; leave it to cmd.Start to fail
+		return
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		log.ShowWrite("[Warning] cannot apply resource limits to %q: %s", cmd.Path, err.Error())
+		return
+	}
+	var script strings.Builder
+	if limits.Memory > 0 {
+		// ulimit expects KiB
+		fmt.Fprintf(&script, "ulimit -v %d && ", limits.Memory*1024)
+	}
+	if limits.CPU > 0 {
+		fmt.Fprintf(&script, "ulimit -t %d && ", limits.CPU)
+	}
+	script.WriteString(`exec "$0" "$@"`)
+	cmd.Args = append([]string{sh, "-c", script.String(), cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = sh
+}
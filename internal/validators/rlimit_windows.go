@@ -0,0 +1,15 @@
+package validators
+
+import (
+	"os/exec"
+
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/log"
+)
+
+// applyRlimits logs that memory and CPU limits are not supported on Windows.
+func applyRlimits(cmd *exec.Cmd, limits config.Limits) {
+	if limits.Memory > 0 || limits.CPU > 0 {
+		log.ShowWrite("[Warning] memory and CPU limits are not supported on this platform")
+	}
+}
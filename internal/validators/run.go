@@ -0,0 +1,286 @@
+package validators
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/log"
+	"github.com/G-Node/gin-valid/internal/resources/templates"
+)
+
+// Run runs the validator on the repository in 'valroot' and saves the results,
+// the report and the badge to 'resdir' for later viewing.
+// The validator is stopped if 'ctx' is cancelled while it runs.
+func Run(ctx context.Context, v Validator, valroot, resdir string) error {
+	srvcfg := config.Read()
+	valcfg := ReadValidationConfig(valroot)
+
+	files, err := v.Files(valroot, valcfg)
+	if err != nil {
+		err = fmt.Errorf("[Error] while looking for %s files in repository at %q: %s", v.Name(), valroot, err.Error())
+		log.ShowWrite(err.Error())
+		return err
+	}
+
+	// The validator is stopped when it exceeds its time limit, which a
+	// repository can only reduce, or writes more output than allowed.
+	limits := limitsFor(v.Name())
+	timeout := limits.Timeout
+	if valcfg.Timeout > 0 && (timeout <= 0 || valcfg.Timeout < timeout) {
+		timeout = valcfg.Timeout
+	}
+	timectx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		timectx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
+	runctx, stop := context.WithCancel(timectx)
+	defer stop()
+
+	out := &limitedBuffer{max: limits.Output, exceeded: stop}
+	serr := &limitedBuffer{max: limits.Output, exceeded: stop}
+	cmd := v.Command(valroot, files, valcfg)
+	log.ShowWrite("[Info] Running %s validation: %v", v.Name(), cmd.Args)
+	backend := backendFor(v.Name())
+	cmd, err = backend.Prepare(cmd, valroot, limits)
+	if err != nil {
+		err = fmt.Errorf("[Error] preparing %s validation of %q: %s", v.Name(), valroot, err.Error())
+		log.ShowWrite(err.Error())
+		return err
+	}
+	cmd.Stdout = out
+	cmd.Stderr = serr
+	exitcode := 0
+	started := time.Now()
+	err = runCommand(runctx, cmd)
+	if s, ok := backend.(stopper); ok && runctx.Err() != nil {
+		s.Stop(cmd)
+	}
+	if out.overflow || serr.overflow {
+		err = fmt.Errorf("[Error] %s validation of %q stopped after writing more than %d bytes of output", v.Name(), valroot, limits.Output)
+		log.ShowWrite(err.Error())
+		return err
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			log.ShowWrite("[Info] %s validation of %q cancelled", v.Name(), valroot)
+			return ctx.Err()
+		}
+		if timectx.Err() != nil {
+			err = fmt.Errorf("[Error] %s validation of %q stopped after %d seconds: %w", v.Name(), valroot, timeout, ErrTimedOut)
+			log.ShowWrite(err.Error())
+			return err
+		}
+		exiterr, isexit := err.(*exec.ExitError)
+		if _, ok := v.(ExitCodeChecker); !ok || !isexit {
+			err = fmt.Errorf("[Error] running %s validation (%s): '%s', '%s'", v.Name(), valroot, err.Error(), serr.String())
+			log.ShowWrite(err.Error())
+			return err
+		}
+		exitcode = exiterr.ExitCode()
+	}
+	finished := time.Now()
+
+	// We need this for both the writing of the result and the badge
+	output := out.Bytes()
+
+	outFile := filepath.Join(resdir, srvcfg.Label.ResultsFile)
+	err = ioutil.WriteFile(outFile, output, os.ModePerm)
+	if err != nil {
+		err = fmt.Errorf("[Error] writing results file for %q", valroot)
+		log.ShowWrite(err.Error())
+		return err
+	}
+
+	results, err := v.Parse(output)
+	if err != nil {
+		err = fmt.Errorf("[Error] parsing %s results: %s", v.Name(), err.Error())
+		log.ShowWrite(err.Error())
+		return err
+	}
+
+	issues := v.Issues(results)
+	if ci, ok := v.(ConfigIssuer); ok {
+		issues = ci.ConfigIssues(results, valcfg)
+	}
+	if ecc, ok := v.(ExitCodeChecker); ok {
+		if issues, ok = ecc.ExitCodeIssues(exitcode, issues); !ok {
+			err = fmt.Errorf("[Error] running %s validation (%s): exit code %d, '%s'", v.Name(), valroot, exitcode, serr.String())
+			log.ShowWrite(err.Error())
+			return err
+		}
+	}
+
+	rep := NewReport(v.Name(), issues)
+	rep.Version = Version(v.Name())
+	rep.Commit = repoCommit(valroot)
+	rep.Started = started
+	rep.Finished = finished
+	rep.Duration = finished.Sub(started).Seconds()
+	rep.Files = len(files)
+	err = WriteReport(resdir, rep)
+	if err != nil {
+		err = fmt.Errorf("[Error] writing report for %q: %s", valroot, err.Error())
+		log.ShowWrite(err.Error())
+		return err
+	}
+
+	badge := rep.Badge()
+	outBadge := filepath.Join(resdir, srvcfg.Label.ResultsBadge)
+	err = ioutil.WriteFile(outBadge, []byte(badge), os.ModePerm)
+	if err != nil {
+		err = fmt.Errorf("[Error] writing results badge for %q", valroot)
+		log.ShowWrite(err.Error())
+		return err
+	}
+
+	log.ShowWrite("[Info] finished validating repo at %q", valroot)
+	return nil
+}
+
+// repoCommit returns the commit checked out in the repository at 'valroot'
+// or an empty string if it cannot be determined.
+func repoCommit(valroot string) string {
+	out, err := exec.Command("git", "-C", valroot, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// runCommand starts the command and waits for it to finish. The command is
+// killed if 'ctx' is cancelled first.
+func runCommand(ctx context.Context, cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// findFiles walks the repository at 'valroot' and returns the paths of all
+// files for which 'match' returns true. Errors encountered while walking the
+// tree are logged and the affected path is skipped.
+func findFiles(valroot string, match func(path string) bool) ([]string, error) {
+	return findFilesIn(valroot, valroot, nil, match)
+}
+
+// findFilesIn walks the directory 'dir' in the repository at 'valroot' and
+// returns the paths of all files for which 'match' returns true. The git
+// directory and paths matching one of the 'exclude' patterns are skipped.
+func findFilesIn(valroot, dir string, exclude []string, match func(path string) bool) ([]string, error) {
+	files := make([]string, 0)
+	finder := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// something went wrong; log this and continue
+			log.ShowWrite("[Error] directory walk caused error at %q: %s", path, err.Error())
+			return nil
+		}
+		relpath, err := filepath.Rel(valroot, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" || isExcluded(relpath, exclude) {
+				return filepath.SkipDir
+			}
+			// nothing to do; continue
+			return nil
+		}
+		if !isExcluded(relpath, exclude) && match(path) {
+			files = append(files, path)
+		}
+		return nil
+	}
+	err := filepath.Walk(dir, finder)
+	return files, err
+}
+
+// selectFiles returns the files in the repository at 'valroot' selected by
+// 'fcfg'. Directories are searched for files for which 'match' returns true;
+// files listed in 'fcfg' are returned regardless of 'match'.
+func selectFiles(valroot string, fcfg Filecfg, match func(path string) bool) ([]string, error) {
+	if len(fcfg.Files) == 0 {
+		return findFilesIn(valroot, valroot, fcfg.Exclude, match)
+	}
+	seen := make(map[string]bool)
+	files := make([]string, 0)
+	add := func(paths ...string) {
+		for _, path := range paths {
+			if !seen[path] {
+				seen[path] = true
+				files = append(files, path)
+			}
+		}
+	}
+	for _, pattern := range fcfg.Files {
+		matches, err := filepath.Glob(filepath.Join(valroot, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid file pattern %q: %s", pattern, err.Error())
+		}
+		if len(matches) == 0 {
+			log.ShowWrite("[Info] file pattern %q did not match any paths", pattern)
+		}
+		for _, path := range matches {
+			relpath, err := filepath.Rel(valroot, path)
+			if err != nil || relpath == ".." || strings.HasPrefix(relpath, ".."+string(filepath.Separator)) {
+				log.ShowWrite("[Warning] skipping path %q outside of the repository", path)
+				continue
+			}
+			if isExcluded(relpath, fcfg.Exclude) {
+				continue
+			}
+			// annexed files may be links to content that is not available
+			info, err := os.Lstat(path)
+			if err != nil {
+				log.ShowWrite("[Warning] skipping path %q: %s", relpath, err.Error())
+				continue
+			}
+			if !info.IsDir() {
+				add(path)
+				continue
+			}
+			found, err := findFilesIn(valroot, path, fcfg.Exclude, match)
+			if err != nil {
+				return nil, err
+			}
+			add(found...)
+		}
+	}
+	return files, nil
+}
+
+// renderTemplate renders the provided results template inside the main layout,
+// whose forms carry 'csrftoken'.
+func renderTemplate(w io.Writer, content string, info interface{}, csrftoken string) error {
+	tmpl := template.New("layout").Funcs(templates.LayoutFuncs(csrftoken))
+	tmpl, err := tmpl.Parse(templates.Layout)
+	if err != nil {
+		return err
+	}
+	tmpl, err = tmpl.Parse(content)
+	if err != nil {
+		return err
+	}
+	return tmpl.ExecuteTemplate(w, "layout", info)
+}
@@ -0,0 +1,285 @@
+package validators
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/log"
+	"github.com/google/uuid"
+)
+
+// Backend prepares the commands of validators for the environment they are
+// executed in.
+type Backend interface {
+	// Name returns the name of the backend as it is used in the server
+	// configuration.
+	Name() string
+	// Available returns an error if the backend cannot be used on this host.
+	Available() error
+	// Prepare returns the command that runs the command 'cmd', which
+	// validates the repository at 'valroot', in the backend within the
+	// memory and CPU limits 'limits'.
+	Prepare(cmd *exec.Cmd, valroot string, limits config.Limits) (*exec.Cmd, error)
+}
+
+// stopper is implemented by backends whose validators keep running when the
+// command returned by Prepare is killed.
+type stopper interface {
+	// Stop stops the validator started with the prepared command 'cmd'.
+	Stop(cmd *exec.Cmd)
+}
+
+// backends are the execution backends that can be selected in
+// config.Sandbox.Backend.
+var backends = map[string]Backend{
+	"direct": direct{},
+	"bwrap":  bwrap{},
+}
+
+// sandboxEnv lists the environment variables passed on to sandboxed
+// validators. All others may hold secrets of the server.
+var sandboxEnv = []string{"PATH", "HOME", "LANG", "LC_ALL", "TZ"}
+
+// CheckSandbox returns the name of the backend validators are executed with
+// and an error if the configured backend is not available, in which case
+// validators are run directly.
+func CheckSandbox() (string, error) {
+	name := config.Read().Sandbox.Backend
+	backend, ok := backends[name]
+	if !ok {
+		return "direct", fmt.Errorf("unknown sandbox backend %q", name)
+	}
+	if err := backend.Available(); err != nil {
+		return "direct", err
+	}
+	return backend.Name(), nil
+}
+
+// backendFor returns the backend of the validator: the container backend if
+// a container image is configured for it and the configured backend
+// otherwise, falling back to running validators directly if it is not
+// available.
+func backendFor(validator string) Backend {
+	if image := config.Read().Exec[validator].Image; image != "" {
+		backend := container{image: image}
+		err := backend.Available()
+		if err == nil {
+			return backend
+		}
+		log.ShowWrite("[Warning] running %s validator without container: %s", validator, err.Error())
+	}
+	name, err := CheckSandbox()
+	if err != nil {
+		log.ShowWrite("[Warning] running %s validator without sandbox: %s", validator, err.Error())
+	}
+	return backends[name]
+}
+
+// direct runs validators as the server user with full access to the host.
+type direct struct{}
+
+func (direct) Name() string {
+	return "direct"
+}
+
+func (direct) Available() error {
+	return nil
+}
+
+func (direct) Prepare(cmd *exec.Cmd, valroot string, limits config.Limits) (*exec.Cmd, error) {
+	applyRlimits(cmd, limits)
+	return cmd, nil
+}
+
+// bwrap runs validators with bubblewrap in new user, network, mount, PID, IPC
+// and UTS namespaces.
+type bwrap struct{}
+
+func (bwrap) Name() string {
+	return "bwrap"
+}
+
+func (bwrap) Available() error {
+	_, err := exec.LookPath(config.Read().Sandbox.Bwrap)
+	return err
+}
+
+func (bwrap) Prepare(cmd *exec.Cmd, valroot string, limits config.Limits) (*exec.Cmd, error) {
+	executable, err := exec.LookPath(config.Read().Sandbox.Bwrap)
+	if err != nil {
+		return nil, err
+	}
+	valroot, err = filepath.Abs(valroot)
+	if err != nil {
+		return nil, err
+	}
+	applyRlimits(cmd, limits)
+	args := []string{
+		executable,
+		"--unshare-all",
+		"--die-with-parent",
+		"--new-session",
+		"--ro-bind", "/", "/",
+		"--dev", "/dev",
+		"--proc", "/proc",
+		"--tmpfs", "/tmp",
+	}
+	for _, dir := range hiddenPaths() {
+		args = append(args, "--tmpfs", dir)
+	}
+	// the clone is mounted last so it is visible even if it is located in
+	// one of the hidden directories
+	workdir := cmd.Dir
+	if workdir == "" {
+		workdir = valroot
+	}
+	args = append(args, "--ro-bind", valroot, valroot, "--chdir", workdir, "--")
+	cmd.Args = append(append(args, cmd.Path), cmd.Args[1:]...)
+	cmd.Path = executable
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = filterEnv(env, sandboxEnv)
+	return cmd, nil
+}
+
+// container runs validators in a container of the image configured for them
+// in config.Executables, started with the container runtime
+// config.Sandbox.Runtime. The container has no network access, a read-only
+// root file system and no capabilities, and the repository clone is mounted
+// read-only at the same path as on the host.
+type container struct {
+	image string
+}
+
+func (container) Name() string {
+	return "container"
+}
+
+func (container) Available() error {
+	_, err := exec.LookPath(config.Read().Sandbox.Runtime)
+	return err
+}
+
+func (c container) Prepare(cmd *exec.Cmd, valroot string, limits config.Limits) (*exec.Cmd, error) {
+	runtime, err := exec.LookPath(config.Read().Sandbox.Runtime)
+	if err != nil {
+		return nil, err
+	}
+	valroot, err = filepath.Abs(valroot)
+	if err != nil {
+		return nil, err
+	}
+	workdir := cmd.Dir
+	if workdir == "" {
+		workdir = valroot
+	}
+	args := []string{
+		"run", "--rm", "-i",
+		"--name", "gin-valid-" + uuid.New().String(),
+		"--network", "none",
+		"--read-only",
+		"--tmpfs", "/tmp",
+		"--cap-drop", "ALL",
+		"--security-opt", "no-new-privileges",
+		"--env", "HOME=/tmp",
+		"--volume", valroot + ":" + valroot + ":ro",
+		"--workdir", workdir,
+	}
+	if uid, gid := os.Getuid(), os.Getgid(); uid >= 0 && gid >= 0 {
+		// results must be readable by the server user
+		args = append(args, "--user", fmt.Sprintf("%d:%d", uid, gid))
+	}
+	if limits.Memory > 0 {
+		args = append(args, "--memory", fmt.Sprintf("%dm", limits.Memory))
+	}
+	if limits.CPU > 0 {
+		args = append(args, "--ulimit", fmt.Sprintf("cpu=%d", limits.CPU))
+	}
+	// the command was created from the path of the executable in the image,
+	// which is not resolved on the host
+	args = append(append(args, c.image), cmd.Args...)
+	prepared := exec.Command(runtime, args...)
+	prepared.Stdin = cmd.Stdin
+	return prepared, nil
+}
+
+// Stop removes the container of a validator whose runtime client was killed,
+// which leaves the container running.
+func (container) Stop(cmd *exec.Cmd) {
+	for idx, arg := range cmd.Args[:len(cmd.Args)-1] {
+		if arg != "--name" {
+			continue
+		}
+		name := cmd.Args[idx+1]
+		if out, err := exec.Command(cmd.Path, "rm", "--force", name).CombinedOutput(); err != nil {
+			log.ShowWrite("[Error] stopping container %q: %s: %s", name, err.Error(), strings.TrimSpace(string(out)))
+		}
+		return
+	}
+}
+
+// hiddenPaths returns the absolute paths of all existing directories that
+// sandboxed validators must not be able to read: the directories of the
+// server, the configuration directories of the server user, which hold the
+// session keys of the GIN client, and the paths configured in
+// config.Sandbox.Hide.
+func hiddenPaths() []string {
+	srvcfg := config.Read()
+	paths := []string{
+		srvcfg.Dir.Temp,
+		srvcfg.Dir.Result,
+		srvcfg.Dir.Log,
+		srvcfg.Dir.Tokens,
+		srvcfg.Dir.Queue,
+		srvcfg.Dir.Webhooks,
+		os.Getenv("GIN_CONFIG_DIR"),
+	}
+	if cfgdir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, cfgdir)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".ssh"))
+	}
+	paths = append(paths, srvcfg.Sandbox.Hide...)
+
+	hidden := make([]string, 0, len(paths))
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		abspath, err := filepath.Abs(path)
+		if err != nil || abspath == "/" || seen[abspath] {
+			continue
+		}
+		if info, err := os.Stat(abspath); err != nil || !info.IsDir() {
+			continue
+		}
+		seen[abspath] = true
+		hidden = append(hidden, abspath)
+	}
+	return hidden
+}
+
+// filterEnv returns the entries of the environment 'env' whose name is
+// listed in 'keep'.
+func filterEnv(env []string, keep []string) []string {
+	filtered := make([]string, 0, len(keep))
+	for _, entry := range env {
+		name := strings.SplitN(entry, "=", 2)[0]
+		for _, k := range keep {
+			if name == k {
+				filtered = append(filtered, entry)
+				break
+			}
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,75 @@
+/*
+Package validators contains the validators supported by the service and the
+registry that is used to look them up by name. Each validator is a single type
+implementing the Validator interface that registers itself on init.
+*/
+package validators
+
+import (
+	"io"
+	"os/exec"
+	"sort"
+	"sync"
+)
+
+// Validator describes everything the service needs to know to run a
+// validation on a repository and to display its results.
+type Validator interface {
+	// Name returns the lowercase name of the validator as it is used in URLs,
+	// the results directory and the server configuration.
+	Name() string
+	// Files returns the files or directories in the repository at 'valroot'
+	// that should be validated.
+	Files(valroot string, valcfg Validationcfg) ([]string, error)
+	// Command returns the command that validates the provided files.
+	Command(valroot string, files []string, valcfg Validationcfg) *exec.Cmd
+	// Parse reads the validator output as it is stored in the results file.
+	Parse(output []byte) (interface{}, error)
+	// Issues converts the parsed results to the issues of the common report.
+	Issues(results interface{}) []Issue
+	// Render writes the results page for the report and the parsed results.
+	// The page embeds 'csrftoken' in the forms of the layout.
+	Render(w io.Writer, badge []byte, rep *Report, results interface{}, user, repo, csrftoken string) error
+}
+
+// ConfigIssuer is implemented by validators whose issues depend on the
+// validation config of the repository. Issues is used instead if the config
+// is not known, e.g. for results from before reports were stored.
+type ConfigIssuer interface {
+	// ConfigIssues converts the parsed results of a validation with the
+	// given config to the issues of the common report.
+	ConfigIssues(results interface{}, valcfg Validationcfg) []Issue
+}
+
+var (
+	registry   = make(map[string]Validator)
+	registryMu sync.RWMutex
+)
+
+// Register adds a validator to the registry. A validator registered under a
+// name that is already in use replaces the existing one.
+func Register(v Validator) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[v.Name()] = v
+}
+
+// Get returns the validator registered under the provided name.
+func Get(name string) (Validator, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	v, ok := registry[name]
+	return v, ok
+}
+
+// Names returns the sorted names of all registered validators.
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
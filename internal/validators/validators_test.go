@@ -0,0 +1,611 @@
+package validators
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/resources"
+)
+
+func TestValiateBadConfig(t *testing.T) {
+	handleValidationConfig("wtf")
+}
+func TestValidateNotYAML(t *testing.T) {
+	f, _ := os.Create("testing-config.json")
+	f.WriteString("foo: somebody said I should put a colon here: so I did")
+	f.Close()
+	handleValidationConfig("testing-config.json")
+	os.RemoveAll("testing-config.json")
+}
+func TestValidateGoodConfig(t *testing.T) {
+	f, _ := os.Create("testing-config.json")
+	f.WriteString("empty: \"true\"")
+	f.Close()
+	handleValidationConfig("testing-config.json")
+	os.RemoveAll("testing-config.json")
+}
+func TestValidateBIDSNoData(t *testing.T) {
+	Run(context.Background(), bids{}, "wtf", "wtf")
+}
+func TestValidateNIXNoData(t *testing.T) {
+	Run(context.Background(), nix{}, "wtf", "wtf")
+}
+func TestValidateODMLNoData(t *testing.T) {
+	Run(context.Background(), odml{}, "wtf", "wtf")
+}
+func TestRegistry(t *testing.T) {
+	for _, name := range []string{"bids", "nix", "odml"} {
+		v, ok := Get(name)
+		if !ok {
+			t.Fatalf("validator %q is not registered", name)
+		}
+		if v.Name() != name {
+			t.Fatalf("validator registered as %q reports name %q", name, v.Name())
+		}
+	}
+	if _, ok := Get("wtf"); ok {
+		t.Fatal("unknown validator found in registry")
+	}
+	names := Names()
+	if len(names) != 3 || names[0] != "bids" || names[2] != "odml" {
+		t.Fatalf("unexpected registered validators: %v", names)
+	}
+}
+func TestExternalValidator(t *testing.T) {
+	def := config.ExternalValidator{
+		Name:         "csvcheck",
+		Executable:   "sh",
+		Args:         []string{"-c", "cat \"$@\"; exit 3", "csvcheck", "{files}"},
+		Patterns:     []string{"*.csv", "meta/*.txt"},
+		ErrorPattern: "^BAD",
+		ErrorCodes:   []int{4},
+		WarningCodes: []int{3},
+	}
+	err := RegisterExternal([]config.ExternalValidator{def})
+	if err != nil {
+		t.Fatalf("registering external validator failed: %s", err.Error())
+	}
+	defer func() {
+		registryMu.Lock()
+		delete(registry, def.Name)
+		registryMu.Unlock()
+	}()
+	if err = RegisterExternal([]config.ExternalValidator{def}); err == nil {
+		t.Fatal("registering a validator twice should fail")
+	}
+
+	valroot, _ := ioutil.TempDir("", "valroot")
+	defer os.RemoveAll(valroot)
+	resdir, _ := ioutil.TempDir("", "resdir")
+	defer os.RemoveAll(resdir)
+	os.Mkdir(filepath.Join(valroot, "meta"), 0755)
+	ioutil.WriteFile(filepath.Join(valroot, "data.csv"), []byte("a,b\n"), 0644)
+	ioutil.WriteFile(filepath.Join(valroot, "meta", "info.txt"), []byte("info\n"), 0644)
+	ioutil.WriteFile(filepath.Join(valroot, "notes.txt"), []byte("BAD\n"), 0644)
+
+	v, _ := Get(def.Name)
+	err = Run(context.Background(), v, valroot, resdir)
+	if err != nil {
+		t.Fatalf("running external validator failed: %s", err.Error())
+	}
+	srvcfg := config.Read()
+	output, _ := ioutil.ReadFile(filepath.Join(resdir, srvcfg.Label.ResultsFile))
+	if string(output) != "a,b\ninfo\n" {
+		t.Fatalf("unexpected validator output %q", output)
+	}
+	badge, _ := ioutil.ReadFile(filepath.Join(resdir, srvcfg.Label.ResultsBadge))
+	if string(badge) != resources.WarningBadge {
+		t.Fatal("exit code 3 should result in a warning badge")
+	}
+
+	rep, err := ReadReport(resdir)
+	if err != nil {
+		t.Fatalf("reading report failed: %s", err.Error())
+	}
+	if rep.Validator != def.Name || rep.Files != 2 || rep.Warnings != 1 || rep.Errors != 0 {
+		t.Fatalf("unexpected report: %+v", rep)
+	}
+	if rep.Finished.Before(rep.Started) {
+		t.Fatal("report finished before it started")
+	}
+
+	ecc := v.(ExitCodeChecker)
+	issues, _ := ecc.ExitCodeIssues(3, v.Issues("BAD things\n"))
+	if NewReport(def.Name, issues).Badge() != resources.ErrorBadge {
+		t.Fatal("error pattern should override warning exit code")
+	}
+	issues, _ = ecc.ExitCodeIssues(4, v.Issues(""))
+	if NewReport(def.Name, issues).Badge() != resources.ErrorBadge {
+		t.Fatal("exit code 4 should result in an error badge")
+	}
+	if _, ok := ecc.ExitCodeIssues(1, nil); ok {
+		t.Fatal("unmapped exit code should be a validator failure")
+	}
+}
+func TestContentPaths(t *testing.T) {
+	valroot, err := ioutil.TempDir("", "content-testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(valroot)
+	for _, fname := range []string{"a.nix", "sub/b.nix", "sub/skip/c.nix", "raw/d.dat", "raw/tmp/e.dat", ".git/f.dat"} {
+		fpath := filepath.Join(valroot, fname)
+		os.MkdirAll(filepath.Dir(fpath), 0755)
+		ioutil.WriteFile(fpath, []byte("data"), 0644)
+	}
+	valcfg := Validationcfg{}
+	valcfg.Contentcfg.Include = []string{"raw", "missing/*"}
+	valcfg.Contentcfg.Exclude = []string{"sub/skip", "tmp"}
+	paths, err := ContentPaths(nix{}, valroot, valcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]bool{"a.nix": true, "sub/b.nix": true, "raw/d.dat": true}
+	if len(paths) != len(expected) {
+		t.Fatalf("unexpected content paths: %v", paths)
+	}
+	for _, p := range paths {
+		if !expected[filepath.ToSlash(p)] {
+			t.Fatalf("unexpected content path %q in %v", p, paths)
+		}
+	}
+
+	// without exclude patterns, directories are kept as they are
+	valcfg.Contentcfg.Exclude = nil
+	paths, err = ContentPaths(bids{}, valroot, valcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 || paths[0] != "." || paths[1] != "raw" {
+		t.Fatalf("unexpected content paths: %v", paths)
+	}
+}
+func TestValidatorFileConfig(t *testing.T) {
+	valroot, err := ioutil.TempDir("", "files-testing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(valroot)
+	for _, fname := range []string{"a.nix", "data/b.nix", "data/old/c.nix", "meta.odml", "meta/d.xml", "meta/e.odml", "figure.xml"} {
+		fpath := filepath.Join(valroot, fname)
+		os.MkdirAll(filepath.Dir(fpath), 0755)
+		ioutil.WriteFile(fpath, []byte("data"), 0644)
+	}
+	cfg := `
+nixconfig:
+  files: ["data"]
+  exclude: ["old"]
+odmlconfig:
+  extensions: ["odml"]
+  exclude: ["meta/*"]
+timeout: 30
+`
+	cfgpath := filepath.Join(valroot, config.Read().Label.ValidationConfigFile)
+	ioutil.WriteFile(cfgpath, []byte(cfg), 0644)
+	valcfg := ReadValidationConfig(valroot)
+	if valcfg.Timeout != 30 {
+		t.Fatalf("unexpected timeout %d", valcfg.Timeout)
+	}
+
+	files, err := nix{}.Files(valroot, valcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != filepath.Join(valroot, "data", "b.nix") {
+		t.Fatalf("unexpected NIX files: %v", files)
+	}
+	files, err = odml{}.Files(valroot, valcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != filepath.Join(valroot, "meta.odml") {
+		t.Fatalf("unexpected odML files: %v", files)
+	}
+
+	// explicitly listed files are validated regardless of their extension
+	valcfg.Odmlcfg.Files = []string{"*.xml"}
+	files, err = odml{}.Files(valroot, valcfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != filepath.Join(valroot, "figure.xml") {
+		t.Fatalf("unexpected odML files: %v", files)
+	}
+}
+func TestValidatorTimeout(t *testing.T) {
+	def := config.ExternalValidator{
+		Name:       "sleeper",
+		Executable: "sleep",
+		Args:       []string{"10"},
+	}
+	v, err := newExternal(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valroot, _ := ioutil.TempDir("", "valroot")
+	defer os.RemoveAll(valroot)
+	resdir, _ := ioutil.TempDir("", "resdir")
+	defer os.RemoveAll(resdir)
+	cfgpath := filepath.Join(valroot, config.Read().Label.ValidationConfigFile)
+	ioutil.WriteFile(cfgpath, []byte("timeout: 1\n"), 0644)
+	start := time.Now()
+	err = Run(context.Background(), v, valroot, resdir)
+	if err == nil {
+		t.Fatal("validator exceeding the timeout should fail")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("validator was not stopped after the timeout")
+	}
+	if !errors.Is(err, ErrTimedOut) {
+		t.Fatalf("unexpected error for a timed out validator: %v", err)
+	}
+}
+func TestValidatorLimits(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("resource limits are not supported on windows")
+	}
+	srvcfg := config.Read()
+	original := srvcfg
+	defer config.Set(original)
+	srvcfg.ValidatorLimits = map[string]config.Limits{
+		"sleeper": {Timeout: 1},
+		"chatter": {Output: 1024},
+		"limited": {Memory: 512, CPU: 30},
+	}
+	config.Set(srvcfg)
+
+	valroot, _ := ioutil.TempDir("", "valroot")
+	defer os.RemoveAll(valroot)
+	resdir, _ := ioutil.TempDir("", "resdir")
+	defer os.RemoveAll(resdir)
+	run := func(def config.ExternalValidator) error {
+		v, err := newExternal(def)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return Run(context.Background(), v, valroot, resdir)
+	}
+
+	// the server timeout applies without a repository configuration
+	start := time.Now()
+	err := run(config.ExternalValidator{Name: "sleeper", Executable: "sleep", Args: []string{"10"}})
+	if !errors.Is(err, ErrTimedOut) || time.Since(start) > 5*time.Second {
+		t.Fatalf("validator exceeding the server timeout was not stopped: %v", err)
+	}
+
+	// endless output is cut off
+	err = run(config.ExternalValidator{Name: "chatter", Executable: "yes"})
+	if err == nil || errors.Is(err, ErrTimedOut) || !strings.Contains(err.Error(), "1024 bytes") {
+		t.Fatalf("validator exceeding the output limit was not stopped: %v", err)
+	}
+
+	// memory and CPU limits are set before the validator starts
+	err = run(config.ExternalValidator{Name: "limited", Executable: "sh", Args: []string{"-c", "ulimit -v; ulimit -t"}})
+	if err != nil {
+		t.Fatalf("limited validator failed: %s", err.Error())
+	}
+	output, _ := ioutil.ReadFile(filepath.Join(resdir, srvcfg.Label.ResultsFile))
+	if strings.Fields(string(output))[0] != "524288" || strings.Fields(string(output))[1] != "30" {
+		t.Fatalf("unexpected limits %q", output)
+	}
+}
+func TestValidatorIssues(t *testing.T) {
+	bidsout := []byte(`{"issues": {"errors": [{"key": "NOT_INCLUDED", "reason": "Files not included", "files": [{"file": {"relativePath": "/sub-01/x.txt"}}, {"file": {"relativePath": "/sub-02/y.txt"}}]}], "warnings": [{"key": "NO_AUTHORS", "reason": "No authors"}]}}`)
+	results, err := bids{}.Parse(bidsout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep := NewReport("bids", bids{}.Issues(results))
+	if rep.Errors != 2 || rep.Warnings != 1 {
+		t.Fatalf("unexpected BIDS counts: %+v", rep)
+	}
+	if rep.Issues[1] != (Issue{Severity: SeverityError, Code: "NOT_INCLUDED", File: "sub-02/y.txt", Message: "Files not included"}) {
+		t.Fatalf("unexpected BIDS issue: %+v", rep.Issues[1])
+	}
+	// file paths are relative to the repository root, not the BIDS root
+	var bidscfg Validationcfg
+	bidscfg.Bidscfg.BidsRoot = "data/bids"
+	issues := bids{}.ConfigIssues(results, bidscfg)
+	if issues[0].File != "data/bids/sub-01/x.txt" || issues[1].File != "data/bids/sub-02/y.txt" || issues[2].File != "" {
+		t.Fatalf("unexpected BIDS issues with BIDS root: %+v", issues)
+	}
+
+	nixout := `data/a.nix
+  Errors:
+    Block 'b': name is missing
+  Warnings:
+    Block 'b': no type
+data/a.nix: Validation completed with errors
+`
+	rep = NewReport("nix", nix{}.Issues(nixout))
+	if rep.Errors != 1 || rep.Warnings != 1 || rep.Issues[0].File != "data/a.nix" {
+		t.Fatalf("unexpected NIX issues: %+v", rep.Issues)
+	}
+	rep = NewReport("nix", nix{}.Issues("a.nix: Validation completed with warnings\n"))
+	if rep.Badge() != resources.WarningBadge {
+		t.Fatalf("unexpected NIX issues: %+v", rep.Issues)
+	}
+
+	odmlout := `Validating 'meta/x.odml'
+[error] Section[s1]: section type undefined
+[warning] Property[p1]: value is empty
+[fatal] file could not be parsed
+`
+	rep = NewReport("odml", odml{}.Issues(odmlout))
+	if rep.Errors != 2 || rep.Warnings != 1 || rep.Issues[0].File != "meta/x.odml" {
+		t.Fatalf("unexpected odML issues: %+v", rep.Issues)
+	}
+	if rep.Issues[0].Message != "Section[s1]: section type undefined" {
+		t.Fatalf("unexpected odML message %q", rep.Issues[0].Message)
+	}
+	// files are recognised by the extensions of the validation config
+	var valcfg Validationcfg
+	valcfg.Odmlcfg.Extensions = []string{".meta", "od+ml"}
+	odmlout = `Validating 'meta/y.meta'
+[error] Section[s1]: section type undefined
+Validating 'meta/z.od+ml':
+[warning] Property[p1]: value is empty
+`
+	issues = odml{}.ConfigIssues(odmlout, valcfg)
+	if len(issues) != 2 || issues[0].File != "meta/y.meta" || issues[1].File != "meta/z.od+ml" {
+		t.Fatalf("unexpected odML issues with configured extensions: %+v", issues)
+	}
+	issues = odml{}.Issues(odmlout)
+	if issues[0].File != "" {
+		t.Fatalf("file with extension that is not configured recognised: %+v", issues)
+	}
+	if NewReport("odml", odml{}.Issues("all good\n")).Badge() != resources.SuccessBadge {
+		t.Fatal("output without issues should result in a success badge")
+	}
+
+	var page bytes.Buffer
+	err = bids{}.Render(&page, []byte(resources.ErrorBadge), NewReport("bids", bids{}.Issues(results)), results, "user", "repo", "csrf-token")
+	if err != nil {
+		t.Fatalf("rendering BIDS results failed: %s", err.Error())
+	}
+	if !bytes.Contains(page.Bytes(), []byte("sub-02/y.txt")) {
+		t.Fatal("rendered BIDS results do not list the issues")
+	}
+	if !bytes.Contains(page.Bytes(), []byte(`name="csrf_token" value="csrf-token"`)) {
+		t.Fatal("rendered BIDS results without CSRF token in the logout form")
+	}
+}
+func TestCompare(t *testing.T) {
+	base := NewReport("bids", []Issue{
+		{Severity: SeverityError, Code: "NOT_INCLUDED", File: "a.txt", Message: "Files not included"},
+		{Severity: SeverityError, Code: "NOT_INCLUDED", File: "b.txt", Message: "Files not included"},
+		{Severity: SeverityWarning, Message: "no type"},
+		{Severity: SeverityWarning, Message: "no type"},
+	})
+	head := NewReport("bids", []Issue{
+		{Severity: SeverityError, Code: "NOT_INCLUDED", File: "b.txt", Message: "File not included"},
+		{Severity: SeverityError, Code: "NOT_INCLUDED", File: "c.txt", Message: "Files not included"},
+		{Severity: SeverityWarning, Message: "no type"},
+	})
+	cmp := Compare(base, head)
+	if len(cmp.Added) != 1 || cmp.Added[0].File != "c.txt" {
+		t.Fatalf("unexpected added issues: %+v", cmp.Added)
+	}
+	if len(cmp.Resolved) != 2 || cmp.Resolved[0].File != "a.txt" || cmp.Resolved[1].Message != "no type" {
+		t.Fatalf("unexpected resolved issues: %+v", cmp.Resolved)
+	}
+	if len(cmp.Unchanged) != 2 || cmp.Unchanged[0].Message != "File not included" {
+		t.Fatalf("unexpected unchanged issues: %+v", cmp.Unchanged)
+	}
+}
+func TestSandbox(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the sandbox is not supported on windows")
+	}
+	tmpdir, _ := ioutil.TempDir("", "sandbox")
+	defer os.RemoveAll(tmpdir)
+	valroot := filepath.Join(tmpdir, "clone")
+	resdir := filepath.Join(tmpdir, "results")
+	tokens := filepath.Join(tmpdir, "tokens")
+	for _, dir := range []string{valroot, resdir, tokens} {
+		os.MkdirAll(dir, 0755)
+	}
+	// the shim records its arguments and runs the command like bwrap would
+	argsfile := filepath.Join(tmpdir, "args")
+	shim := filepath.Join(tmpdir, "bwrap")
+	ioutil.WriteFile(shim, []byte("#!/bin/sh\nprintf '%s\\n' \"$@\" > "+argsfile+"\nwhile [ \"$1\" != \"--\" ]; do shift; done\nshift\nexec \"$@\"\n"), 0755)
+
+	srvcfg := config.Read()
+	original := srvcfg
+	defer config.Set(original)
+	srvcfg.Dir.Tokens = tokens
+	srvcfg.Sandbox = config.Sandbox{Backend: "bwrap", Bwrap: shim}
+	config.Set(srvcfg)
+	os.Setenv("GINVALID_TEST_SECRET", "secret")
+	defer os.Unsetenv("GINVALID_TEST_SECRET")
+
+	if backend, err := CheckSandbox(); err != nil || backend != "bwrap" {
+		t.Fatalf("unexpected sandbox backend %q: %v", backend, err)
+	}
+	v, err := newExternal(config.ExternalValidator{Name: "sandboxed", Executable: "env"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Run(context.Background(), v, valroot, resdir)
+	if err != nil {
+		t.Fatalf("sandboxed validator failed: %s", err.Error())
+	}
+	args, _ := ioutil.ReadFile(argsfile)
+	for _, expected := range []string{
+		"--unshare-all\n",
+		"--ro-bind\n/\n/\n",
+		"--tmpfs\n" + tokens + "\n",
+		"--ro-bind\n" + valroot + "\n" + valroot + "\n--chdir\n" + valroot + "\n--\n",
+	} {
+		if !strings.Contains(string(args), expected) {
+			t.Fatalf("sandbox arguments do not contain %q:\n%s", expected, args)
+		}
+	}
+	output, _ := ioutil.ReadFile(filepath.Join(resdir, srvcfg.Label.ResultsFile))
+	if strings.Contains(string(output), "GINVALID_TEST_SECRET") || !strings.Contains(string(output), "PATH=") {
+		t.Fatalf("unexpected sandbox environment:\n%s", output)
+	}
+
+	// validators run directly if the sandbox is not available
+	srvcfg.Sandbox.Bwrap = filepath.Join(tmpdir, "missing")
+	config.Set(srvcfg)
+	if backend, err := CheckSandbox(); err == nil || backend != "direct" {
+		t.Fatalf("unavailable sandbox selected %q", backend)
+	}
+	err = Run(context.Background(), v, valroot, resdir)
+	if err != nil {
+		t.Fatalf("validator without sandbox failed: %s", err.Error())
+	}
+	output, _ = ioutil.ReadFile(filepath.Join(resdir, srvcfg.Label.ResultsFile))
+	if !strings.Contains(string(output), "GINVALID_TEST_SECRET") {
+		t.Fatalf("validator did not run directly:\n%s", output)
+	}
+}
+func TestContainer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the runtime shim requires a POSIX shell")
+	}
+	tmpdir, _ := ioutil.TempDir("", "container")
+	defer os.RemoveAll(tmpdir)
+	valroot := filepath.Join(tmpdir, "clone")
+	resdir := filepath.Join(tmpdir, "results")
+	for _, dir := range []string{valroot, resdir} {
+		os.MkdirAll(dir, 0755)
+	}
+	// the shim records its arguments and runs the command following the
+	// image like the container runtime would
+	argsfile := filepath.Join(tmpdir, "args")
+	rmfile := filepath.Join(tmpdir, "rm")
+	shim := filepath.Join(tmpdir, "runtime")
+	ioutil.WriteFile(shim, []byte("#!/bin/sh\nif [ \"$1\" = rm ]; then printf '%s\\n' \"$@\" > "+rmfile+"; exit 0; fi\nprintf '%s\\n' \"$@\" > "+argsfile+"\nwhile [ \"$1\" != gin-valid/test ]; do shift; done\nshift\nexec \"$@\"\n"), 0755)
+
+	// executables are either a path or an object with a container image
+	var executables config.Executables
+	err := json.Unmarshal([]byte(`{"nix": "/usr/bin/nixio-validate", "containerized": {"path": "env", "image": "gin-valid/test"}}`), &executables)
+	if err != nil {
+		t.Fatalf("failed to read executables: %s", err.Error())
+	}
+	if !reflect.DeepEqual(executables["nix"], config.Executable{Path: "/usr/bin/nixio-validate"}) || !reflect.DeepEqual(executables["containerized"], config.Executable{Path: "env", Image: "gin-valid/test"}) {
+		t.Fatalf("unexpected executables %+v", executables)
+	}
+
+	srvcfg := config.Read()
+	original := srvcfg
+	defer config.Set(original)
+	srvcfg.Exec = executables
+	srvcfg.Sandbox.Runtime = shim
+	srvcfg.ValidatorLimits = map[string]config.Limits{"containerized": {Memory: 512, CPU: 60}}
+	config.Set(srvcfg)
+
+	v, err := newExternal(config.ExternalValidator{Name: "containerized", Executable: "env"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Run(context.Background(), v, valroot, resdir)
+	if err != nil {
+		t.Fatalf("containerized validator failed: %s", err.Error())
+	}
+	args, _ := ioutil.ReadFile(argsfile)
+	for _, expected := range []string{
+		"run\n--rm\n",
+		"--network\nnone\n",
+		"--read-only\n",
+		"--volume\n" + valroot + ":" + valroot + ":ro\n--workdir\n" + valroot + "\n",
+		"--memory\n512m\n",
+		"--ulimit\ncpu=60\n",
+		"gin-valid/test\nenv\n",
+	} {
+		if !strings.Contains(string(args), expected) {
+			t.Fatalf("runtime arguments do not contain %q:\n%s", expected, args)
+		}
+	}
+	if _, err := os.Stat(rmfile); err == nil {
+		t.Fatal("container of finished validator removed")
+	}
+
+	// containers of stopped validators are removed
+	srvcfg.ValidatorLimits = map[string]config.Limits{"containerized": {Timeout: 1}}
+	config.Set(srvcfg)
+	v, _ = newExternal(config.ExternalValidator{Name: "containerized", Executable: "sleep", Args: []string{"10"}})
+	err = Run(context.Background(), v, valroot, resdir)
+	if !errors.Is(err, ErrTimedOut) {
+		t.Fatalf("containerized validator did not time out: %v", err)
+	}
+	rmargs, _ := ioutil.ReadFile(rmfile)
+	if !strings.HasPrefix(string(rmargs), "rm\n--force\ngin-valid-") {
+		t.Fatalf("container not removed: %q", rmargs)
+	}
+
+	// validators run with the configured backend if the runtime is missing
+	srvcfg.Sandbox.Runtime = filepath.Join(tmpdir, "missing")
+	srvcfg.ValidatorLimits = nil
+	config.Set(srvcfg)
+	os.Remove(argsfile)
+	v, _ = newExternal(config.ExternalValidator{Name: "containerized", Executable: "env"})
+	if err = Run(context.Background(), v, valroot, resdir); err != nil {
+		t.Fatalf("validator without container failed: %s", err.Error())
+	}
+	if _, err := os.Stat(argsfile); err == nil {
+		t.Fatal("validator ran in a container with a missing runtime")
+	}
+}
+func TestValidatorVersions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the version script requires a POSIX shell")
+	}
+	tmpdir, _ := ioutil.TempDir("", "versions")
+	defer os.RemoveAll(tmpdir)
+	valroot := filepath.Join(tmpdir, "clone")
+	resdir := filepath.Join(tmpdir, "results")
+	for _, dir := range []string{valroot, resdir} {
+		os.MkdirAll(dir, 0755)
+	}
+	// the script prints its version like most validators do
+	tool := filepath.Join(tmpdir, "tool")
+	ioutil.WriteFile(tool, []byte("#!/bin/sh\nif [ \"$1\" = --version ]; then printf '\\n  tool 2.1.0\\nbuilt today\\n'; fi\n"), 0755)
+
+	srvcfg := config.Read()
+	original := srvcfg
+	defer config.Set(original)
+	srvcfg.Settings.Validators = []string{"versioned", "configured", "unversioned"}
+	srvcfg.Exec = config.Executables{
+		"versioned":  {Path: tool},
+		"configured": {Path: "missing", Version: []string{"echo", "1.0"}},
+	}
+	config.Set(srvcfg)
+	for _, name := range srvcfg.Settings.Validators {
+		v, err := newExternal(config.ExternalValidator{Name: name, Executable: tool})
+		if err != nil {
+			t.Fatal(err)
+		}
+		Register(v)
+	}
+
+	versions := CheckVersions()
+	expected := map[string]string{"versioned": "tool 2.1.0", "configured": "1.0"}
+	if !reflect.DeepEqual(versions, expected) {
+		t.Fatalf("unexpected versions %v", versions)
+	}
+	v, _ := Get("versioned")
+	if err := Run(context.Background(), v, valroot, resdir); err != nil {
+		t.Fatalf("validator failed: %s", err.Error())
+	}
+	rep, err := ReadReport(resdir)
+	if err != nil {
+		t.Fatalf("failed to read report: %s", err.Error())
+	}
+	if rep.Version != "tool 2.1.0" {
+		t.Fatalf("unexpected report version %q", rep.Version)
+	}
+}
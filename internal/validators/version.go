@@ -0,0 +1,120 @@
+package validators
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/log"
+)
+
+// versionTimeout is the time a validator gets to print its version.
+const versionTimeout = 30 * time.Second
+
+var (
+	versions   = make(map[string]string)
+	versionsMu sync.RWMutex
+)
+
+// CheckVersions determines the versions of all validators enabled in the
+// server configuration and stores them for the reports of subsequent runs.
+// Validators whose version cannot be determined are logged and reported
+// without a version.
+func CheckVersions() map[string]string {
+	checked := make(map[string]string)
+	for _, name := range config.Read().Settings.Validators {
+		v, ok := Get(name)
+		if !ok {
+			continue
+		}
+		version, err := checkVersion(v)
+		if err != nil {
+			log.ShowWrite("[Warning] checking %s validator version: %s", name, err.Error())
+			continue
+		}
+		checked[name] = version
+	}
+	versionsMu.Lock()
+	versions = checked
+	versionsMu.Unlock()
+	return Versions()
+}
+
+// Version returns the version of the validator with the given name as
+// determined by the last call to CheckVersions or an empty string if it is
+// not known.
+func Version(name string) string {
+	versionsMu.RLock()
+	defer versionsMu.RUnlock()
+	return versions[name]
+}
+
+// Versions returns the known versions of all validators by name.
+func Versions() map[string]string {
+	versionsMu.RLock()
+	defer versionsMu.RUnlock()
+	known := make(map[string]string, len(versions))
+	for name, version := range versions {
+		known[name] = version
+	}
+	return known
+}
+
+// versionCommand returns the command that prints the version of the
+// validator with the given name as configured in config.Executables.
+func versionCommand(name string) (*exec.Cmd, error) {
+	executable := config.Read().Exec[name]
+	args := executable.Version
+	if len(args) == 0 && executable.Path != "" {
+		args = []string{executable.Path, "--version"}
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no version command configured")
+	}
+	return exec.Command(args[0], args[1:]...), nil
+}
+
+// checkVersion runs the version command of a validator in the execution
+// backend of the validator and returns the first line it prints.
+func checkVersion(v Validator) (string, error) {
+	cmd, err := versionCommand(v.Name())
+	if err != nil {
+		return "", err
+	}
+	// the backends expect a repository; an empty directory stands in for it
+	tmpdir, err := ioutil.TempDir("", "version")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpdir)
+	backend := backendFor(v.Name())
+	cmd, err = backend.Prepare(cmd, tmpdir, limitsFor(v.Name()))
+	if err != nil {
+		return "", err
+	}
+	var out, serr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &serr
+	ctx, cancel := context.WithTimeout(context.Background(), versionTimeout)
+	defer cancel()
+	err = runCommand(ctx, cmd)
+	if s, ok := backend.(stopper); ok && ctx.Err() != nil {
+		s.Stop(cmd)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: '%s'", err.Error(), strings.TrimSpace(serr.String()))
+	}
+	for _, line := range strings.Split(out.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line, nil
+		}
+	}
+	return "", fmt.Errorf("no version printed")
+}
@@ -0,0 +1,136 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/G-Node/gin-cli/ginclient"
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/helpers"
+	"github.com/G-Node/gin-valid/internal/log"
+	"github.com/G-Node/gin-valid/internal/resources/templates"
+	"github.com/G-Node/gin-valid/internal/validators"
+)
+
+// validatorInfo is an enabled validator as it is listed on the admin page.
+type validatorInfo struct {
+	Name    string
+	Version string
+}
+
+// isAdmin returns whether the GIN user is listed in config.Settings.Admins.
+func isAdmin(username string) bool {
+	for _, admin := range config.Read().Settings.Admins {
+		if admin == username {
+			return true
+		}
+	}
+	return false
+}
+
+// Admin renders the page listing the enabled validators and their versions,
+// from which administrators can revalidate all hooked repositories.
+func Admin(w http.ResponseWriter, r *http.Request) {
+	ut, err := getSessionOrRedirect(w, r)
+	if err != nil {
+		log.Write("[Info] %s: Redirecting to login", err.Error())
+		return
+	}
+	if !isAdmin(ut.Username) {
+		fail(w, http.StatusForbidden, "only administrators can access this page")
+		return
+	}
+
+	tmpl := layoutTemplate(r)
+	tmpl, err = tmpl.Parse(templates.Layout)
+	if err != nil {
+		log.ShowWrite("[Error] failed to parse html layout page")
+		fail(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	tmpl, err = tmpl.Parse(templates.Admin)
+	if err != nil {
+		log.ShowWrite("[Error] failed to render admin page")
+		fail(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	info := struct {
+		Validators []validatorInfo
+		Message    string
+		CSRFToken  string
+	}{CSRFToken: requestCSRFToken(r)}
+	for _, name := range config.Read().Settings.Validators {
+		info.Validators = append(info.Validators, validatorInfo{name, validators.Version(name)})
+	}
+	switch revalidating := r.URL.Query().Get("revalidating"); revalidating {
+	case "":
+	case combinedbadge:
+		info.Message = "Revalidating all hooked repositories with all validators."
+	default:
+		info.Message = fmt.Sprintf("Revalidating all hooked repositories with the %s validator.", revalidating)
+	}
+	tmpl.ExecuteTemplate(w, "layout", info)
+}
+
+// RevalidateAll checks the versions of the validators again and revalidates
+// the latest validated commit of all hooked repositories with the validator
+// in the form value 'validator' or, if it is "all", with all enabled
+// validators. It is meant to be used after a validator was upgraded.
+func RevalidateAll(w http.ResponseWriter, r *http.Request) {
+	ut, err := getSessionOrRedirect(w, r)
+	if err != nil {
+		log.Write("[Info] %s: Redirecting to login", err.Error())
+		return
+	}
+	if !isAdmin(ut.Username) {
+		fail(w, http.StatusForbidden, "only administrators can revalidate all repositories")
+		return
+	}
+	validator := r.FormValue("validator")
+	names := []string{validator}
+	if validator == combinedbadge {
+		names = config.Read().Settings.Validators
+	} else if !helpers.SupportedValidator(validator) {
+		fail(w, http.StatusNotFound, "unsupported validator")
+		return
+	}
+	log.ShowWrite("[Info] %s revalidates all repositories with %v", ut.Username, names)
+	// checking the versions runs the validators and may take a while
+	go func() {
+		validators.CheckVersions()
+		revalidateAll(names)
+	}()
+	http.Redirect(w, r, "/admin?revalidating="+validator, http.StatusFound)
+}
+
+// revalidateAll queues a forced validation of the latest validated commit of
+// every hooked repository for each of the validators and returns the number
+// of queued jobs. Repositories that have not been validated with a validator
+// are skipped.
+func revalidateAll(names []string) int {
+	repos, err := hookedRepos()
+	if err != nil {
+		log.ShowWrite("[Error] listing hooked repositories: %s", err.Error())
+		return 0
+	}
+	queued := 0
+	for _, repopath := range repos {
+		ut, err := getTokenByRepo(repopath)
+		if err != nil {
+			log.ShowWrite("[Error] revalidating %q: no access token found: %s", repopath, err.Error())
+			continue
+		}
+		gcl := ginclient.New(serveralias)
+		gcl.UserToken = ut
+		for _, validator := range names {
+			commit, err := latestCommit(validator, repopath)
+			if err != nil {
+				continue
+			}
+			revalidate(validator, repopath, commit, gcl)
+			queued++
+		}
+	}
+	log.ShowWrite("[Info] queued %d revalidations", queued)
+	return queued
+}
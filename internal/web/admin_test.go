@@ -0,0 +1,75 @@
+package web
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/store"
+)
+
+func TestRevalidateAll(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir("", "revalidate")
+	defer os.RemoveAll(tmpdir)
+	srvcfg := config.Read()
+	original := srvcfg
+	srvcfg.Dir.Tokens = filepath.Join(tmpdir, "tokens")
+	srvcfg.Dir.Result = filepath.Join(tmpdir, "results")
+	srvcfg.Dir.Queue = filepath.Join(tmpdir, "queue")
+	srvcfg.TokenStore.KeyFile = filepath.Join(tmpdir, "tokens.key")
+	srvcfg.Settings.CommitStatus = false
+	srvcfg.Settings.Admins = []string{"valid-admin"}
+	config.Set(srvcfg)
+	defer config.Set(original)
+	if err := LoadTokenKeys(); err != nil {
+		t.Fatal(err)
+	}
+	// jobs are only queued, no worker runs them
+	queueOnce.Do(func() {
+		queue = newTestQueue()
+	})
+
+	if !isAdmin("valid-admin") || isAdmin(username) {
+		t.Fatal("unexpected administrators")
+	}
+	if repos, err := hookedRepos(); err != nil || len(repos) != 0 {
+		t.Fatalf("unexpected hooked repositories without tokens %v: %v", repos, err)
+	}
+
+	os.MkdirAll(filepath.Join(srvcfg.Dir.Tokens, "by-repo"), 0755)
+	if err := saveToken(store.UserToken{Username: "valid-owner", Token: "token"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, repopath := range []string{"valid-owner/validated", "valid-owner/unvalidated"} {
+		if err := linkToRepo("valid-owner", repopath); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if repos, _ := hookedRepos(); len(repos) != 2 {
+		t.Fatalf("unexpected hooked repositories %v", repos)
+	}
+	// only the bids validator ran on one of the repositories
+	repodir := filepath.Join(srvcfg.Dir.Result, "bids", "valid-owner", "validated")
+	os.MkdirAll(filepath.Join(repodir, "abc123"), 0755)
+	os.Symlink(filepath.Join(repodir, "abc123"), filepath.Join(repodir, srvcfg.Label.ResultsFolder))
+
+	if queued := revalidateAll([]string{"bids", "nix"}); queued != 1 {
+		t.Fatalf("expected 1 revalidation, got %d", queued)
+	}
+	var found *job
+	queue.mu.Lock()
+	for _, j := range queue.pending {
+		if j.Repopath == "valid-owner/validated" {
+			found = j
+		}
+	}
+	queue.mu.Unlock()
+	if found == nil || found.Validator != "bids" || found.Commit != "abc123" || !found.Force || !found.Automatic {
+		t.Fatalf("unexpected revalidation job %+v", found)
+	}
+	if found.gcl == nil || found.gcl.Username != "valid-owner" {
+		t.Fatal("revalidation does not use the token of the repository")
+	}
+}
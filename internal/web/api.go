@@ -0,0 +1,208 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/helpers"
+	"github.com/G-Node/gin-valid/internal/log"
+	"github.com/G-Node/gin-valid/internal/resources"
+	"github.com/G-Node/gin-valid/internal/validators"
+	"github.com/gorilla/mux"
+)
+
+// States of a validation as reported by the JSON API.
+const (
+	statequeued   = "queued"
+	staterunning  = "running"
+	statewaiting  = "waiting"
+	statefailed   = "failed"
+	statetimedout = "timedout"
+	statedone     = "done"
+)
+
+// apiStatus is the state of a validation as returned by the JSON API.
+// Outcome is one of "success", "warning" or "error" once the validation is
+// done and Position is the queue position of a queued validation.
+type apiStatus struct {
+	Validator  string `json:"validator"`
+	Repository string `json:"repository"`
+	ID         string `json:"id"`
+	State      string `json:"state"`
+	Position   int    `json:"position,omitempty"`
+	Outcome    string `json:"outcome,omitempty"`
+	Errors     int    `json:"errors"`
+	Warnings   int    `json:"warnings"`
+}
+
+// apiResults are the results of a validation as returned by the JSON API.
+// The report is only included once the validation is done.
+type apiResults struct {
+	apiStatus
+	Report *validators.Report `json:"report,omitempty"`
+}
+
+// apiError is the body of JSON API error responses.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// ResultsAPI returns the state and the report of a validation as JSON.
+func ResultsAPI(w http.ResponseWriter, r *http.Request) {
+	res, status, err := readResults(mux.Vars(r))
+	if err != nil {
+		failJSON(w, status, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// StatusAPI returns the state of a validation as JSON.
+func StatusAPI(w http.ResponseWriter, r *http.Request) {
+	res, status, err := readResults(mux.Vars(r))
+	if err != nil {
+		failJSON(w, status, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, res.apiStatus)
+}
+
+// readResults reads the results of the validation specified by the
+// validator, user, repo and optional id route variables. If reading fails,
+// the HTTP status code for the error is returned.
+func readResults(vars map[string]string) (*apiResults, int, error) {
+	validator := strings.ToLower(vars["validator"])
+	user := vars["user"]
+	repo := vars["repo"]
+	if !helpers.SupportedValidator(validator) {
+		return nil, http.StatusNotFound, fmt.Errorf("unsupported validator %q", validator)
+	}
+	v, ok := validators.Get(validator)
+	if !ok {
+		return nil, http.StatusNotFound, fmt.Errorf("unsupported validator %q", validator)
+	}
+
+	srvcfg := config.Read()
+	resID, ok := vars["id"]
+	if !ok {
+		resID = srvcfg.Label.ResultsFolder
+	}
+	resdir := filepath.Join(srvcfg.Dir.Result, validator, user, repo, resID)
+	res, err := loadResults(v, resdir)
+	if os.IsNotExist(err) {
+		log.ShowWrite("[Error] serving '%s/%s' result: %s\n", user, repo, err.Error())
+		return nil, http.StatusNotFound, fmt.Errorf("no %s results found for %s/%s", validator, user, repo)
+	} else if err != nil {
+		log.ShowWrite("[Error] parsing '%s/%s' result: %s\n", user, repo, err.Error())
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to read %s results for %s/%s", validator, user, repo)
+	}
+	res.Repository = user + "/" + repo
+	return res, http.StatusOK, nil
+}
+
+// loadResults reads the state and, once the validation is done, the report
+// of the validation results in 'resdir'. The returned error satisfies
+// os.IsNotExist if there are no results in 'resdir'.
+func loadResults(v validators.Validator, resdir string) (*apiResults, error) {
+	srvcfg := config.Read()
+	content, err := ioutil.ReadFile(filepath.Join(resdir, srvcfg.Label.ResultsFile))
+	if err != nil {
+		return nil, err
+	}
+	badge, err := ioutil.ReadFile(filepath.Join(resdir, srvcfg.Label.ResultsBadge))
+	if err != nil && !os.IsNotExist(err) {
+		log.ShowWrite("[Error] reading badge in %q: %s\n", resdir, err.Error())
+	}
+
+	res := &apiResults{}
+	res.Validator = v.Name()
+	// report the ID of the results the 'latest' link points to
+	res.ID = filepath.Base(resdir)
+	if resolved, err := filepath.EvalSymlinks(resdir); err == nil {
+		res.ID = filepath.Base(resolved)
+	}
+	switch {
+	case string(content) == waitingmsg:
+		res.State = statewaiting
+	case string(content) == progressmsg:
+		res.State = staterunning
+		if pos := queuePosition(resdir); pos > 0 {
+			res.State = statequeued
+			res.Position = pos
+		}
+	case string(badge) == resources.FailureBadge:
+		res.State = statefailed
+	case string(badge) == resources.TimeoutBadge:
+		res.State = statetimedout
+	default:
+		results, err := v.Parse(content)
+		if err != nil {
+			return nil, err
+		}
+		res.Report = validators.LoadReport(v, resdir, results)
+		res.State = statedone
+		res.Errors = res.Report.Errors
+		res.Warnings = res.Report.Warnings
+		res.Outcome = reportOutcome(res.Report)
+	}
+	return res, nil
+}
+
+// reportOutcome returns the outcome of a completed validation with the given
+// report.
+func reportOutcome(rep *validators.Report) string {
+	switch rep.Badge() {
+	case resources.ErrorBadge:
+		return "error"
+	case resources.WarningBadge:
+		return "warning"
+	default:
+		return "success"
+	}
+}
+
+// wantsJSON returns whether the request prefers a JSON response over an HTML
+// page or a badge, i.e., whether it accepts JSON but neither HTML nor images.
+func wantsJSON(r *http.Request) bool {
+	var acceptjson, acceptother bool
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediatype, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		switch {
+		case mediatype == "application/json":
+			acceptjson = true
+		case mediatype == "text/html" || strings.HasPrefix(mediatype, "image/"):
+			acceptother = true
+		}
+	}
+	return acceptjson && !acceptother
+}
+
+// writeJSON writes 'v' as the JSON body of the response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		log.ShowWrite("[Error] encoding JSON response: %s", err.Error())
+		status = http.StatusInternalServerError
+		content = []byte(`{"error":"internal server error"}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(content)
+}
+
+// failJSON logs an error and returns it as a JSON error response with the
+// given status code.
+func failJSON(w http.ResponseWriter, status int, message string) {
+	log.ShowWrite("[Error] %s", message)
+	writeJSON(w, status, apiError{message})
+}
@@ -0,0 +1,128 @@
+package web
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/resources"
+	"github.com/G-Node/gin-valid/internal/validators"
+	"github.com/gorilla/mux"
+)
+
+func apiRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/api/v1/results/{validator}/{user}/{repo}", ResultsAPI).Methods("GET")
+	router.HandleFunc("/api/v1/results/{validator}/{user}/{repo}/{id}", ResultsAPI).Methods("GET")
+	router.HandleFunc("/api/v1/status/{validator}/{user}/{repo}", StatusAPI).Methods("GET")
+	router.HandleFunc("/results/{validator}/{user}/{repo}/{id}", Results).Methods("GET")
+	return router
+}
+
+func TestAPIResults(t *testing.T) {
+	srvcfg := config.Read()
+	resdir := filepath.Join(srvcfg.Dir.Result, "nix", username, reponame, "api-testing")
+	os.MkdirAll(resdir, 0755)
+	defer os.RemoveAll(filepath.Join(srvcfg.Dir.Result, "nix", username, reponame))
+	ioutil.WriteFile(filepath.Join(resdir, srvcfg.Label.ResultsFile), []byte("a.nix: Validation completed with warnings\n"), 0644)
+	ioutil.WriteFile(filepath.Join(resdir, srvcfg.Label.ResultsBadge), []byte(resources.WarningBadge), 0644)
+	rep := validators.NewReport("nix", []validators.Issue{{Severity: validators.SeverityWarning, File: "a.nix", Message: "no type"}})
+	rep.Commit = "abc"
+	validators.WriteReport(resdir, rep)
+	os.Symlink("api-testing", filepath.Join(filepath.Dir(resdir), srvcfg.Label.ResultsFolder))
+
+	r, _ := http.NewRequest("GET", "/api/v1/results/nix/"+username+"/"+reponame, nil)
+	w := httptest.NewRecorder()
+	apiRouter().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code %d", w.Code)
+	}
+	res := apiResults{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("invalid JSON response: %s", err.Error())
+	}
+	if res.State != statedone || res.Outcome != "warning" || res.ID != "api-testing" || res.Warnings != 1 {
+		t.Fatalf("unexpected results: %+v", res.apiStatus)
+	}
+	if res.Report == nil || res.Report.Commit != "abc" || len(res.Report.Issues) != 1 {
+		t.Fatalf("unexpected report: %+v", res.Report)
+	}
+
+	// content negotiation on the results page
+	r, _ = http.NewRequest("GET", "/results/nix/"+username+"/"+reponame+"/api-testing", nil)
+	r.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	apiRouter().ServeHTTP(w, r)
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("JSON results not served for Accept header, got %q", w.Header().Get("Content-Type"))
+	}
+
+	// results in progress
+	ioutil.WriteFile(filepath.Join(resdir, srvcfg.Label.ResultsFile), []byte(progressmsg), 0644)
+	r, _ = http.NewRequest("GET", "/api/v1/status/nix/"+username+"/"+reponame, nil)
+	w = httptest.NewRecorder()
+	apiRouter().ServeHTTP(w, r)
+	status := apiStatus{}
+	json.Unmarshal(w.Body.Bytes(), &status)
+	if status.State != staterunning || status.Outcome != "" {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
+
+func TestAPINoResults(t *testing.T) {
+	for _, path := range []string{"/api/v1/results/nix/whatever/whatever", "/api/v1/status/wtf/whatever/whatever"} {
+		r, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		apiRouter().ServeHTTP(w, r)
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("%s: unexpected status code %d", path, w.Code)
+		}
+		res := apiError{}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || res.Error == "" {
+			t.Fatalf("%s: invalid error response %q", path, w.Body.String())
+		}
+	}
+}
+
+func TestAPITimedOut(t *testing.T) {
+	srvcfg := config.Read()
+	repodir := filepath.Join(srvcfg.Dir.Result, "bids", username, "timeout-testing")
+	defer os.RemoveAll(repodir)
+	resdir := filepath.Join(repodir, "abc123")
+	os.MkdirAll(resdir, 0755)
+	writeValTimeout(resdir)
+	os.Symlink("abc123", filepath.Join(repodir, srvcfg.Label.ResultsFolder))
+
+	router := apiRouter()
+	router.HandleFunc("/status/{validator}/{user}/{repo}", Status).Methods("GET")
+	r, _ := http.NewRequest("GET", "/api/v1/status/bids/"+username+"/timeout-testing", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	status := apiStatus{}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("invalid JSON response: %s", err.Error())
+	}
+	if status.State != statetimedout || status.ID != "abc123" {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	r, _ = http.NewRequest("GET", "/results/bids/"+username+"/timeout-testing/abc123", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), timedoutmsg) {
+		t.Fatalf("results page does not show the timeout: %d %s", w.Code, w.Body.String())
+	}
+
+	r, _ = http.NewRequest("GET", "/status/bids/"+username+"/timeout-testing", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), ">timed out<") || !strings.Contains(w.Body.String(), resources.BadgeTimeout) {
+		t.Fatalf("unexpected timeout badge %s", w.Body.String())
+	}
+}
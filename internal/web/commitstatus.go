@@ -0,0 +1,179 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+
+	"github.com/G-Node/gin-cli/ginclient"
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/log"
+	"github.com/G-Node/gin-valid/internal/validators"
+)
+
+// States of a commit status as accepted by the GIN API.
+const (
+	statuspending = "pending"
+	statussuccess = "success"
+	statusfailure = "failure"
+	statuserror   = "error"
+)
+
+// commitStatus is the body of a request to the commit status endpoint of the
+// GIN API.
+type commitStatus struct {
+	State       string `json:"state"`
+	TargetURL   string `json:"target_url"`
+	Description string `json:"description"`
+	Context     string `json:"context"`
+}
+
+// statusUpdate is a commit status waiting to be posted.
+type statusUpdate struct {
+	gcl      *ginclient.Client
+	repopath string
+	commit   string
+	status   commitStatus
+}
+
+// statusBacklog is the number of waiting commit statuses from which on a new
+// status replaces the waiting status for the same commit and validator
+// instead of being queued behind it.
+const statusBacklog = 100
+
+// statusQueue holds the commit statuses waiting to be posted, in the order
+// they were reported. Adding a status never blocks, even if the GIN server is
+// slow or unavailable.
+type statusQueue struct {
+	mu      sync.Mutex
+	pending []statusUpdate
+	wake    chan struct{}
+}
+
+var (
+	statuses   *statusQueue
+	statusOnce sync.Once
+)
+
+// key identifies the commit and validator of a status update.
+func (update statusUpdate) key() string {
+	return update.repopath + "\x00" + update.commit + "\x00" + update.status.Context
+}
+
+// push adds a status update to the queue. If the backlog is full, the update
+// replaces the latest waiting update for the same commit and validator, which
+// it supersedes.
+func (q *statusQueue) push(update statusUpdate) {
+	q.mu.Lock()
+	replaced := false
+	if len(q.pending) >= statusBacklog {
+		for idx := len(q.pending) - 1; idx >= 0; idx-- {
+			if q.pending[idx].key() == update.key() {
+				q.pending[idx] = update
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			log.ShowWrite("[Warning] %d commit statuses waiting to be posted", len(q.pending))
+		}
+	}
+	if !replaced {
+		q.pending = append(q.pending, update)
+	}
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+		// the sender is already woken up
+	}
+}
+
+// pop removes the oldest status update from the queue.
+func (q *statusQueue) pop() (statusUpdate, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) == 0 {
+		return statusUpdate{}, false
+	}
+	update := q.pending[0]
+	q.pending = q.pending[1:]
+	return update, true
+}
+
+// reportStatus posts the state of a hook triggered job as a commit status
+// for the validated commit, using the client of the job. Statuses are posted
+// in order by a single goroutine, so that reporting never blocks the job or
+// the hook handler.
+func reportStatus(j *job, gcl *ginclient.Client, state, description string) {
+	srvcfg := config.Read()
+	if !srvcfg.Settings.CommitStatus || !j.Automatic || gcl == nil {
+		return
+	}
+	target, err := url.Parse(srvcfg.Settings.RootURL)
+	if err != nil {
+		log.ShowWrite("[Error] failed to parse url: %s", err.Error())
+		return
+	}
+	target.Path = path.Join(target.Path, "results", j.respath())
+	update := statusUpdate{
+		gcl:      gcl,
+		repopath: j.Repopath,
+		commit:   j.Commit,
+		status: commitStatus{
+			State:       state,
+			TargetURL:   target.String(),
+			Description: description,
+			Context:     fmt.Sprintf("gin-valid/%s", j.Validator),
+		},
+	}
+	statusOnce.Do(func() {
+		statuses = &statusQueue{wake: make(chan struct{}, 1)}
+		go statuses.send()
+	})
+	statuses.push(update)
+}
+
+// send posts the queued commit statuses in order.
+func (q *statusQueue) send() {
+	for range q.wake {
+		for update, ok := q.pop(); ok; update, ok = q.pop() {
+			err := postCommitStatus(update.gcl, update.repopath, update.commit, update.status)
+			if err != nil {
+				log.ShowWrite("[Error] posting commit status for %q (%s): %s", update.repopath, update.commit, err.Error())
+			}
+		}
+	}
+}
+
+// postCommitStatus creates a commit status for a commit of a repository.
+func postCommitStatus(gcl *ginclient.Client, repopath, commit string, status commitStatus) error {
+	res, err := gcl.Post(fmt.Sprintf("/api/v1/repos/%s/statuses/%s", repopath, commit), status)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusOK {
+		return fmt.Errorf("non-OK response: %s", res.Status)
+	}
+	return nil
+}
+
+// reportResult posts the commit status for the report of a finished job.
+// Validations with errors are reported as failures.
+func reportResult(j *job, gcl *ginclient.Client, resdir string) {
+	rep, err := validators.ReadReport(resdir)
+	if err != nil {
+		log.ShowWrite("[Error] reading report of %q: %s", resdir, err.Error())
+		reportStatus(j, gcl, statuserror, "The validation report is not available")
+		return
+	}
+	description := fmt.Sprintf("%d errors, %d warnings", rep.Errors, rep.Warnings)
+	if rep.Errors > 0 {
+		reportStatus(j, gcl, statusfailure, description)
+		return
+	}
+	reportStatus(j, gcl, statussuccess, description)
+}
@@ -0,0 +1,173 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/G-Node/gin-cli/ginclient"
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/validators"
+)
+
+// statusRequest is a commit status received by the fake GIN server.
+type statusRequest struct {
+	path   string
+	token  string
+	status commitStatus
+}
+
+// fakeStatusServer starts a server that accepts commit statuses like the GIN
+// API and sends them to the returned channel.
+func fakeStatusServer(t *testing.T) (*httptest.Server, chan statusRequest) {
+	received := make(chan statusRequest, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		req := statusRequest{path: r.URL.Path, token: r.Header.Get("Authorization")}
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &req.status); err != nil {
+			t.Errorf("invalid commit status body: %s", err.Error())
+		}
+		received <- req
+		w.WriteHeader(http.StatusCreated)
+	}))
+	return srv, received
+}
+
+func TestCommitStatusReport(t *testing.T) {
+	srv, received := fakeStatusServer(t)
+	defer srv.Close()
+	gcl := ginclient.New("")
+	gcl.Host = srv.URL
+	gcl.Token = "testtoken"
+
+	srvcfg := config.Read()
+	original := srvcfg
+	srvcfg.Settings.RootURL = "https://valid.example.org"
+	srvcfg.Settings.CommitStatus = true
+	config.Set(srvcfg)
+	defer config.Set(original)
+
+	j := &job{Validator: "bids", Repopath: username + "/status-testing", Commit: "abc123", Automatic: true}
+	resdir := filepath.Join(srvcfg.Dir.Result, j.respath())
+	os.MkdirAll(resdir, 0755)
+	defer os.RemoveAll(filepath.Join(srvcfg.Dir.Result, "bids", username, "status-testing"))
+	rep := validators.NewReport("bids", []validators.Issue{{Severity: validators.SeverityError, Message: "broken"}})
+	validators.WriteReport(resdir, rep)
+
+	reportStatus(j, gcl, statuspending, "Validation queued")
+	reportResult(j, gcl, resdir)
+	expected := []commitStatus{
+		{statuspending, "https://valid.example.org/results/bids/" + username + "/status-testing/abc123", "Validation queued", "gin-valid/bids"},
+		{statusfailure, "https://valid.example.org/results/bids/" + username + "/status-testing/abc123", "1 errors, 0 warnings", "gin-valid/bids"},
+	}
+	for _, exp := range expected {
+		select {
+		case req := <-received:
+			if req.path != "/api/v1/repos/"+username+"/status-testing/statuses/abc123" {
+				t.Fatalf("commit status posted to %q", req.path)
+			}
+			if req.token != "token testtoken" {
+				t.Fatalf("commit status posted with authorization %q", req.token)
+			}
+			if req.status != exp {
+				t.Fatalf("unexpected commit status %+v, expected %+v", req.status, exp)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("commit status not posted")
+		}
+	}
+
+	// one-time validations have no commit to report on
+	j.Automatic = false
+	reportStatus(j, gcl, statuspending, "Validation queued")
+	srvcfg.Settings.CommitStatus = false
+	j.Automatic = true
+	config.Set(srvcfg)
+	reportStatus(j, gcl, statuspending, "Validation queued")
+	select {
+	case req := <-received:
+		t.Fatalf("unexpected commit status %+v", req.status)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestCommitStatusPostFailed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+	gcl := ginclient.New("")
+	gcl.Host = srv.URL
+	err := postCommitStatus(gcl, "whatever/whatever", "abc123", commitStatus{State: statussuccess})
+	if err == nil {
+		t.Fatal("posting a commit status to a missing endpoint should fail")
+	}
+}
+
+func TestCommitStatusBacklog(t *testing.T) {
+	release := make(chan struct{})
+	received := make(chan commitStatus, 2*statusBacklog)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		var status commitStatus
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &status)
+		received <- status
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+	defer close(release)
+	gcl := ginclient.New("")
+	gcl.Host = srv.URL
+
+	srvcfg := config.Read()
+	original := srvcfg
+	srvcfg.Settings.RootURL = "https://valid.example.org"
+	srvcfg.Settings.CommitStatus = true
+	config.Set(srvcfg)
+	defer config.Set(original)
+
+	// the GIN server does not answer, so no status is posted until released
+	j := &job{Validator: "bids", Repopath: username + "/backlog-testing", Commit: "abc123", Automatic: true}
+	reported := make(chan struct{})
+	go func() {
+		for idx := 0; idx <= statusBacklog+50; idx++ {
+			reportStatus(j, gcl, statuspending, fmt.Sprintf("Validation queued %d", idx))
+		}
+		close(reported)
+	}()
+	select {
+	case <-reported:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reporting commit statuses blocked on a full backlog")
+	}
+
+	last := fmt.Sprintf("Validation queued %d", statusBacklog+50)
+	count := 0
+	for {
+		release <- struct{}{}
+		var status commitStatus
+		select {
+		case status = <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("commit status not posted")
+		}
+		count++
+		if status.Description == last {
+			break
+		}
+	}
+	if count > statusBacklog+1 {
+		t.Fatalf("%d commit statuses posted, expected at most %d", count, statusBacklog+1)
+	}
+}
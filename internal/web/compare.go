@@ -0,0 +1,114 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/helpers"
+	"github.com/G-Node/gin-valid/internal/log"
+	"github.com/G-Node/gin-valid/internal/resources/templates"
+	"github.com/G-Node/gin-valid/internal/validators"
+	"github.com/gorilla/mux"
+)
+
+// apiComparison lists the issues added, resolved and unchanged between the
+// validation runs 'base' and 'head' of a repository.
+type apiComparison struct {
+	Validator  string    `json:"validator"`
+	Repository string    `json:"repository"`
+	Base       apiStatus `json:"base"`
+	Head       apiStatus `json:"head"`
+	validators.Comparison
+}
+
+// Compare renders a page with the differences between two validation runs of
+// a repository. Requests that only accept JSON are served the same response
+// as the JSON API.
+func Compare(w http.ResponseWriter, r *http.Request) {
+	if wantsJSON(r) {
+		CompareAPI(w, r)
+		return
+	}
+	cmp, status, err := readComparison(mux.Vars(r))
+	if err != nil {
+		fail(w, status, err.Error())
+		return
+	}
+
+	tmpl := layoutTemplate(r)
+	tmpl, err = tmpl.Parse(templates.Layout)
+	if err != nil {
+		log.ShowWrite("[Error] failed to parse html layout page")
+		fail(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	tmpl, err = tmpl.Parse(templates.Compare)
+	if err != nil {
+		log.ShowWrite("[Error] failed to render comparison page")
+		fail(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	info := struct {
+		Header string
+		*apiComparison
+	}{fmt.Sprintf("%s validation changes for %s", strings.ToUpper(cmp.Validator), cmp.Repository), cmp}
+	tmpl.ExecuteTemplate(w, "layout", info)
+}
+
+// CompareAPI returns the differences between two validation runs of a
+// repository as JSON.
+func CompareAPI(w http.ResponseWriter, r *http.Request) {
+	cmp, status, err := readComparison(mux.Vars(r))
+	if err != nil {
+		failJSON(w, status, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, cmp)
+}
+
+// readComparison compares the validation runs specified by the base and head
+// route variables. Both runs must be done. If the comparison fails, the HTTP
+// status code for the error is returned.
+func readComparison(vars map[string]string) (*apiComparison, int, error) {
+	validator := strings.ToLower(vars["validator"])
+	user := vars["user"]
+	repo := vars["repo"]
+	if !helpers.SupportedValidator(validator) {
+		return nil, http.StatusNotFound, fmt.Errorf("unsupported validator %q", validator)
+	}
+	v, ok := validators.Get(validator)
+	if !ok {
+		return nil, http.StatusNotFound, fmt.Errorf("unsupported validator %q", validator)
+	}
+
+	srvcfg := config.Read()
+	repodir := filepath.Join(srvcfg.Dir.Result, validator, user, repo)
+	runs := make([]*apiResults, 0, 2)
+	for _, id := range []string{vars["base"], vars["head"]} {
+		res, err := loadResults(v, filepath.Join(repodir, id))
+		if os.IsNotExist(err) {
+			return nil, http.StatusNotFound, fmt.Errorf("no %s results %q found for %s/%s", validator, id, user, repo)
+		} else if err != nil {
+			log.ShowWrite("[Error] reading '%s/%s' result %q: %s", user, repo, id, err.Error())
+			return nil, http.StatusInternalServerError, fmt.Errorf("failed to read %s results %q for %s/%s", validator, id, user, repo)
+		}
+		if res.State != statedone {
+			return nil, http.StatusConflict, fmt.Errorf("%s validation %q for %s/%s is not done", validator, id, user, repo)
+		}
+		res.Repository = user + "/" + repo
+		runs = append(runs, res)
+	}
+
+	cmp := &apiComparison{
+		Validator:  validator,
+		Repository: user + "/" + repo,
+		Base:       runs[0].apiStatus,
+		Head:       runs[1].apiStatus,
+		Comparison: validators.Compare(runs[0].Report, runs[1].Report),
+	}
+	return cmp, http.StatusOK, nil
+}
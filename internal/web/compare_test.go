@@ -0,0 +1,69 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/resources"
+	"github.com/G-Node/gin-valid/internal/validators"
+	"github.com/gorilla/mux"
+)
+
+func TestCompare(t *testing.T) {
+	srvcfg := config.Read()
+	repodir := filepath.Join(srvcfg.Dir.Result, "bids", username, "compare-testing")
+	defer os.RemoveAll(repodir)
+	outputs := map[string]string{
+		"base": `{"issues": {"errors": [{"key": "NOT_INCLUDED", "reason": "Files not included", "files": [{"file": {"relativePath": "/a.txt"}}]}]}}`,
+		"head": `{"issues": {"errors": [{"key": "NOT_INCLUDED", "reason": "Files not included", "files": [{"file": {"relativePath": "/b.txt"}}]}]}}`,
+	}
+	for id, output := range outputs {
+		resdir := filepath.Join(repodir, id)
+		os.MkdirAll(resdir, 0755)
+		ioutil.WriteFile(filepath.Join(resdir, srvcfg.Label.ResultsFile), []byte(output), 0644)
+		ioutil.WriteFile(filepath.Join(resdir, srvcfg.Label.ResultsBadge), []byte(resources.ErrorBadge), 0644)
+	}
+	resdir := filepath.Join(repodir, "running")
+	os.MkdirAll(resdir, 0755)
+	ioutil.WriteFile(filepath.Join(resdir, srvcfg.Label.ResultsFile), []byte(progressmsg), 0644)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/compare/{validator}/{user}/{repo}/{base}/{head}", Compare).Methods("GET")
+	r, _ := http.NewRequest("GET", "/compare/bids/"+username+"/compare-testing/base/head", nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	cmp := apiComparison{}
+	if err := json.Unmarshal(w.Body.Bytes(), &cmp); err != nil {
+		t.Fatalf("invalid JSON response: %s", err.Error())
+	}
+	expected := validators.Issue{Severity: validators.SeverityError, Code: "NOT_INCLUDED", File: "b.txt", Message: "Files not included"}
+	if len(cmp.Added) != 1 || cmp.Added[0] != expected || len(cmp.Resolved) != 1 || len(cmp.Unchanged) != 0 {
+		t.Fatalf("unexpected comparison: %+v", cmp.Comparison)
+	}
+	if cmp.Base.ID != "base" || cmp.Head.ID != "head" || cmp.Head.Errors != 1 {
+		t.Fatalf("unexpected runs: %+v, %+v", cmp.Base, cmp.Head)
+	}
+
+	r, _ = http.NewRequest("GET", "/compare/bids/"+username+"/compare-testing/base/head", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte("Added issues (1)")) {
+		t.Fatalf("unexpected comparison page: %d", w.Code)
+	}
+
+	r, _ = http.NewRequest("GET", "/compare/bids/"+username+"/compare-testing/base/running", nil)
+	r.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("comparing with a running validation should fail, got %d", w.Code)
+	}
+}
@@ -0,0 +1,132 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/G-Node/gin-cli/git"
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/log"
+)
+
+// maxContentRetry is the longest time to wait between two attempts to
+// download missing content.
+const maxContentRetry = 30 * time.Minute
+
+// contentBatch is the maximum number of paths passed to a single git-annex
+// get call.
+const contentBatch = 500
+
+// getContent downloads the annexed content of 'paths' in the repository at
+// 'dir' and returns the files whose content could not be retrieved. Errors
+// that do not concern a single file are returned as an error. The paths are
+// passed to git-annex in batches of contentBatch paths to keep the command
+// line short.
+func getContent(ctx context.Context, dir string, paths []string) ([]string, error) {
+	var missing []string
+	for start := 0; start < len(paths); start += contentBatch {
+		end := start + contentBatch
+		if end > len(paths) {
+			end = len(paths)
+		}
+		args := append([]string{"get", "--json"}, paths[start:end]...)
+		stdout, err := runCommand(ctx, dir, git.AnnexCommand(args...))
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		failed := 0
+		for _, line := range bytes.Split(stdout, []byte("\n")) {
+			var res struct {
+				File    string `json:"file"`
+				Success bool   `json:"success"`
+				Note    string `json:"note"`
+			}
+			if len(bytes.TrimSpace(line)) == 0 || json.Unmarshal(line, &res) != nil || res.File == "" {
+				continue
+			}
+			if !res.Success {
+				log.ShowWrite("[Warning] failed to get content of %q: %s", res.File, res.Note)
+				missing = append(missing, res.File)
+				failed++
+				continue
+			}
+			log.ShowWrite("[Info] got content of %s", res.File)
+		}
+		// git-annex fails when any file fails; only report errors that are
+		// not explained by the missing files
+		if err != nil && failed == 0 {
+			return missing, err
+		}
+	}
+	return missing, nil
+}
+
+// fetchContent downloads the annexed content of 'paths' in the clone at
+// 'valroot' for a job. Annexed content is uploaded after the push that
+// triggers the hook, so content that is missing is downloaded again with
+// increasing intervals until Settings.ContentTimeout has passed. While
+// waiting, the results page of the job shows that the job is waiting for data.
+func fetchContent(j *job, valroot, resdir string, paths []string) error {
+	srvcfg := config.Read()
+	timeout := time.Duration(srvcfg.Settings.ContentTimeout) * time.Second
+	interval := time.Duration(srvcfg.Settings.ContentRetry) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	deadline := time.Now().Add(timeout)
+
+	missing, err := getContent(j.ctx, valroot, paths)
+	waited := false
+	for err == nil && len(missing) > 0 {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		if !waited {
+			writeJobMessage(resdir, waitingmsg)
+			waited = true
+		}
+		wait := interval
+		if wait > remaining {
+			wait = remaining
+		}
+		log.ShowWrite("[Info] content of %d files missing for %q, retrying in %s", len(missing), j.Repopath, wait)
+		select {
+		case <-j.ctx.Done():
+			return j.ctx.Err()
+		case <-time.After(wait):
+		}
+		interval *= 2
+		if interval > maxContentRetry {
+			interval = maxContentRetry
+		}
+		missing, err = getContent(j.ctx, valroot, missing)
+	}
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("content not available for %d files: %s", len(missing), strings.Join(missing, ", "))
+	}
+	if waited {
+		writeJobMessage(resdir, progressmsg)
+	}
+	return nil
+}
+
+// writeJobMessage replaces the contents of the results file of a job that
+// has not finished yet with the given message.
+func writeJobMessage(resdir, msg string) {
+	outFile := filepath.Join(resdir, config.Read().Label.ResultsFile)
+	err := ioutil.WriteFile(outFile, []byte(msg), os.ModePerm)
+	if err != nil {
+		log.ShowWrite("[Error] writing results file for %q", resdir)
+	}
+}
@@ -14,7 +14,7 @@ func fail(w http.ResponseWriter, status int, message string) {
 	log.Write("[error] %s", message)
 	w.WriteHeader(status)
 
-	tmpl := template.New("layout")
+	tmpl := layoutTemplate(nil)
 	tmpl, err := tmpl.Parse(templates.Layout)
 	if err != nil {
 		log.Write("[Error] failed to parse html layout page. Displaying error message without layout.")
@@ -0,0 +1,191 @@
+package web
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/helpers"
+	"github.com/G-Node/gin-valid/internal/log"
+	"github.com/G-Node/gin-valid/internal/resources/templates"
+	"github.com/G-Node/gin-valid/internal/validators"
+	"github.com/gorilla/mux"
+)
+
+// Trends of a validation run compared to the previous completed run.
+const (
+	trendbetter    = "better"
+	trendworse     = "worse"
+	trendunchanged = "unchanged"
+)
+
+// historyEntry describes a single validation run of a repository. Trend
+// compares the issue counts of a completed run with those of the previous
+// completed run, whose ID is Previous.
+type historyEntry struct {
+	ID       string    `json:"id"`
+	Commit   string    `json:"commit,omitempty"`
+	Version  string    `json:"version,omitempty"`
+	Date     time.Time `json:"date"`
+	State    string    `json:"state"`
+	Outcome  string    `json:"outcome,omitempty"`
+	Errors   int       `json:"errors"`
+	Warnings int       `json:"warnings"`
+	Latest   bool      `json:"latest,omitempty"`
+	Trend    string    `json:"trend,omitempty"`
+	Previous string    `json:"previous,omitempty"`
+	// Badge is only used to render the history page.
+	Badge template.HTML `json:"-"`
+}
+
+// apiHistory lists all validation runs of a repository, most recent first.
+type apiHistory struct {
+	Validator  string         `json:"validator"`
+	Repository string         `json:"repository"`
+	Runs       []historyEntry `json:"runs"`
+}
+
+// History renders a page listing all validation runs of a repository.
+// Requests that only accept JSON are served the same response as the JSON
+// API.
+func History(w http.ResponseWriter, r *http.Request) {
+	if wantsJSON(r) {
+		HistoryAPI(w, r)
+		return
+	}
+	hist, status, err := readHistory(mux.Vars(r))
+	if err != nil {
+		fail(w, status, err.Error())
+		return
+	}
+
+	tmpl := layoutTemplate(r)
+	tmpl, err = tmpl.Parse(templates.Layout)
+	if err != nil {
+		log.ShowWrite("[Error] failed to parse html layout page")
+		fail(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	tmpl, err = tmpl.Parse(templates.History)
+	if err != nil {
+		log.ShowWrite("[Error] failed to render history page")
+		fail(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	info := struct {
+		Header string
+		*apiHistory
+	}{fmt.Sprintf("%s validation history for %s", strings.ToUpper(hist.Validator), hist.Repository), hist}
+	tmpl.ExecuteTemplate(w, "layout", info)
+}
+
+// HistoryAPI returns all validation runs of a repository as JSON.
+func HistoryAPI(w http.ResponseWriter, r *http.Request) {
+	hist, status, err := readHistory(mux.Vars(r))
+	if err != nil {
+		failJSON(w, status, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, hist)
+}
+
+// readHistory reads all validation runs for the validator, user and repo
+// route variables. If reading fails, the HTTP status code for the error is
+// returned.
+func readHistory(vars map[string]string) (*apiHistory, int, error) {
+	validator := strings.ToLower(vars["validator"])
+	user := vars["user"]
+	repo := vars["repo"]
+	if !helpers.SupportedValidator(validator) {
+		return nil, http.StatusNotFound, fmt.Errorf("unsupported validator %q", validator)
+	}
+	v, ok := validators.Get(validator)
+	if !ok {
+		return nil, http.StatusNotFound, fmt.Errorf("unsupported validator %q", validator)
+	}
+
+	srvcfg := config.Read()
+	repodir := filepath.Join(srvcfg.Dir.Result, validator, user, repo)
+	files, err := ioutil.ReadDir(repodir)
+	if os.IsNotExist(err) {
+		return nil, http.StatusNotFound, fmt.Errorf("no %s results found for %s/%s", validator, user, repo)
+	} else if err != nil {
+		log.ShowWrite("[Error] reading results of '%s/%s': %s", user, repo, err.Error())
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to read %s results for %s/%s", validator, user, repo)
+	}
+	var latest string
+	if target, err := os.Readlink(filepath.Join(repodir, srvcfg.Label.ResultsFolder)); err == nil {
+		latest = filepath.Base(target)
+	}
+
+	hist := &apiHistory{Validator: validator, Repository: user + "/" + repo, Runs: []historyEntry{}}
+	for _, fi := range files {
+		if !fi.IsDir() {
+			// skips the 'latest' link
+			continue
+		}
+		resdir := filepath.Join(repodir, fi.Name())
+		res, err := loadResults(v, resdir)
+		if err != nil {
+			log.ShowWrite("[Warning] skipping results in %q: %s", resdir, err.Error())
+			continue
+		}
+		entry := historyEntry{
+			ID:       fi.Name(),
+			Date:     fi.ModTime(),
+			State:    res.State,
+			Outcome:  res.Outcome,
+			Errors:   res.Errors,
+			Warnings: res.Warnings,
+			Latest:   fi.Name() == latest,
+		}
+		if res.Report != nil {
+			entry.Commit = res.Report.Commit
+			entry.Version = res.Report.Version
+			if !res.Report.Finished.IsZero() {
+				entry.Date = res.Report.Finished
+			}
+		}
+		if badge, err := ioutil.ReadFile(filepath.Join(resdir, srvcfg.Label.ResultsBadge)); err == nil {
+			entry.Badge = template.HTML(badge)
+		}
+		hist.Runs = append(hist.Runs, entry)
+	}
+	sort.Slice(hist.Runs, func(i, j int) bool {
+		return hist.Runs[i].Date.After(hist.Runs[j].Date)
+	})
+	setTrends(hist.Runs)
+	return hist, http.StatusOK, nil
+}
+
+// setTrends compares every completed run with the previous completed run,
+// first by the number of errors, then by the number of warnings. The runs
+// must be sorted most recent first.
+func setTrends(runs []historyEntry) {
+	var prev *historyEntry
+	for idx := len(runs) - 1; idx >= 0; idx-- {
+		run := &runs[idx]
+		if run.State != statedone {
+			continue
+		}
+		if prev != nil {
+			run.Previous = prev.ID
+			switch {
+			case run.Errors < prev.Errors || (run.Errors == prev.Errors && run.Warnings < prev.Warnings):
+				run.Trend = trendbetter
+			case run.Errors > prev.Errors || (run.Errors == prev.Errors && run.Warnings > prev.Warnings):
+				run.Trend = trendworse
+			default:
+				run.Trend = trendunchanged
+			}
+		}
+		prev = run
+	}
+}
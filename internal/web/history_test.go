@@ -0,0 +1,92 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/resources"
+	"github.com/G-Node/gin-valid/internal/validators"
+	"github.com/gorilla/mux"
+)
+
+func TestHistory(t *testing.T) {
+	srvcfg := config.Read()
+	repodir := filepath.Join(srvcfg.Dir.Result, "nix", username, "history-testing")
+	defer os.RemoveAll(repodir)
+	finished := time.Now().Add(-time.Hour)
+	runs := []struct {
+		id     string
+		issues []validators.Issue
+	}{
+		{"first", []validators.Issue{{Severity: validators.SeverityError, Message: "broken"}}},
+		{"second", []validators.Issue{{Severity: validators.SeverityWarning, Message: "odd"}}},
+		{"third", []validators.Issue{{Severity: validators.SeverityWarning, Message: "odd"}}},
+	}
+	for idx, run := range runs {
+		resdir := filepath.Join(repodir, run.id)
+		os.MkdirAll(resdir, 0755)
+		rep := validators.NewReport("nix", run.issues)
+		rep.Commit = run.id
+		rep.Finished = finished.Add(time.Duration(idx) * time.Minute)
+		validators.WriteReport(resdir, rep)
+		ioutil.WriteFile(filepath.Join(resdir, srvcfg.Label.ResultsFile), []byte(""), 0644)
+		ioutil.WriteFile(filepath.Join(resdir, srvcfg.Label.ResultsBadge), []byte(rep.Badge()), 0644)
+	}
+	// a run that is still in progress is listed with the time it was queued
+	resdir := filepath.Join(repodir, "fourth")
+	os.MkdirAll(resdir, 0755)
+	ioutil.WriteFile(filepath.Join(resdir, srvcfg.Label.ResultsFile), []byte(progressmsg), 0644)
+	ioutil.WriteFile(filepath.Join(resdir, srvcfg.Label.ResultsBadge), []byte(resources.ProcessingBadge), 0644)
+	os.Symlink(resdir, filepath.Join(repodir, srvcfg.Label.ResultsFolder))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/history/{validator}/{user}/{repo}", History).Methods("GET")
+	r, _ := http.NewRequest("GET", "/history/nix/"+username+"/history-testing", nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	hist := apiHistory{}
+	if err := json.Unmarshal(w.Body.Bytes(), &hist); err != nil {
+		t.Fatalf("invalid JSON response: %s", err.Error())
+	}
+	if len(hist.Runs) != 4 {
+		t.Fatalf("unexpected runs: %+v", hist.Runs)
+	}
+	expected := []struct{ id, trend string }{{"fourth", ""}, {"third", trendunchanged}, {"second", trendbetter}, {"first", ""}}
+	for idx, exp := range expected {
+		run := hist.Runs[idx]
+		if run.ID != exp.id || run.Trend != exp.trend {
+			t.Fatalf("unexpected run %d: %+v", idx, run)
+		}
+	}
+	if !hist.Runs[0].Latest || hist.Runs[0].State != staterunning {
+		t.Fatalf("unexpected latest run: %+v", hist.Runs[0])
+	}
+
+	r, _ = http.NewRequest("GET", "/history/nix/"+username+"/history-testing", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte("/results/nix/"+username+"/history-testing/second")) {
+		t.Fatalf("history page does not link to the results: %d", w.Code)
+	}
+}
+
+func TestSetTrends(t *testing.T) {
+	runs := []historyEntry{
+		{State: statedone, Errors: 2},
+		{State: statefailed},
+		{State: statedone, Errors: 1, Warnings: 3},
+	}
+	setTrends(runs)
+	if runs[0].Trend != trendworse || runs[1].Trend != "" || runs[2].Trend != "" {
+		t.Fatalf("unexpected trends: %+v", runs)
+	}
+}
@@ -77,11 +77,15 @@ func DisableHook(w http.ResponseWriter, r *http.Request) {
 
 func checkHookSecret(data []byte, secret string) bool {
 	cfg := config.Read()
-	hooksecret := cfg.Settings.HookSecret
-	sig := hmac.New(sha256.New, []byte(hooksecret))
+	return hookSignature(data, cfg.Settings.HookSecret) == secret
+}
+
+// hookSignature returns the hex encoded HMAC-SHA256 of 'data' keyed with
+// 'secret', as sent by GIN with hooks and by gin-valid with webhooks.
+func hookSignature(data []byte, secret string) string {
+	sig := hmac.New(sha256.New, []byte(secret))
 	sig.Write(data)
-	signature := hex.EncodeToString(sig.Sum(nil))
-	return signature == secret
+	return hex.EncodeToString(sig.Sum(nil))
 }
 
 func createValidHook(repopath string, validator string, usertoken gweb.UserToken) error {
@@ -16,8 +16,8 @@ import (
 func TestHooksDisable(t *testing.T) {
 	body := []byte("{}")
 	router := mux.NewRouter()
-	router.HandleFunc("/repos/{user}/{repo}/{hookid}/disable", DisableHook).Methods("GET")
-	r, _ := http.NewRequest("GET", filepath.Join("/repos/", username, "/", reponame, "/1/disable"), bytes.NewReader(body))
+	router.HandleFunc("/repos/{user}/{repo}/{hookid}/disable", DisableHook).Methods("POST")
+	r, _ := http.NewRequest("POST", filepath.Join("/repos/", username, "/", reponame, "/1/disable"), bytes.NewReader(body))
 	w := httptest.NewRecorder()
 	srvcfg := config.Read()
 	sig := hmac.New(sha256.New, []byte(srvcfg.Settings.HookSecret))
@@ -28,8 +28,8 @@ func TestHooksDisable(t *testing.T) {
 func TestHooksEnable(t *testing.T) {
 	body := []byte("{}")
 	router := mux.NewRouter()
-	router.HandleFunc("/repos/{user}/{repo}/{validator}/enable", EnableHook).Methods("GET")
-	r, _ := http.NewRequest("GET", filepath.Join("/repos/", username, "/", reponame, "/bids/enable"), bytes.NewReader(body))
+	router.HandleFunc("/repos/{user}/{repo}/{validator}/enable", EnableHook).Methods("POST")
+	r, _ := http.NewRequest("POST", filepath.Join("/repos/", username, "/", reponame, "/bids/enable"), bytes.NewReader(body))
 	w := httptest.NewRecorder()
 	srvcfg := config.Read()
 	sig := hmac.New(sha256.New, []byte(srvcfg.Settings.HookSecret))
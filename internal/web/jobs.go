@@ -0,0 +1,200 @@
+package web
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/log"
+	"github.com/G-Node/gin-valid/internal/resources"
+)
+
+// previousResults is the directory in the results directory of a job that
+// holds the results of an earlier run for the same commit until the job
+// finishes, so that they can be restored if the job is cancelled.
+const previousResults = ".previous"
+
+// jobstate describes where a job is in its life cycle.
+type jobstate uint8
+
+const (
+	jobqueued jobstate = iota
+	jobrunning
+	jobdone
+	jobfailed
+	jobcancelled
+)
+
+// jobRegistry keeps track of the most recent hook triggered job for every
+// validator and repository. A new job for a different commit cancels the
+// previous one, a new job for the same commit reuses it.
+type jobRegistry struct {
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+var repoJobs = jobRegistry{jobs: make(map[string]*job)}
+
+// jobKey returns the key of a job in the registry.
+func jobKey(validator, repopath string) string {
+	return filepath.Join(validator, repopath)
+}
+
+// active returns whether the job is waiting in the queue or running.
+func (j *job) active() bool {
+	return j.state == jobqueued || j.state == jobrunning
+}
+
+// register adds a job to the registry unless the latest job for the same
+// validator and repository validates the same commit and did not fail or get
+// cancelled. In that case the existing job is returned and 'prepare' is not
+// called. Finished jobs are only reused if the new job is not forced.
+// Otherwise, an active older job is cancelled and 'prepare' is called before
+// the registry is unlocked, so that preparing the results directory of the new
+// job cannot race with another push to the repository. If 'prepare' returns
+// false, the job is registered as done and register returns false.
+func (reg *jobRegistry) register(j *job, prepare func() bool) (*job, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	key := jobKey(j.Validator, j.Repopath)
+	if prev, ok := reg.jobs[key]; ok {
+		if prev.Commit == j.Commit && (prev.active() || (prev.state == jobdone && !j.Force)) {
+			log.ShowWrite("[Info] reusing %s job for %q (%s)", prev.Validator, prev.Repopath, prev.Commitname)
+			return prev, false
+		}
+		if prev.active() {
+			log.ShowWrite("[Info] cancelling %s job for %q (%s): superseded by %s", prev.Validator, prev.Repopath, prev.Commitname, j.Commitname)
+			prev.cancel()
+			if prev.state == jobqueued && getQueue().remove(prev) {
+				discardJob(prev)
+			}
+			prev.state = jobcancelled
+		}
+	}
+	j.state = jobqueued
+	reg.jobs[key] = j
+	if !prepare() {
+		j.state = jobdone
+		return j, false
+	}
+	return j, true
+}
+
+// setState updates the state of a job.
+func (reg *jobRegistry) setState(j *job, state jobstate) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if j.state == jobcancelled {
+		// a cancelled job stays cancelled
+		return
+	}
+	j.state = state
+}
+
+// discard removes the queue file of a cancelled job and its results, unless
+// a newer job for the same commit was registered in the meantime, which
+// writes its results to the same directory. It returns whether the results
+// were removed.
+func (reg *jobRegistry) discard(j *job) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if cur, ok := reg.jobs[jobKey(j.Validator, j.Repopath)]; ok && cur != j && cur.respath() == j.respath() {
+		log.ShowWrite("[Info] keeping results of cancelled job %q for job %s", j.respath(), cur.ID)
+		removeJob(j)
+		return false
+	}
+	discardJob(j)
+	return true
+}
+
+// newJobContext sets up the context used to cancel a job.
+func newJobContext(j *job) {
+	if j.ctx == nil {
+		j.ctx, j.cancel = context.WithCancel(context.Background())
+	}
+}
+
+// discardJob removes the results and the queue file of a cancelled job. If
+// the results directory was created by an earlier run, its results are
+// restored instead. Jobs that may have been superseded by a job for the same
+// commit must be discarded through the registry instead.
+func discardJob(j *job) {
+	resdir := filepath.Join(config.Read().Dir.Result, j.respath())
+	if restorePrevious(resdir) {
+		log.ShowWrite("[Info] restored previous results of cancelled job %q", resdir)
+	} else {
+		log.ShowWrite("[Info] removing results of cancelled job %q", resdir)
+		err := os.RemoveAll(resdir)
+		if err != nil {
+			log.ShowWrite("[Error] removing results of cancelled job %q: %s", resdir, err.Error())
+		}
+	}
+	removeJob(j)
+}
+
+// keepPrevious moves the results of an earlier run in 'resdir' to the
+// previousResults directory, unless it already holds the results of an
+// earlier run. Results of runs that did not finish are not kept.
+func keepPrevious(resdir string) error {
+	prevdir := filepath.Join(resdir, previousResults)
+	if _, err := os.Stat(prevdir); err == nil {
+		return nil
+	}
+	badge, err := ioutil.ReadFile(filepath.Join(resdir, config.Read().Label.ResultsBadge))
+	if err != nil || string(badge) == resources.ProcessingBadge {
+		// nothing to keep
+		return nil
+	}
+	files, err := ioutil.ReadDir(resdir)
+	if err != nil {
+		return err
+	}
+	err = os.Mkdir(prevdir, 0755)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		err = os.Rename(filepath.Join(resdir, fi.Name()), filepath.Join(prevdir, fi.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restorePrevious replaces the contents of 'resdir' with the results of an
+// earlier run kept by keepPrevious. It returns false if there are no such
+// results.
+func restorePrevious(resdir string) bool {
+	prevdir := filepath.Join(resdir, previousResults)
+	prevfiles, err := ioutil.ReadDir(prevdir)
+	if err != nil {
+		return false
+	}
+	files, _ := ioutil.ReadDir(resdir)
+	for _, fi := range files {
+		if fi.Name() != previousResults {
+			os.RemoveAll(filepath.Join(resdir, fi.Name()))
+		}
+	}
+	for _, fi := range prevfiles {
+		err = os.Rename(filepath.Join(prevdir, fi.Name()), filepath.Join(resdir, fi.Name()))
+		if err != nil {
+			log.ShowWrite("[Error] restoring previous results in %q: %s", resdir, err.Error())
+		}
+	}
+	os.RemoveAll(prevdir)
+	return true
+}
+
+// dropPrevious removes the results of an earlier run kept by keepPrevious
+// once the job that replaces them has finished.
+func dropPrevious(resdir string) {
+	err := os.RemoveAll(filepath.Join(resdir, previousResults))
+	if err != nil {
+		log.ShowWrite("[Error] removing previous results in %q: %s", resdir, err.Error())
+	}
+}
@@ -0,0 +1,155 @@
+package web
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/resources"
+)
+
+func TestJobRegistrySupersede(t *testing.T) {
+	srvcfg := config.Read()
+	reg := jobRegistry{jobs: make(map[string]*job)}
+	repopath := filepath.Join(username, "registry-testing")
+
+	first := &job{ID: "first", Validator: "bids", Repopath: repopath, Commit: "aaa"}
+	newJobContext(first)
+	var prepared int
+	prepare := func() bool { prepared++; return true }
+	if _, ok := reg.register(first, prepare); !ok {
+		t.Fatal("first job was not registered")
+	}
+	os.MkdirAll(filepath.Join(srvcfg.Dir.Result, first.respath()), 0755)
+	defer os.RemoveAll(filepath.Join(srvcfg.Dir.Result, "bids", repopath))
+
+	// same commit reuses the queued job
+	dup := &job{ID: "dup", Validator: "bids", Repopath: repopath, Commit: "aaa"}
+	newJobContext(dup)
+	if regjob, ok := reg.register(dup, prepare); ok || regjob != first {
+		t.Fatal("job for the same commit was not reused")
+	}
+
+	// running job is cancelled by a newer commit
+	reg.setState(first, jobrunning)
+	second := &job{ID: "second", Validator: "bids", Repopath: repopath, Commit: "bbb"}
+	newJobContext(second)
+	if _, ok := reg.register(second, prepare); !ok {
+		t.Fatal("newer job was not registered")
+	}
+	if first.ctx.Err() == nil || first.state != jobcancelled {
+		t.Fatal("superseded job was not cancelled")
+	}
+	reg.setState(first, jobdone)
+	if first.state != jobcancelled {
+		t.Fatal("cancelled job changed state")
+	}
+	if prepared != 2 {
+		t.Fatalf("expected 2 prepared jobs, got %d", prepared)
+	}
+
+	// a failed job does not block revalidating the same commit
+	reg.setState(second, jobfailed)
+	retry := &job{ID: "retry", Validator: "bids", Repopath: repopath, Commit: "bbb"}
+	newJobContext(retry)
+	if _, ok := reg.register(retry, prepare); !ok {
+		t.Fatal("failed job was reused")
+	}
+}
+
+func TestJobRegistryReturningCommit(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir("", "registry")
+	defer os.RemoveAll(tmpdir)
+	srvcfg := config.Read()
+	original := srvcfg
+	srvcfg.Dir.Result = filepath.Join(tmpdir, "results")
+	srvcfg.Dir.Queue = filepath.Join(tmpdir, "queue")
+	config.Set(srvcfg)
+	defer config.Set(original)
+
+	reg := jobRegistry{jobs: make(map[string]*job)}
+	repopath := filepath.Join(username, "registry-testing")
+	register := func(id, commit string) *job {
+		j := &job{ID: id, Validator: "bids", Repopath: repopath, Commit: commit}
+		newJobContext(j)
+		prepare := func() bool {
+			os.MkdirAll(filepath.Join(srvcfg.Dir.Result, j.respath()), 0755)
+			return true
+		}
+		if _, ok := reg.register(j, prepare); !ok {
+			t.Fatalf("job %s was not registered", id)
+		}
+		reg.setState(j, jobrunning)
+		return j
+	}
+
+	// pushes of A, B and A again while the first jobs are still running
+	first := register("first", "aaa")
+	second := register("second", "bbb")
+	third := register("third", "aaa")
+	if first.ctx.Err() == nil || second.ctx.Err() == nil || third.ctx.Err() != nil {
+		t.Fatal("unexpected cancelled jobs")
+	}
+
+	// the cancelled job for A must not remove the results of the new one
+	if reg.discard(first) {
+		t.Fatal("results of a job superseded by the same commit were removed")
+	}
+	if _, err := os.Stat(filepath.Join(srvcfg.Dir.Result, third.respath())); err != nil {
+		t.Fatalf("results of the running job were removed: %v", err)
+	}
+	if !reg.discard(second) {
+		t.Fatal("results of a cancelled job were kept")
+	}
+	if _, err := os.Stat(filepath.Join(srvcfg.Dir.Result, second.respath())); !os.IsNotExist(err) {
+		t.Fatalf("results of the cancelled job were not removed: %v", err)
+	}
+}
+
+func TestJobCancelledRevalidation(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir("", "registry")
+	defer os.RemoveAll(tmpdir)
+	srvcfg := config.Read()
+	original := srvcfg
+	srvcfg.Dir.Result = filepath.Join(tmpdir, "results")
+	srvcfg.Dir.Queue = filepath.Join(tmpdir, "queue")
+	config.Set(srvcfg)
+	defer config.Set(original)
+
+	// completed results of an earlier run of the commit
+	reg := jobRegistry{jobs: make(map[string]*job)}
+	repopath := filepath.Join(username, "registry-testing")
+	forced := &job{ID: "forced", Validator: "bids", Repopath: repopath, Commit: "aaa", Automatic: true, Force: true}
+	newJobContext(forced)
+	resdir := filepath.Join(srvcfg.Dir.Result, forced.respath())
+	os.MkdirAll(resdir, 0755)
+	ioutil.WriteFile(filepath.Join(resdir, srvcfg.Label.ResultsBadge), []byte(resources.SuccessBadge), 0644)
+	ioutil.WriteFile(filepath.Join(resdir, srvcfg.Label.ResultsFile), []byte("earlier run"), 0644)
+
+	// the forced revalidation is superseded by a push of another commit
+	if _, ok := reg.register(forced, func() bool { return prepareJob(forced) }); !ok {
+		t.Fatal("forced job was not registered")
+	}
+	if hasResults(resdir) {
+		t.Fatal("forced job did not replace the earlier results")
+	}
+	reg.setState(forced, jobrunning)
+	newer := &job{ID: "newer", Validator: "bids", Repopath: repopath, Commit: "bbb"}
+	newJobContext(newer)
+	if _, ok := reg.register(newer, func() bool { return true }); !ok {
+		t.Fatal("newer job was not registered")
+	}
+
+	// the cancelled job restores the results of the earlier run
+	reg.discard(forced)
+	badge, _ := ioutil.ReadFile(filepath.Join(resdir, srvcfg.Label.ResultsBadge))
+	content, _ := ioutil.ReadFile(filepath.Join(resdir, srvcfg.Label.ResultsFile))
+	if string(badge) != resources.SuccessBadge || string(content) != "earlier run" {
+		t.Fatalf("results of the earlier run were not restored: %q", content)
+	}
+	if _, err := os.Stat(filepath.Join(resdir, previousResults)); !os.IsNotExist(err) {
+		t.Fatalf("kept results were not removed: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/G-Node/gin-cli/ginclient"
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/log"
+	"github.com/G-Node/gin-valid/internal/resources/templates"
+	"github.com/G-Node/gin-valid/internal/validators"
+)
+
+// maxMailIssues is the number of errors listed in a notification email.
+const maxMailIssues = 20
+
+// mailInfo is the data required by the templates.NotificationMail template.
+type mailInfo struct {
+	Title      string
+	Repository string
+	Commit     string
+	URL        string
+	Failed     bool
+	WasPassing bool
+	Errors     int
+	Warnings   int
+	Issues     []validators.Issue
+	MoreIssues int
+}
+
+// notifyResult emails the owner of the repository and the recipients
+// configured for it when a hook triggered job found errors, or when the
+// validator failed to run after the previous validation of the repository
+// passed. 'failed' denotes whether the validator failed to run and 'resdir'
+// is the results directory of the job.
+func notifyResult(j *job, gcl *ginclient.Client, resdir string, failed bool) {
+	srvcfg := config.Read()
+	if srvcfg.Notify.Host == "" || !j.Automatic {
+		return
+	}
+	info := mailInfo{
+		Title:      strings.ToUpper(j.Validator),
+		Repository: j.Repopath,
+		Commit:     j.Commit,
+		Failed:     failed,
+	}
+	if !failed {
+		rep, err := validators.ReadReport(resdir)
+		if err != nil {
+			log.ShowWrite("[Error] reading report for notification on %q: %s", j.Repopath, err.Error())
+			return
+		}
+		if rep.Errors == 0 {
+			return
+		}
+		info.Errors = rep.Errors
+		info.Warnings = rep.Warnings
+		for _, issue := range rep.Issues {
+			if issue.Severity != validators.SeverityError {
+				continue
+			}
+			if len(info.Issues) == maxMailIssues {
+				info.MoreIssues++
+				continue
+			}
+			info.Issues = append(info.Issues, issue)
+		}
+	}
+	prev := previousOutcome(j)
+	info.WasPassing = prev != "" && prev != "error"
+	if failed && !info.WasPassing {
+		return
+	}
+
+	target, err := url.Parse(srvcfg.Settings.RootURL)
+	if err != nil {
+		log.ShowWrite("[Error] failed to parse url: %s", err.Error())
+		return
+	}
+	target.Path = path.Join(target.Path, "results", j.respath())
+	info.URL = target.String()
+
+	recipients := append([]string{}, srvcfg.Notify.Recipients[j.Repopath]...)
+	if gcl != nil {
+		repoinfo, err := gcl.GetRepo(j.Repopath)
+		if err != nil {
+			log.ShowWrite("[Error] getting owner of %q for notification: %s", j.Repopath, err.Error())
+		} else if repoinfo.Owner != nil && repoinfo.Owner.Email != "" {
+			recipients = append(recipients, repoinfo.Owner.Email)
+		}
+	}
+	if len(recipients) == 0 {
+		log.ShowWrite("[Info] no recipients for %s notification on %q", j.Validator, j.Repopath)
+		return
+	}
+
+	subject := fmt.Sprintf("[gin-valid] %s validation of %s found %d errors", info.Title, j.Repopath, info.Errors)
+	if failed {
+		subject = fmt.Sprintf("[gin-valid] %s validation of %s failed to run", info.Title, j.Repopath)
+	}
+	tmpl, err := template.New("mail").Parse(templates.NotificationMail)
+	if err != nil {
+		log.ShowWrite("[Error] failed to parse notification template: %s", err.Error())
+		return
+	}
+	var body bytes.Buffer
+	err = tmpl.Execute(&body, info)
+	if err != nil {
+		log.ShowWrite("[Error] failed to render notification: %s", err.Error())
+		return
+	}
+	err = sendMail(recipients, subject, body.String())
+	if err != nil {
+		log.ShowWrite("[Error] sending notification for %q: %s", j.Repopath, err.Error())
+		return
+	}
+	log.ShowWrite("[Info] sent %s notification for %q to %d recipients", j.Validator, j.Repopath, len(recipients))
+}
+
+// previousOutcome returns the outcome of the last completed validation of the
+// repository before the job's. It returns an empty string if there is none.
+func previousOutcome(j *job) string {
+	user, repo := path.Split(j.Repopath)
+	hist, _, err := readHistory(map[string]string{"validator": j.Validator, "user": strings.TrimSuffix(user, "/"), "repo": repo})
+	if err != nil {
+		return ""
+	}
+	// the job just finished, so all other completed runs precede it
+	for _, run := range hist.Runs {
+		if run.ID != j.Commit && run.State == statedone {
+			return run.Outcome
+		}
+	}
+	return ""
+}
+
+// sendMail sends a plain text email to the recipients through the configured
+// SMTP server.
+func sendMail(recipients []string, subject, body string) error {
+	notifycfg := config.Read().Notify
+	addr := net.JoinHostPort(notifycfg.Host, strconv.Itoa(notifycfg.Port))
+	var auth smtp.Auth
+	if notifycfg.Username != "" {
+		auth = smtp.PlainAuth("", notifycfg.Username, notifycfg.Password, notifycfg.Host)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", notifycfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(recipients, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return smtp.SendMail(addr, auth, notifycfg.From, recipients, msg.Bytes())
+}
@@ -0,0 +1,193 @@
+package web
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/G-Node/gin-cli/ginclient"
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/resources"
+	"github.com/G-Node/gin-valid/internal/validators"
+)
+
+// sentMail is an email received by the SMTP sink.
+type sentMail struct {
+	recipients []string
+	data       string
+}
+
+// smtpSink starts a minimal SMTP server that accepts all mail and sends it to
+// the returned channel.
+func smtpSink(t *testing.T) (net.Listener, chan sentMail) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start SMTP sink: %s", err.Error())
+	}
+	received := make(chan sentMail, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				rd := bufio.NewReader(conn)
+				reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+				reply("220 localhost")
+				mail := sentMail{}
+				for {
+					line, err := rd.ReadString('\n')
+					if err != nil {
+						return
+					}
+					cmd := strings.ToUpper(strings.TrimSpace(line))
+					switch {
+					case strings.HasPrefix(cmd, "RCPT TO:"):
+						rcpt := strings.TrimSpace(line)[len("RCPT TO:"):]
+						mail.recipients = append(mail.recipients, strings.Trim(rcpt, "<>"))
+						reply("250 OK")
+					case cmd == "DATA":
+						reply("354 go ahead")
+						var data strings.Builder
+						for {
+							line, err := rd.ReadString('\n')
+							if err != nil {
+								return
+							}
+							if line == ".\r\n" {
+								break
+							}
+							data.WriteString(line)
+						}
+						mail.data = data.String()
+						received <- mail
+						reply("250 OK")
+					case cmd == "QUIT":
+						reply("221 bye")
+						return
+					default:
+						reply("250 OK")
+					}
+				}
+			}(conn)
+		}
+	}()
+	return ln, received
+}
+
+// writeNotifyRun stores the results of a completed nix validation run.
+func writeNotifyRun(resdir string, finished time.Time, issues []validators.Issue) {
+	srvcfg := config.Read()
+	os.MkdirAll(resdir, 0755)
+	rep := validators.NewReport("nix", issues)
+	rep.Commit = filepath.Base(resdir)
+	rep.Finished = finished
+	validators.WriteReport(resdir, rep)
+	ioutil.WriteFile(filepath.Join(resdir, srvcfg.Label.ResultsFile), []byte(""), 0644)
+	ioutil.WriteFile(filepath.Join(resdir, srvcfg.Label.ResultsBadge), []byte(rep.Badge()), 0644)
+}
+
+func TestNotifyResult(t *testing.T) {
+	ln, received := smtpSink(t)
+	defer ln.Close()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	gin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/repos/"+username+"/notify-testing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"full_name": "` + username + `/notify-testing", "owner": {"username": "` + username + `", "email": "owner@example.org"}}`))
+	}))
+	defer gin.Close()
+	gcl := ginclient.New("")
+	gcl.Host = gin.URL
+
+	srvcfg := config.Read()
+	original := srvcfg
+	srvcfg.Settings.RootURL = "https://valid.example.org"
+	srvcfg.Notify.Host = host
+	srvcfg.Notify.Port, _ = strconv.Atoi(port)
+	srvcfg.Notify.Recipients = map[string][]string{username + "/notify-testing": {"team@example.org"}}
+	config.Set(srvcfg)
+	defer config.Set(original)
+
+	repodir := filepath.Join(srvcfg.Dir.Result, "nix", username, "notify-testing")
+	defer os.RemoveAll(repodir)
+	finished := time.Now().Add(-time.Hour)
+
+	expectMail := func() sentMail {
+		select {
+		case mail := <-received:
+			return mail
+		case <-time.After(5 * time.Second):
+			t.Fatal("notification not sent")
+		}
+		return sentMail{}
+	}
+	expectNoMail := func() {
+		select {
+		case mail := <-received:
+			t.Fatalf("unexpected notification to %v", mail.recipients)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	// a passing run does not notify anyone
+	j := &job{Validator: "nix", Repopath: username + "/notify-testing", Commit: "first", Automatic: true}
+	resdir := filepath.Join(repodir, j.Commit)
+	writeNotifyRun(resdir, finished, nil)
+	notifyResult(j, gcl, resdir, false)
+	expectNoMail()
+
+	// a run with errors notifies the owner and the configured recipients
+	j = &job{Validator: "nix", Repopath: username + "/notify-testing", Commit: "second", Automatic: true}
+	resdir = filepath.Join(repodir, j.Commit)
+	writeNotifyRun(resdir, finished.Add(time.Minute), []validators.Issue{{Severity: validators.SeverityError, File: "data.nix", Message: "broken"}})
+	notifyResult(j, gcl, resdir, false)
+	mail := expectMail()
+	if len(mail.recipients) != 2 || mail.recipients[0] != "team@example.org" || mail.recipients[1] != "owner@example.org" {
+		t.Fatalf("unexpected recipients %v", mail.recipients)
+	}
+	for _, expected := range []string{
+		"Subject: [gin-valid] NIX validation of " + username + "/notify-testing found 1 errors",
+		"data.nix: broken",
+		"The previous validation of the repository passed.",
+		"https://valid.example.org/results/nix/" + username + "/notify-testing/second",
+	} {
+		if !strings.Contains(mail.data, expected) {
+			t.Fatalf("notification does not contain %q:\n%s", expected, mail.data)
+		}
+	}
+
+	// one-time validations do not notify anyone
+	j.Automatic = false
+	notifyResult(j, gcl, resdir, false)
+	expectNoMail()
+
+	// failing to run only notifies if the previous validation passed
+	j = &job{Validator: "nix", Repopath: username + "/notify-testing", Commit: "third", Automatic: true}
+	resdir = filepath.Join(repodir, j.Commit)
+	os.MkdirAll(resdir, 0755)
+	ioutil.WriteFile(filepath.Join(resdir, srvcfg.Label.ResultsBadge), []byte(resources.FailureBadge), 0644)
+	ioutil.WriteFile(filepath.Join(resdir, srvcfg.Label.ResultsFile), []byte("ERROR: The validator failed to run"), 0644)
+	notifyResult(j, gcl, resdir, true)
+	expectNoMail()
+
+	os.RemoveAll(filepath.Join(repodir, "second"))
+	notifyResult(j, gcl, resdir, true)
+	mail = expectMail()
+	if !strings.Contains(mail.data, "failed to run") {
+		t.Fatalf("unexpected notification:\n%s", mail.data)
+	}
+}
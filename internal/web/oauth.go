@@ -0,0 +1,289 @@
+package web
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	gweb "github.com/G-Node/gin-cli/web"
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/log"
+	"github.com/G-Node/gin-valid/internal/store"
+)
+
+// oauthStateCookie holds the random state of a pending OAuth login, which
+// the authorization server passes back to the callback.
+const oauthStateCookie = "gin-valid-oauth-state"
+
+// oauthStateAge is the number of seconds a user has to authorize the service
+// on the authorization server.
+const oauthStateAge = 600
+
+// maxOAuthResponse limits the size of responses from the authorization
+// server.
+const maxOAuthResponse = 1 << 20
+
+// oauthClient makes the requests to the authorization server.
+var oauthClient = &http.Client{Timeout: 30 * time.Second}
+
+// tokenRefreshMargin is the time before their expiry from which on tokens are
+// refreshed before they are used, so they do not expire during a request.
+const tokenRefreshMargin = time.Minute
+
+// refreshMu serialises refreshing tokens.
+var refreshMu sync.Mutex
+
+// oauthLogin returns whether users log in with OAuth instead of forwarding
+// their password to GIN.
+func oauthLogin() bool {
+	return config.Read().Settings.LoginMode == "oauth"
+}
+
+// oauthConfig returns the OAuth settings with the endpoints that are not set
+// pointing to the GIN server.
+func oauthConfig() config.OAuth {
+	cfg := config.Read()
+	oauth := cfg.Settings.OAuth
+	ginurl := strings.TrimRight(cfg.GINAddresses.WebURL, "/")
+	if oauth.AuthURL == "" {
+		oauth.AuthURL = ginurl + "/login/oauth/authorize"
+	}
+	if oauth.TokenURL == "" {
+		oauth.TokenURL = ginurl + "/login/oauth/access_token"
+	}
+	if oauth.UserInfoURL == "" {
+		oauth.UserInfoURL = ginurl + "/login/oauth/userinfo"
+	}
+	return oauth
+}
+
+// oauthRedirectURL returns the callback URL the authorization server sends
+// users back to, which must be registered with the client.
+func oauthRedirectURL() string {
+	return strings.TrimRight(config.Read().Settings.RootURL, "/") + "/oauth/callback"
+}
+
+// stateCookie returns the cookie holding the state of an OAuth login.
+func stateCookie(state string, maxage int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state,
+		Path:     "/oauth",
+		MaxAge:   maxage,
+		Secure:   secureCookies(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// OAuthLogin redirects the user to the authorization server to authorize the
+// service to access GIN on behalf of the user.
+func OAuthLogin(w http.ResponseWriter, r *http.Request) {
+	if !oauthLogin() {
+		fail(w, http.StatusNotFound, "not found")
+		return
+	}
+	oauth := oauthConfig()
+	authurl, err := url.Parse(oauth.AuthURL)
+	if err != nil {
+		log.ShowWrite("[Error] invalid OAuth authorization URL %q: %s", oauth.AuthURL, err.Error())
+		fail(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	state, err := generateNewSessionID()
+	if err != nil {
+		fail(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	http.SetCookie(w, stateCookie(state, oauthStateAge))
+
+	query := authurl.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", oauth.ClientID)
+	query.Set("redirect_uri", oauthRedirectURL())
+	query.Set("scope", strings.Join(oauth.Scopes, " "))
+	query.Set("state", state)
+	authurl.RawQuery = query.Encode()
+	http.Redirect(w, r, authurl.String(), http.StatusFound)
+}
+
+// OAuthCallback completes an OAuth login: it exchanges the authorization code
+// for an access token, stores the token and starts a session for its user.
+func OAuthCallback(w http.ResponseWriter, r *http.Request) {
+	if !oauthLogin() {
+		fail(w, http.StatusNotFound, "not found")
+		return
+	}
+	query := r.URL.Query()
+	cookie, err := r.Cookie(oauthStateCookie)
+	// the state is only valid for one attempt
+	http.SetCookie(w, stateCookie("", -1))
+	if err != nil || cookie.Value == "" || !hmac.Equal([]byte(query.Get("state")), []byte(cookie.Value)) {
+		log.Write("[Error] OAuth callback with invalid state")
+		fail(w, http.StatusBadRequest, "invalid login attempt, please log in again")
+		return
+	}
+	if errcode := query.Get("error"); errcode != "" {
+		log.Write("[Info] OAuth login not authorized: %s", errcode)
+		fail(w, http.StatusUnauthorized, "authentication failed")
+		return
+	}
+
+	ut, err := oauthExchange(query.Get("code"))
+	if err != nil {
+		log.ShowWrite("[Error] OAuth login failed: %s", err.Error())
+		fail(w, http.StatusUnauthorized, "authentication failed")
+		return
+	}
+	sessionid, err := newSession(ut)
+	if err != nil {
+		log.ShowWrite("[Error] starting session of %q: %s", ut.Username, err.Error())
+		fail(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	log.Write("Login successful. Username: %s", ut.Username)
+	http.SetCookie(w, sessionCookie(sessionid, cookieExp()))
+	http.Redirect(w, r, fmt.Sprintf("/repos/%s", ut.Username), http.StatusFound)
+}
+
+// oauthExchange exchanges an authorization code for an access token at the
+// token endpoint and returns it with the name of the user it belongs to and
+// the refresh token to renew it.
+func oauthExchange(code string) (store.UserToken, error) {
+	ut, err := oauthToken(url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {oauthRedirectURL()},
+	})
+	if err != nil {
+		return store.UserToken{}, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, oauthConfig().UserInfoURL, nil)
+	if err != nil {
+		return store.UserToken{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+ut.Token)
+	info := struct {
+		PreferredUsername string `json:"preferred_username"`
+		Login             string `json:"login"`
+	}{}
+	status, err := oauthRequest(req, &info)
+	if err != nil {
+		return store.UserToken{}, fmt.Errorf("requesting user info: %s", err.Error())
+	}
+	ut.Username = info.PreferredUsername
+	if ut.Username == "" {
+		ut.Username = info.Login
+	}
+	if status != http.StatusOK || ut.Username == "" {
+		return store.UserToken{}, fmt.Errorf("requesting user info: no username in response with status %d", status)
+	}
+	return ut, nil
+}
+
+// oauthRefresh renews an expired token with its refresh token. Authorization
+// servers may issue a new refresh token with every access token; otherwise
+// the old one is kept.
+func oauthRefresh(ut store.UserToken) (store.UserToken, error) {
+	refreshed, err := oauthToken(url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {ut.RefreshToken},
+	})
+	if err != nil {
+		return store.UserToken{}, err
+	}
+	refreshed.Username = ut.Username
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = ut.RefreshToken
+	}
+	return refreshed, nil
+}
+
+// oauthToken requests an access token for a grant from the token endpoint.
+// The returned token has no username.
+func oauthToken(form url.Values) (store.UserToken, error) {
+	oauth := oauthConfig()
+	form.Set("client_id", oauth.ClientID)
+	form.Set("client_secret", oauth.ClientSecret)
+	req, err := http.NewRequest(http.MethodPost, oauth.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return store.UserToken{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	token := struct {
+		AccessToken      string `json:"access_token"`
+		RefreshToken     string `json:"refresh_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{}
+	requested := time.Now()
+	status, err := oauthRequest(req, &token)
+	if err != nil {
+		return store.UserToken{}, fmt.Errorf("requesting access token: %s", err.Error())
+	}
+	if token.Error != "" {
+		return store.UserToken{}, fmt.Errorf("requesting access token: %s %s", token.Error, token.ErrorDescription)
+	}
+	if status != http.StatusOK || token.AccessToken == "" {
+		return store.UserToken{}, fmt.Errorf("requesting access token: no token in response with status %d", status)
+	}
+	ut := store.UserToken{Token: token.AccessToken, RefreshToken: token.RefreshToken}
+	if token.ExpiresIn > 0 {
+		ut.Expiry = requested.Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return ut, nil
+}
+
+// freshToken returns the GIN token of a stored token. Tokens that expire
+// within tokenRefreshMargin are refreshed and saved to the store first.
+func freshToken(ts store.TokenStore, ut store.UserToken) (gweb.UserToken, error) {
+	if ut.Expiry.IsZero() || time.Until(ut.Expiry) > tokenRefreshMargin {
+		return ut.GIN(), nil
+	}
+	// a refresh token can only be used once, so concurrent requests must
+	// not refresh the same token
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+	if current, err := ts.Token(ut.Username); err == nil && current.Token != ut.Token {
+		// refreshed while waiting for the lock
+		ut = current
+		if time.Until(ut.Expiry) > tokenRefreshMargin {
+			return ut.GIN(), nil
+		}
+	}
+	if ut.RefreshToken == "" {
+		return gweb.UserToken{}, fmt.Errorf("token of user %q expired", ut.Username)
+	}
+	refreshed, err := oauthRefresh(ut)
+	if err != nil {
+		return gweb.UserToken{}, fmt.Errorf("refreshing token of user %q: %s", ut.Username, err.Error())
+	}
+	if err = ts.SaveToken(refreshed); err != nil {
+		return gweb.UserToken{}, err
+	}
+	log.Write("[Info] refreshed token of user %q", ut.Username)
+	return refreshed.GIN(), nil
+}
+
+// oauthRequest sends a request to the authorization server and decodes the
+// JSON response into 'v'. It returns the status code of the response.
+func oauthRequest(req *http.Request, v interface{}) (int, error) {
+	req.Header.Set("Accept", "application/json")
+	res, err := oauthClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxOAuthResponse)).Decode(v); err != nil {
+		return res.StatusCode, fmt.Errorf("invalid response with status %d: %s", res.StatusCode, err.Error())
+	}
+	return res.StatusCode, nil
+}
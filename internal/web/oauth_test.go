@@ -0,0 +1,201 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/gorilla/mux"
+)
+
+// fakeAuthServer is a local OAuth2 authorization server that issues an access
+// token for a single user in exchange for the authorization code "good-code".
+// Access tokens expire after an hour and the refresh token is replaced with
+// every refresh.
+type fakeAuthServer struct {
+	*httptest.Server
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	username     string
+	token        string
+	refreshToken string
+	refreshes    int
+}
+
+func newFakeAuthServer(clientID, clientSecret, redirectURL, username string) *fakeAuthServer {
+	as := &fakeAuthServer{clientID: clientID, clientSecret: clientSecret, redirectURL: redirectURL, username: username, token: "access-token", refreshToken: "refresh-token"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/access_token", as.accessToken)
+	mux.HandleFunc("/login/oauth/userinfo", as.userInfo)
+	as.Server = httptest.NewServer(mux)
+	return as
+}
+
+func (as *fakeAuthServer) accessToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost || r.FormValue("client_id") != as.clientID || r.FormValue("client_secret") != as.clientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+	switch {
+	case r.FormValue("grant_type") == "authorization_code" && r.FormValue("redirect_uri") == as.redirectURL && r.FormValue("code") == "good-code":
+	case r.FormValue("grant_type") == "refresh_token" && r.FormValue("refresh_token") == as.refreshToken:
+		as.refreshes++
+		as.token = fmt.Sprintf("access-token-%d", as.refreshes)
+		as.refreshToken = fmt.Sprintf("refresh-token-%d", as.refreshes)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"access_token": as.token, "token_type": "bearer", "refresh_token": as.refreshToken, "expires_in": 3600})
+}
+
+func (as *fakeAuthServer) userInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Header.Get("Authorization") != "Bearer "+as.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_token"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"sub": "1", "preferred_username": as.username})
+}
+
+func TestOAuthLogin(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir("", "oauth")
+	defer os.RemoveAll(tmpdir)
+	srvcfg := config.Read()
+	original := srvcfg
+	defer config.Set(original)
+	srvcfg.Dir.Tokens = filepath.Join(tmpdir, "tokens")
+	srvcfg.TokenStore.KeyFile = filepath.Join(tmpdir, "tokens.key")
+	srvcfg.Settings.RootURL = "http://valid.example.org/"
+	srvcfg.Settings.LoginMode = "oauth"
+	srvcfg.Settings.OAuth.ClientID = "valid-client"
+	srvcfg.Settings.OAuth.ClientSecret = "client-secret"
+	as := newFakeAuthServer("valid-client", "client-secret", "http://valid.example.org/oauth/callback", "valid-user")
+	defer as.Close()
+	srvcfg.GINAddresses.WebURL = as.URL
+	config.Set(srvcfg)
+	if err := LoadTokenKeys(); err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(srvcfg.Dir.Tokens, "by-sessionid"), 0755)
+	os.MkdirAll(filepath.Join(srvcfg.Dir.Tokens, "by-repo"), 0755)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/login", LoginGet).Methods("GET")
+	r.HandleFunc("/login", LoginPost).Methods("POST")
+	r.HandleFunc("/oauth/login", OAuthLogin).Methods("GET")
+	r.HandleFunc("/oauth/callback", OAuthCallback).Methods("GET")
+	request := func(method, path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// passwords are not accepted
+	if rr := request("POST", "/login"); rr.Code != http.StatusForbidden {
+		t.Fatalf("password login returned %d", rr.Code)
+	}
+	if rr := request("GET", "/login"); !strings.Contains(rr.Body.String(), `href="/oauth/login"`) || strings.Contains(rr.Body.String(), "password") {
+		t.Fatalf("unexpected OAuth login page: %s", rr.Body.String())
+	}
+
+	// the user is sent to the authorization server
+	rr := request("GET", "/oauth/login")
+	authurl, err := url.Parse(rr.Header().Get("Location"))
+	if rr.Code != http.StatusFound || err != nil || authurl.Path != "/login/oauth/authorize" {
+		t.Fatalf("unexpected authorization redirect %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+	query := authurl.Query()
+	if query.Get("client_id") != "valid-client" || query.Get("response_type") != "code" || query.Get("redirect_uri") != as.redirectURL || query.Get("state") == "" {
+		t.Fatalf("unexpected authorization request %v", query)
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != oauthStateCookie || cookies[0].Value != query.Get("state") || !cookies[0].HttpOnly {
+		t.Fatalf("unexpected state cookie %v", cookies)
+	}
+	state := cookies[0]
+
+	// the callback requires the state of the login attempt
+	if rr := request("GET", "/oauth/callback?code=good-code&state=forged", state); rr.Code != http.StatusBadRequest {
+		t.Fatalf("callback with forged state returned %d", rr.Code)
+	}
+	if rr := request("GET", "/oauth/callback?code=good-code&state="+url.QueryEscape(state.Value)); rr.Code != http.StatusBadRequest {
+		t.Fatalf("callback without state cookie returned %d", rr.Code)
+	}
+	if rr := request("GET", "/oauth/callback?error=access_denied&state="+url.QueryEscape(state.Value), state); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("denied authorization returned %d", rr.Code)
+	}
+	if rr := request("GET", "/oauth/callback?code=bad-code&state="+url.QueryEscape(state.Value), state); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("invalid code returned %d", rr.Code)
+	}
+
+	// a valid code starts a session with the access token
+	rr = request("GET", "/oauth/callback?code=good-code&state="+url.QueryEscape(state.Value), state)
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != "/repos/valid-user" {
+		t.Fatalf("unexpected callback response %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+	var session *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == srvcfg.Settings.CookieName {
+			session = c
+		}
+	}
+	if session == nil {
+		t.Fatal("no session cookie after OAuth login")
+	}
+	if ut, err := getTokenBySession(session.Value); err != nil || ut.Username != "valid-user" || ut.Token != "access-token" {
+		t.Fatalf("unexpected token of OAuth session %+v: %v", ut, err)
+	}
+	ts, _ := tokenStore()
+	stored, err := ts.Token("valid-user")
+	if err != nil || stored.RefreshToken != "refresh-token" || time.Until(stored.Expiry) < 59*time.Minute {
+		t.Fatalf("refresh token and expiry not stored %+v: %v", stored, err)
+	}
+
+	// expired tokens are refreshed before they are used
+	stored.Expiry = time.Now().Add(-time.Minute)
+	ts.SaveToken(stored)
+	linkToRepo("valid-user", "valid-user/data")
+	if ut, err := getTokenByRepo("valid-user/data"); err != nil || ut.Token != "access-token-1" {
+		t.Fatalf("expired token of repository not refreshed %+v: %v", ut, err)
+	}
+	if ut, err := getTokenBySession(session.Value); err != nil || ut.Token != "access-token-1" || as.refreshes != 1 {
+		t.Fatalf("refreshed token not stored %+v: %v", ut, err)
+	}
+	if stored, err = ts.Token("valid-user"); err != nil || stored.RefreshToken != "refresh-token-1" || time.Until(stored.Expiry) < 59*time.Minute {
+		t.Fatalf("refreshed token stored without new refresh token and expiry %+v: %v", stored, err)
+	}
+
+	// tokens that cannot be refreshed are not used after they expired
+	stored.Expiry = time.Now().Add(-time.Minute)
+	stored.RefreshToken = "revoked"
+	ts.SaveToken(stored)
+	if ut, err := getTokenBySession(session.Value); err == nil {
+		t.Fatalf("expired token with revoked refresh token returned %+v", ut)
+	}
+
+	// the OAuth routes are disabled in password mode
+	srvcfg.Settings.LoginMode = "password"
+	config.Set(srvcfg)
+	if rr := request("GET", "/oauth/login"); rr.Code != http.StatusNotFound {
+		t.Fatalf("OAuth login in password mode returned %d", rr.Code)
+	}
+}
@@ -9,4 +9,9 @@ const (
 	serveralias = "gin"
 	/* fixes G-Node/gin-valid#59 */
 	progressmsg = "A validation job for this repository is currently in progress, please do not leave this page and refresh the page after a while."
+	queuedmsg   = "A validation job for this repository is waiting in the queue at position %d, please refresh the page after a while."
+	waitingmsg  = "Waiting for data: the validation job for this repository is waiting for annexed content to be uploaded to the server. The validation starts once all content is available, please refresh the page after a while."
+	timedoutmsg = "The validator did not finish within its time limit and was stopped."
+	// deletedcommit is the 'after' commit of a push deleting a branch or tag
+	deletedcommit = "0000000000000000000000000000000000000000"
 )
@@ -0,0 +1,308 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/G-Node/gin-cli/ginclient"
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/log"
+	"github.com/G-Node/gin-valid/internal/validators"
+)
+
+// job describes a single validation run waiting in or taken from the queue.
+// Jobs are stored in config.Dir.Queue until they are finished, so that they
+// can be restored when the server restarts.
+type job struct {
+	ID         string    `json:"id"`
+	Validator  string    `json:"validator"`
+	Repopath   string    `json:"repopath"`
+	Commit     string    `json:"commit"`
+	Commitname string    `json:"commitname"`
+	Automatic  bool      `json:"automatic"`
+	Queued     time.Time `json:"queued"`
+	// Force runs the validation even if results for the commit exist.
+	Force bool `json:"force"`
+
+	// gcl is the client used to clone the repository. It is not persisted
+	// and is recreated with jobClient for restored jobs.
+	gcl *ginclient.Client
+	// ctx is cancelled when the job is superseded by a newer job.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// state is protected by the mutex of repoJobs.
+	state jobstate
+}
+
+// respath returns the path of the job results relative to config.Dir.Result.
+func (j *job) respath() string {
+	return filepath.Join(j.Validator, j.Repopath, j.Commit)
+}
+
+// jobQueue holds the pending jobs and the number of running jobs per
+// validator. Workers take the oldest pending job whose validator has not
+// reached its concurrency limit.
+type jobQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []*job
+	running map[string]int
+}
+
+var (
+	queue     *jobQueue
+	queueOnce sync.Once
+)
+
+// getQueue returns the job queue, starting the configured number of workers
+// on first use.
+func getQueue() *jobQueue {
+	queueOnce.Do(func() {
+		queue = &jobQueue{running: make(map[string]int)}
+		queue.cond = sync.NewCond(&queue.mu)
+		workers := config.Read().Settings.Workers
+		if workers < 1 {
+			workers = 1
+		}
+		log.ShowWrite("[Info] starting %d validation workers", workers)
+		for idx := 0; idx < workers; idx++ {
+			go queue.work()
+		}
+	})
+	return queue
+}
+
+// StartQueue starts the validation workers and requeues all jobs that were
+// stored in the queue directory when the server last stopped.
+func StartQueue() {
+	q := getQueue()
+	queuedir := config.Read().Dir.Queue
+	files, err := ioutil.ReadDir(queuedir)
+	if err != nil {
+		log.ShowWrite("[Warning] reading queue directory %q: %s", queuedir, err.Error())
+		return
+	}
+	restored := make([]*job, 0, len(files))
+	for _, fi := range files {
+		if fi.IsDir() || filepath.Ext(fi.Name()) != ".json" {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(queuedir, fi.Name()))
+		if err != nil {
+			log.ShowWrite("[Error] reading queued job %q: %s", fi.Name(), err.Error())
+			continue
+		}
+		j := &job{}
+		if err = json.Unmarshal(content, j); err != nil {
+			log.ShowWrite("[Error] parsing queued job %q: %s", fi.Name(), err.Error())
+			continue
+		}
+		restored = append(restored, j)
+	}
+	sort.Slice(restored, func(i, k int) bool {
+		return restored[i].Queued.Before(restored[k].Queued)
+	})
+	for _, j := range restored {
+		log.ShowWrite("[Info] restoring queued %s job for %q (%s)", j.Validator, j.Repopath, j.Commitname)
+		newJobContext(j)
+		if j.Automatic {
+			if _, ok := repoJobs.register(j, func() bool { return true }); !ok {
+				removeJob(j)
+				continue
+			}
+		}
+		q.push(j)
+	}
+}
+
+// enqueue stores a job in the queue directory and adds it to the queue.
+func enqueue(j *job) {
+	newJobContext(j)
+	j.Queued = time.Now()
+	saveJob(j)
+	getQueue().push(j)
+}
+
+func (q *jobQueue) push(j *job) {
+	q.mu.Lock()
+	q.pending = append(q.pending, j)
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// remove takes a job out of the pending jobs. It returns false if the job
+// was not pending.
+func (q *jobQueue) remove(j *job) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for idx, pj := range q.pending {
+		if pj == j {
+			q.pending = append(q.pending[:idx], q.pending[idx+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// next blocks until a job can be run and removes it from the pending jobs.
+func (q *jobQueue) next() *job {
+	limits := config.Read().Settings.ValidatorWorkers
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		for idx, j := range q.pending {
+			limit, ok := limits[j.Validator]
+			if ok && limit > 0 && q.running[j.Validator] >= limit {
+				continue
+			}
+			q.pending = append(q.pending[:idx], q.pending[idx+1:]...)
+			q.running[j.Validator]++
+			return j
+		}
+		q.cond.Wait()
+	}
+}
+
+// done marks a job of the given validator as finished.
+func (q *jobQueue) done(j *job) {
+	q.mu.Lock()
+	q.running[j.Validator]--
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// work runs jobs from the queue until the server shuts down.
+func (q *jobQueue) work() {
+	for {
+		j := q.next()
+		repoJobs.setState(j, jobrunning)
+		resdir := filepath.Join(config.Read().Dir.Result, j.respath())
+		gcl, err := jobClient(j)
+		if err != nil {
+			log.ShowWrite("[Error] no client for %s job on %q: %s", j.Validator, j.Repopath, err.Error())
+			writeValFailure(resdir)
+		} else {
+			err = runJob(j, gcl)
+		}
+		cancelled := j.ctx.Err() != nil
+		if cancelled {
+			// the status of the commit belongs to a newer job for it
+			if repoJobs.discard(j) {
+				reportStatus(j, gcl, statuserror, "Validation cancelled by a newer push")
+			}
+		} else if errors.Is(err, validators.ErrTimedOut) {
+			repoJobs.setState(j, jobfailed)
+			reportStatus(j, gcl, statuserror, "The validator timed out")
+			go notifyResult(j, gcl, resdir, true)
+			sendWebhooks(j, resdir, statetimedout)
+		} else if err != nil {
+			repoJobs.setState(j, jobfailed)
+			reportStatus(j, gcl, statuserror, "The validator failed to run")
+			go notifyResult(j, gcl, resdir, true)
+			sendWebhooks(j, resdir, statefailed)
+		} else {
+			repoJobs.setState(j, jobdone)
+			reportResult(j, gcl, resdir)
+			go notifyResult(j, gcl, resdir, false)
+			sendWebhooks(j, resdir, statedone)
+		}
+		if !cancelled {
+			dropPrevious(resdir)
+		}
+		removeJob(j)
+		q.done(j)
+	}
+}
+
+// position returns the 1-based position of the job with the given results
+// path among the pending jobs. It returns 0 if no such job is pending.
+func (q *jobQueue) position(respath string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for idx, j := range q.pending {
+		if j.respath() == respath {
+			return idx + 1
+		}
+	}
+	return 0
+}
+
+// queuePosition returns the queue position of the job writing its results to
+// 'resdir'. It returns 0 if the job is not waiting in the queue.
+func queuePosition(resdir string) int {
+	srvcfg := config.Read()
+	resolved, err := filepath.EvalSymlinks(resdir)
+	if err != nil {
+		return 0
+	}
+	resroot, err := filepath.EvalSymlinks(srvcfg.Dir.Result)
+	if err != nil {
+		return 0
+	}
+	respath, err := filepath.Rel(resroot, resolved)
+	if err != nil || strings.HasPrefix(respath, "..") {
+		return 0
+	}
+	return getQueue().position(respath)
+}
+
+// jobClient returns the client a job was queued with or, for restored jobs,
+// a new client logged in the same way as for the original request.
+func jobClient(j *job) (*ginclient.Client, error) {
+	if j.gcl != nil {
+		return j.gcl, nil
+	}
+	gcl := ginclient.New(serveralias)
+	if j.Automatic {
+		ut, err := getTokenByRepo(j.Repopath)
+		if err != nil {
+			return nil, fmt.Errorf("no access token found: %s", err.Error())
+		}
+		gcl.UserToken = ut
+		return gcl, nil
+	}
+	srvcfg := config.Read()
+	err := gcl.Login(srvcfg.Settings.GINUser, srvcfg.Settings.GINPassword, srvcfg.Settings.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	return gcl, nil
+}
+
+// saveJob writes a job to the queue directory. Failures are logged; the job
+// still runs but is not restored after a restart.
+func saveJob(j *job) {
+	queuedir := config.Read().Dir.Queue
+	err := os.MkdirAll(queuedir, os.ModePerm)
+	if err != nil {
+		log.ShowWrite("[Error] creating queue directory %q: %s", queuedir, err.Error())
+		return
+	}
+	content, err := json.Marshal(j)
+	if err != nil {
+		log.ShowWrite("[Error] encoding job %s: %s", j.ID, err.Error())
+		return
+	}
+	err = ioutil.WriteFile(filepath.Join(queuedir, j.ID+".json"), content, 0600)
+	if err != nil {
+		log.ShowWrite("[Error] writing job %s: %s", j.ID, err.Error())
+	}
+}
+
+// removeJob deletes a finished job from the queue directory.
+func removeJob(j *job) {
+	queuedir := config.Read().Dir.Queue
+	err := os.Remove(filepath.Join(queuedir, j.ID+".json"))
+	if err != nil && !os.IsNotExist(err) {
+		log.ShowWrite("[Error] removing job %s: %s", j.ID, err.Error())
+	}
+}
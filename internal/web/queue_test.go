@@ -0,0 +1,72 @@
+package web
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/G-Node/gin-valid/internal/config"
+)
+
+func newTestQueue() *jobQueue {
+	q := &jobQueue{running: make(map[string]int)}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func TestQueueValidatorLimit(t *testing.T) {
+	srvcfg := config.Read()
+	original := srvcfg.Settings.ValidatorWorkers
+	srvcfg.Settings.ValidatorWorkers = map[string]int{"bids": 1}
+	config.Set(srvcfg)
+	defer func() {
+		srvcfg.Settings.ValidatorWorkers = original
+		config.Set(srvcfg)
+	}()
+
+	q := newTestQueue()
+	q.push(&job{ID: "1", Validator: "bids", Repopath: "a/b", Commit: "1"})
+	q.push(&job{ID: "2", Validator: "bids", Repopath: "a/b", Commit: "2"})
+	q.push(&job{ID: "3", Validator: "nix", Repopath: "a/b", Commit: "3"})
+
+	if j := q.next(); j.ID != "1" {
+		t.Fatalf("expected job 1, got %s", j.ID)
+	}
+	// bids is at its limit, the nix job must be taken next
+	if j := q.next(); j.ID != "3" {
+		t.Fatalf("expected job 3, got %s", j.ID)
+	}
+	if pos := q.position(filepath.Join("bids", "a/b", "2")); pos != 1 {
+		t.Fatalf("expected queue position 1, got %d", pos)
+	}
+	if pos := q.position(filepath.Join("bids", "a/b", "1")); pos != 0 {
+		t.Fatalf("running job should not have a queue position, got %d", pos)
+	}
+	q.done(&job{Validator: "bids"})
+	if j := q.next(); j.ID != "2" {
+		t.Fatalf("expected job 2, got %s", j.ID)
+	}
+}
+
+func TestQueueSaveRemoveJob(t *testing.T) {
+	srvcfg := config.Read()
+	original := srvcfg.Dir.Queue
+	srvcfg.Dir.Queue = "testing-queue"
+	config.Set(srvcfg)
+	defer func() {
+		os.RemoveAll(srvcfg.Dir.Queue)
+		srvcfg.Dir.Queue = original
+		config.Set(srvcfg)
+	}()
+
+	j := &job{ID: "testjob", Validator: "bids", Repopath: "a/b", Commit: "1"}
+	saveJob(j)
+	if _, err := os.Stat(filepath.Join(srvcfg.Dir.Queue, "testjob.json")); err != nil {
+		t.Fatalf("job was not stored: %s", err.Error())
+	}
+	removeJob(j)
+	if _, err := os.Stat(filepath.Join(srvcfg.Dir.Queue, "testjob.json")); !os.IsNotExist(err) {
+		t.Fatal("job was not removed")
+	}
+}
@@ -2,7 +2,6 @@ package web
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
 	"html/template"
 	"io/ioutil"
@@ -14,89 +13,19 @@ import (
 	"github.com/G-Node/gin-valid/internal/config"
 	"github.com/G-Node/gin-valid/internal/helpers"
 	"github.com/G-Node/gin-valid/internal/log"
+	"github.com/G-Node/gin-valid/internal/resources"
 	"github.com/G-Node/gin-valid/internal/resources/templates"
+	"github.com/G-Node/gin-valid/internal/validators"
 	"github.com/gorilla/mux"
 )
 
-// BidsResultStruct is the struct to parse a full BIDS validation json.
-type BidsResultStruct struct {
-	Issues struct {
-		Errors []struct {
-			Key      string `json:"key"`
-			Severity string `json:"severity"`
-			Reason   string `json:"reason"`
-			Files    []struct {
-				Key  string `json:"key"`
-				Code int    `json:"code"`
-				File struct {
-					string       `json:"name"`
-					Path         string `json:"path"`
-					RelativePath string `json:"relativePath"`
-				} `json:"file"`
-				Evidence  interface{} `json:"evidence"`
-				Line      interface{} `json:"line"`
-				Character interface{} `json:"character"`
-				Severity  string      `json:"severity"`
-				Reason    string      `json:"reason"`
-			} `json:"files"`
-			AdditionalFileCount int `json:"additionalFileCount"`
-			Code                int `json:"code"`
-		} `json:"errors"`
-		Warnings []struct {
-			Key      string `json:"key"`
-			Severity string `json:"severity"`
-			Reason   string `json:"reason"`
-			Files    []struct {
-				Key  string `json:"key"`
-				Code int    `json:"code"`
-				File struct {
-					Name         string `json:"name"`
-					Path         string `json:"path"`
-					RelativePath string `json:"relativePath"`
-					Stats        struct {
-						Dev         int       `json:"dev"`
-						Mode        int       `json:"mode"`
-						Nlink       int       `json:"nlink"`
-						UID         int       `json:"uid"`
-						Gid         int       `json:"gid"`
-						Rdev        int       `json:"rdev"`
-						Blksize     int       `json:"blksize"`
-						Ino         int       `json:"ino"`
-						Size        int       `json:"size"`
-						Blocks      int       `json:"blocks"`
-						AtimeMs     float64   `json:"atimeMs"`
-						MtimeMs     float64   `json:"mtimeMs"`
-						CtimeMs     float64   `json:"ctimeMs"`
-						BirthtimeMs float64   `json:"birthtimeMs"`
-						Atime       time.Time `json:"atime"`
-						Mtime       time.Time `json:"mtime"`
-						Ctime       time.Time `json:"ctime"`
-						Birthtime   time.Time `json:"birthtime"`
-					} `json:"stats"`
-				} `json:"file"`
-				Evidence  interface{} `json:"evidence"`
-				Line      interface{} `json:"line"`
-				Character interface{} `json:"character"`
-				Severity  string      `json:"severity"`
-				Reason    string      `json:"reason"`
-			} `json:"files"`
-			AdditionalFileCount int `json:"additionalFileCount"`
-			Code                int `json:"code"`
-		} `json:"warnings"`
-		Ignored []interface{} `json:"ignored"`
-	} `json:"issues"`
-	Summary struct {
-		Sessions   []interface{} `json:"sessions"`
-		Subjects   []string      `json:"subjects"`
-		Tasks      []string      `json:"tasks"`
-		Modalities []string      `json:"modalities"`
-		TotalFiles int           `json:"totalFiles"`
-		Size       int           `json:"size"`
-	} `json:"summary"`
-}
-
-// Results returns the results of a previously run validation.
+// Results returns the results of a previously run validation. Requests that
+// only accept JSON are served the same response as the JSON API.
 func Results(w http.ResponseWriter, r *http.Request) {
+	if wantsJSON(r) {
+		ResultsAPI(w, r)
+		return
+	}
 	vars := mux.Vars(r)
 	user := vars["user"]
 	repo := vars["repo"]
@@ -130,135 +59,55 @@ func Results(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if string(content) == progressmsg {
-		// validation in progress
-		renderInProgress(w, r, badge, strings.ToUpper(validator), user, repo)
-		return
-	}
-
-	switch validator {
-	case "bids":
-		renderBIDSResults(w, r, badge, content, user, repo)
-	case "nix":
-		renderNIXResults(w, r, badge, content, user, repo)
-	case "odml":
-		renderODMLResults(w, r, badge, content, user, repo)
-	default:
-		log.ShowWrite("[Error] Validator %q is supported but no render result function is set up", validator)
-		http.ServeContent(w, r, "unavailable", time.Now(), bytes.NewReader([]byte("404 Validator results missing")))
-	}
-	return
-}
-
-func renderInProgress(w http.ResponseWriter, r *http.Request, badge []byte, validator string, user, repo string) {
-	tmpl := template.New("layout")
-	tmpl, err := tmpl.Parse(templates.Layout)
-	if err != nil {
-		log.ShowWrite("[Error] '%s/%s' result: %s\n", user, repo, err.Error())
-		http.ServeContent(w, r, "unavailable", time.Now(), bytes.NewReader([]byte("500 Something went wrong...")))
-		return
-	}
-	tmpl, err = tmpl.Parse(templates.GenericResults)
-	if err != nil {
-		log.ShowWrite("[Error] '%s/%s' result: %s\n", user, repo, err.Error())
-		http.ServeContent(w, r, "unavailable", time.Now(), bytes.NewReader([]byte("500 Something went wrong...")))
-		return
-	}
-
-	// Parse results into html template and serve it
-	head := fmt.Sprintf("%s validation for %s/%s", validator, user, repo)
-	info := struct {
-		Badge   template.HTML
-		Header  string
-		Content string
-	}{template.HTML(badge), head, string(progressmsg)}
-
-	err = tmpl.ExecuteTemplate(w, "layout", info)
-	if err != nil {
-		log.ShowWrite("[Error] '%s/%s' result: %s\n", user, repo, err.Error())
-		http.ServeContent(w, r, "unavailable", time.Now(), bytes.NewReader([]byte("500 Something went wrong...")))
-		return
-	}
-}
-
-func renderBIDSResults(w http.ResponseWriter, r *http.Request, badge []byte, content []byte, user, repo string) {
-	// Parse results file
-	var resBIDS BidsResultStruct
-	err := json.Unmarshal(content, &resBIDS)
-	if err != nil {
-		log.ShowWrite("[Error] unmarshalling '%s/%s' result: %s\n", user, repo, err.Error())
-		http.ServeContent(w, r, "unavailable", time.Now(), bytes.NewReader([]byte("500 Something went wrong...")))
+	if string(content) == waitingmsg {
+		// validation waiting for annexed content
+		renderInProgress(w, r, badge, waitingmsg, strings.ToUpper(validator), user, repo)
 		return
 	}
 
-	// Parse html template
-	tmpl := template.New("layout")
-	tmpl, err = tmpl.Parse(templates.Layout)
-	if err != nil {
-		log.ShowWrite("[Error] '%s/%s' result: %s\n", user, repo, err.Error())
-		http.ServeContent(w, r, "unavailable", time.Now(), bytes.NewReader([]byte("500 Something went wrong...")))
-		return
-	}
-	tmpl, err = tmpl.Parse(templates.BidsResults)
-	if err != nil {
-		log.ShowWrite("[Error] '%s/%s' result: %s\n", user, repo, err.Error())
-		http.ServeContent(w, r, "unavailable", time.Now(), bytes.NewReader([]byte("500 Something went wrong...")))
+	if string(badge) == resources.FailureBadge || string(badge) == resources.TimeoutBadge {
+		// the validator failed to run or timed out; show the message
+		renderInProgress(w, r, badge, string(content), strings.ToUpper(validator), user, repo)
 		return
 	}
 
-	// Parse results into html template and serve it
-	head := fmt.Sprintf("BIDS validation for %s/%s", user, repo)
-	info := struct {
-		Badge  template.HTML
-		Header string
-		*BidsResultStruct
-	}{template.HTML(badge), head, &resBIDS}
-
-	err = tmpl.ExecuteTemplate(w, "layout", info)
-	if err != nil {
-		log.ShowWrite("[Error] '%s/%s' result: %s\n", user, repo, err.Error())
-		http.ServeContent(w, r, "unavailable", time.Now(), bytes.NewReader([]byte("500 Something went wrong...")))
+	if string(content) == progressmsg {
+		// validation in progress
+		msg := progressmsg
+		if pos := queuePosition(resdir); pos > 0 {
+			msg = fmt.Sprintf(queuedmsg, pos)
+		}
+		renderInProgress(w, r, badge, msg, strings.ToUpper(validator), user, repo)
 		return
 	}
-}
 
-func renderNIXResults(w http.ResponseWriter, r *http.Request, badge []byte, content []byte, user, repo string) {
-	// Parse results file
-	// Parse html template
-	tmpl := template.New("layout")
-	tmpl, err := tmpl.Parse(templates.Layout)
-	if err != nil {
-		log.ShowWrite("[Error] '%s/%s' result: %s\n", user, repo, err.Error())
-		http.ServeContent(w, r, "unavailable", time.Now(), bytes.NewReader([]byte("500 Something went wrong...")))
+	v, ok := validators.Get(validator)
+	if !ok {
+		log.ShowWrite("[Error] Validator %q is supported but not registered", validator)
+		http.ServeContent(w, r, "unavailable", time.Now(), bytes.NewReader([]byte("404 Validator results missing")))
 		return
 	}
-	tmpl, err = tmpl.Parse(templates.GenericResults)
+	results, err := v.Parse(content)
 	if err != nil {
-		log.ShowWrite("[Error] '%s/%s' result: %s\n", user, repo, err.Error())
+		log.ShowWrite("[Error] parsing '%s/%s' result: %s\n", user, repo, err.Error())
 		http.ServeContent(w, r, "unavailable", time.Now(), bytes.NewReader([]byte("500 Something went wrong...")))
 		return
 	}
-
-	// Parse results into html template and serve it
-	head := fmt.Sprintf("NIX validation for %s/%s", user, repo)
-	info := struct {
-		Badge   template.HTML
-		Header  string
-		Content string
-	}{template.HTML(badge), head, string(content)}
-
-	err = tmpl.ExecuteTemplate(w, "layout", info)
+	// Render to a buffer first so a failing template does not leave a
+	// partially written page behind.
+	var page bytes.Buffer
+	rep := validators.LoadReport(v, resdir, results)
+	err = v.Render(&page, badge, rep, results, user, repo, requestCSRFToken(r))
 	if err != nil {
 		log.ShowWrite("[Error] '%s/%s' result: %s\n", user, repo, err.Error())
 		http.ServeContent(w, r, "unavailable", time.Now(), bytes.NewReader([]byte("500 Something went wrong...")))
 		return
 	}
+	w.Write(page.Bytes())
 }
 
-func renderODMLResults(w http.ResponseWriter, r *http.Request, badge []byte, content []byte, user, repo string) {
-	// Parse results file
-	// Parse html template
-	tmpl := template.New("layout")
+func renderInProgress(w http.ResponseWriter, r *http.Request, badge []byte, msg string, validator string, user, repo string) {
+	tmpl := layoutTemplate(r)
 	tmpl, err := tmpl.Parse(templates.Layout)
 	if err != nil {
 		log.ShowWrite("[Error] '%s/%s' result: %s\n", user, repo, err.Error())
@@ -273,12 +122,12 @@ func renderODMLResults(w http.ResponseWriter, r *http.Request, badge []byte, con
 	}
 
 	// Parse results into html template and serve it
-	head := fmt.Sprintf("odML validation for %s/%s", user, repo)
+	head := fmt.Sprintf("%s validation for %s/%s", validator, user, repo)
 	info := struct {
 		Badge   template.HTML
 		Header  string
 		Content string
-	}{template.HTML(badge), head, string(content)}
+	}{template.HTML(badge), head, msg}
 
 	err = tmpl.ExecuteTemplate(w, "layout", info)
 	if err != nil {
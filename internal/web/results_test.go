@@ -113,6 +113,25 @@ func TestResultsInProgress(t *testing.T) {
 	router.ServeHTTP(w, r)
 	os.RemoveAll(filepath.Join(srvcfg.Dir.Result, "bids", username, reponame, id))
 }
+func TestResultsWaitingForData(t *testing.T) {
+	id := "1"
+	router := mux.NewRouter()
+	router.HandleFunc("/results/{validator}/{user}/{repo}/{id}", Results).Methods("GET")
+	r, _ := http.NewRequest("GET", filepath.Join("/results/bids", username, "/", reponame, "/", id), nil)
+	w := httptest.NewRecorder()
+	srvcfg := config.Read()
+	resdir := filepath.Join(srvcfg.Dir.Result, "bids", username, reponame, id)
+	os.MkdirAll(resdir, 0755)
+	defer os.RemoveAll(resdir)
+	writeJobMessage(resdir, waitingmsg)
+	if hasResults(resdir) {
+		t.Fatal("results waiting for data reported as complete")
+	}
+	router.ServeHTTP(w, r)
+	if !bytes.Contains(w.Body.Bytes(), []byte("Waiting for data")) {
+		t.Fatal("results page does not show that the job is waiting for data")
+	}
+}
 func TestResultsSomeResults(t *testing.T) {
 	id := "1"
 	content := "wtf"
@@ -0,0 +1,275 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/log"
+	"github.com/G-Node/gin-valid/internal/resources/templates"
+	"github.com/G-Node/gin-valid/internal/store"
+	"github.com/gorilla/mux"
+)
+
+// sessionTouchInterval limits how often the last seen time of a session is
+// stored, so not every request writes to the token store.
+const sessionTouchInterval = time.Minute
+
+// csrfField is the name of the form value that carries the CSRF token with
+// every form submitted from a session.
+const csrfField = "csrf_token"
+
+// sessionInfo is a session as it is listed on the sessions page. Sessions are
+// identified by a hash of their ID, which must not appear in pages.
+type sessionInfo struct {
+	Key      string
+	Created  time.Time
+	LastSeen time.Time
+	Expires  time.Time
+	Current  bool
+}
+
+// sessionKey returns the hash identifying a session on the sessions page.
+func sessionKey(sessionid string) string {
+	hash := sha256.Sum256([]byte(sessionid))
+	return hex.EncodeToString(hash[:16])
+}
+
+// sessionExpiry returns the time a session expires, which is
+// config.Settings.SessionLifetime hours after its creation or, if set,
+// config.Settings.SessionIdle hours after it was last seen, whichever comes
+// first. Sessions without recorded timestamps are expired.
+func sessionExpiry(s store.Session) time.Time {
+	settings := config.Read().Settings
+	expiry := s.Created.Add(time.Duration(settings.SessionLifetime) * time.Hour)
+	if settings.SessionIdle > 0 {
+		if idle := s.LastSeen.Add(time.Duration(settings.SessionIdle) * time.Hour); idle.Before(expiry) {
+			expiry = idle
+		}
+	}
+	return expiry
+}
+
+// secureCookies returns whether the service is served over https, according
+// to config.Settings.RootURL, so cookies must only be sent over https.
+func secureCookies() bool {
+	rooturl, err := url.Parse(config.Read().Settings.RootURL)
+	return err == nil && rooturl.Scheme == "https"
+}
+
+// sessionCookie returns the session cookie holding a session ID. Scripts
+// cannot read it and browsers only send it with requests from other sites when
+// following a link.
+func sessionCookie(sessionid string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     config.Read().Settings.CookieName,
+		Value:    sessionid,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   secureCookies(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// clearSessionCookie tells the browser to delete the session cookie.
+func clearSessionCookie(w http.ResponseWriter) {
+	cookie := sessionCookie("", time.Unix(0, 0))
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+}
+
+// csrfToken returns the token that forms submitted from a session must carry.
+// It is derived from the secret session ID, so other sites cannot forge it
+// and it needs not be stored.
+func csrfToken(sessionid string) string {
+	return hookSignature([]byte(csrfField), sessionid)
+}
+
+// requestCSRFToken returns the CSRF token of the session of a request for
+// embedding in the forms of a page. Pages rendered without a request or
+// session get an empty token.
+func requestCSRFToken(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	cookie, err := r.Cookie(config.Read().Settings.CookieName)
+	if err != nil {
+		return ""
+	}
+	return csrfToken(cookie.Value)
+}
+
+// layoutTemplate returns a new template for a page of a request, which
+// provides the CSRF token of the request to the logout form of the layout.
+func layoutTemplate(r *http.Request) *template.Template {
+	return template.New("layout").Funcs(templates.LayoutFuncs(requestCSRFToken(r)))
+}
+
+// validCSRF returns whether a request submitted the CSRF token of a session.
+func validCSRF(r *http.Request, sessionid string) bool {
+	return hmac.Equal([]byte(r.FormValue(csrfField)), []byte(csrfToken(sessionid)))
+}
+
+// Logout removes the session of the request and its session cookie and
+// redirects to the login page. The logout form must carry the CSRF token of
+// the session, so other sites cannot log users out.
+func Logout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(config.Read().Settings.CookieName); err == nil {
+		if !validCSRF(r, cookie.Value) {
+			log.Write("[Error] logout with invalid CSRF token")
+			fail(w, http.StatusForbidden, "invalid form, please reload the page and try again")
+			return
+		}
+		if err := rmSession(cookie.Value); err != nil {
+			log.Write("[Info] logout of unknown session: %s", err.Error())
+		}
+	}
+	clearSessionCookie(w)
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
+
+// userSessions returns the sessions of a user, most recently seen first.
+func userSessions(username string) ([]store.Session, error) {
+	sessions, err := getSessions()
+	if err != nil {
+		return nil, err
+	}
+	own := make([]store.Session, 0, len(sessions))
+	for _, s := range sessions {
+		if s.Username == username {
+			own = append(own, s)
+		}
+	}
+	sort.Slice(own, func(i, j int) bool { return own[i].LastSeen.After(own[j].LastSeen) })
+	return own, nil
+}
+
+// Sessions renders the page listing the active sessions of the logged in
+// user, from which the user can revoke them.
+func Sessions(w http.ResponseWriter, r *http.Request) {
+	ut, err := getSessionOrRedirect(w, r)
+	if err != nil {
+		log.Write("[Info] %s: Redirecting to login", err.Error())
+		return
+	}
+	sessions, err := userSessions(ut.Username)
+	if err != nil {
+		log.ShowWrite("[Error] listing sessions of %q: %s", ut.Username, err.Error())
+		fail(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	tmpl := layoutTemplate(r)
+	tmpl, err = tmpl.Parse(templates.Layout)
+	if err != nil {
+		log.ShowWrite("[Error] failed to parse html layout page")
+		fail(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	tmpl, err = tmpl.Parse(templates.Sessions)
+	if err != nil {
+		log.ShowWrite("[Error] failed to render sessions page")
+		fail(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	current, _ := r.Cookie(config.Read().Settings.CookieName)
+	info := struct {
+		Username  string
+		Sessions  []sessionInfo
+		CSRFToken string
+	}{Username: ut.Username, CSRFToken: csrfToken(current.Value)}
+	for _, s := range sessions {
+		info.Sessions = append(info.Sessions, sessionInfo{
+			Key:      sessionKey(s.ID),
+			Created:  s.Created,
+			LastSeen: s.LastSeen,
+			Expires:  sessionExpiry(s),
+			Current:  s.ID == current.Value,
+		})
+	}
+	tmpl.ExecuteTemplate(w, "layout", info)
+}
+
+// RevokeSession removes the session of the logged in user identified by the
+// key in the URL. Revoking the session of the request logs the user out.
+func RevokeSession(w http.ResponseWriter, r *http.Request) {
+	ut, err := getSessionOrRedirect(w, r)
+	if err != nil {
+		log.Write("[Info] %s: Redirecting to login", err.Error())
+		return
+	}
+	sessions, err := userSessions(ut.Username)
+	if err != nil {
+		log.ShowWrite("[Error] listing sessions of %q: %s", ut.Username, err.Error())
+		fail(w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+	key := mux.Vars(r)["key"]
+	for _, s := range sessions {
+		if sessionKey(s.ID) != key {
+			continue
+		}
+		if err := rmSession(s.ID); err != nil {
+			log.ShowWrite("[Error] revoking session of %q: %s", ut.Username, err.Error())
+			fail(w, http.StatusInternalServerError, "failed to revoke session")
+			return
+		}
+		log.Write("[Info] %s revoked a session", ut.Username)
+		if current, _ := r.Cookie(config.Read().Settings.CookieName); current.Value == s.ID {
+			clearSessionCookie(w)
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "/sessions", http.StatusFound)
+		return
+	}
+	fail(w, http.StatusNotFound, "session not found")
+}
+
+// sweepSessions removes all expired sessions and returns their number.
+func sweepSessions() int {
+	sessions, err := getSessions()
+	if err != nil {
+		log.ShowWrite("[Error] listing sessions: %s", err.Error())
+		return 0
+	}
+	now := time.Now()
+	removed := 0
+	for _, s := range sessions {
+		if now.Before(sessionExpiry(s)) {
+			continue
+		}
+		if err := rmSession(s.ID); err != nil {
+			log.ShowWrite("[Error] removing expired session of %q: %s", s.Username, err.Error())
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		log.Write("[Info] removed %d expired sessions", removed)
+	}
+	return removed
+}
+
+// StartSessionSweeper removes expired sessions now and then every
+// config.Settings.SessionSweep minutes. A non-positive interval disables the
+// sweeper; expired sessions are still rejected when they are used.
+func StartSessionSweeper() {
+	interval := time.Duration(config.Read().Settings.SessionSweep) * time.Minute
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		sweepSessions()
+		for range time.Tick(interval) {
+			sweepSessions()
+		}
+	}()
+}
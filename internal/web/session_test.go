@@ -0,0 +1,203 @@
+package web
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/store"
+	"github.com/gorilla/mux"
+)
+
+func TestSessions(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir("", "sessions")
+	defer os.RemoveAll(tmpdir)
+	srvcfg := config.Read()
+	original := srvcfg
+	srvcfg.Dir.Tokens = filepath.Join(tmpdir, "tokens")
+	srvcfg.TokenStore.KeyFile = filepath.Join(tmpdir, "tokens.key")
+	srvcfg.Settings.SessionLifetime = 24
+	srvcfg.Settings.SessionIdle = 2
+	config.Set(srvcfg)
+	defer config.Set(original)
+	if err := LoadTokenKeys(); err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(srvcfg.Dir.Tokens, "by-sessionid"), 0755)
+	saveToken(store.UserToken{Username: "valid-user", Token: "token"})
+
+	ts, _ := tokenStore()
+	now := time.Now()
+	sessions := map[string]store.Session{
+		"current": {Created: now.Add(-time.Hour), LastSeen: now.Add(-time.Hour)},
+		"other":   {Created: now, LastSeen: now},
+		"old":     {Created: now.Add(-25 * time.Hour), LastSeen: now},
+		"idle":    {Created: now.Add(-3 * time.Hour), LastSeen: now.Add(-3 * time.Hour)},
+	}
+	for id, s := range sessions {
+		s.ID, s.Username = id, "valid-user"
+		if err := ts.SaveSession(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/sessions", Sessions).Methods("GET")
+	r.HandleFunc("/sessions/{key}/revoke", RevokeSession).Methods("POST")
+	r.HandleFunc("/logout", Logout).Methods("POST")
+	request := func(method, path, sessionid string) *httptest.ResponseRecorder {
+		form := url.Values{csrfField: {csrfToken(sessionid)}}
+		req, _ := http.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: srvcfg.Settings.CookieName, Value: sessionid})
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// expired sessions are rejected and removed
+	for _, id := range []string{"old", "idle"} {
+		if rr := request("GET", "/sessions", id); rr.Code != http.StatusFound || rr.Header().Get("Location") != "/login" {
+			t.Fatalf("expired session %q not redirected to login: %d", id, rr.Code)
+		}
+		if _, err := getSession(id); err == nil {
+			t.Fatalf("expired session %q not removed", id)
+		}
+	}
+
+	// the page lists the active sessions without their IDs and records
+	// the activity of the current session
+	rr := request("GET", "/sessions", "current")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("sessions page returned %d", rr.Code)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, sessionKey("other")) || strings.Contains(body, sessionKey("old")) || strings.Contains(body, `"current"`) {
+		t.Fatalf("unexpected sessions page: %s", body)
+	}
+	if s, _ := getSession("current"); now.Sub(s.LastSeen) > time.Minute {
+		t.Fatal("activity of the session not recorded")
+	}
+
+	if !strings.Contains(body, csrfToken("current")) {
+		t.Fatal("sessions page without CSRF token")
+	}
+
+	// forms must carry the CSRF token of the session
+	req, _ := http.NewRequest("POST", "/sessions/"+sessionKey("other")+"/revoke", nil)
+	req.AddCookie(&http.Cookie{Name: srvcfg.Settings.CookieName, Value: "current"})
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("form without CSRF token returned %d", rr.Code)
+	}
+	if _, err := getSession("other"); err != nil {
+		t.Fatal("session revoked without CSRF token")
+	}
+
+	// sessions can only be revoked by their user
+	ts.SaveToken(store.UserToken{Username: "other-user", Token: "token"})
+	ts.SaveSession(store.Session{ID: "foreign", Username: "other-user", Created: now, LastSeen: now})
+	if rr := request("POST", "/sessions/"+sessionKey("foreign")+"/revoke", "current"); rr.Code != http.StatusNotFound {
+		t.Fatalf("revoking a session of another user returned %d", rr.Code)
+	}
+	if rr := request("POST", "/sessions/"+sessionKey("other")+"/revoke", "current"); rr.Code != http.StatusFound || rr.Header().Get("Location") != "/sessions" {
+		t.Fatalf("revoking a session returned %d", rr.Code)
+	}
+	if _, err := getTokenBySession("other"); err == nil {
+		t.Fatal("revoked session still linked to a token")
+	}
+
+	// the logout form of the layout carries the CSRF token
+	if !strings.Contains(body, `action="/logout" method="post"`) {
+		t.Fatalf("sessions page without logout form: %s", body)
+	}
+
+	// logging out requires a form with the CSRF token of the session
+	if rr := request("GET", "/logout", "current"); rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("logout with GET returned %d", rr.Code)
+	}
+	req, _ = http.NewRequest("POST", "/logout", nil)
+	req.AddCookie(&http.Cookie{Name: srvcfg.Settings.CookieName, Value: "current"})
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("logout without CSRF token returned %d", rr.Code)
+	}
+	if _, err := getSession("current"); err != nil {
+		t.Fatal("session removed on logout without CSRF token")
+	}
+
+	// logging out removes the session and the cookie
+	rr = request("POST", "/logout", "current")
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != "/login" {
+		t.Fatalf("logout returned %d", rr.Code)
+	}
+	if cookie := rr.Result().Cookies(); len(cookie) != 1 || cookie[0].MaxAge >= 0 {
+		t.Fatalf("session cookie not removed on logout: %v", cookie)
+	}
+	if _, err := getSession("current"); err == nil {
+		t.Fatal("session not removed on logout")
+	}
+}
+
+func TestSweepSessions(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir("", "sessions")
+	defer os.RemoveAll(tmpdir)
+	srvcfg := config.Read()
+	original := srvcfg
+	srvcfg.Dir.Tokens = filepath.Join(tmpdir, "tokens")
+	srvcfg.TokenStore.KeyFile = filepath.Join(tmpdir, "tokens.key")
+	srvcfg.Settings.SessionLifetime = 24
+	srvcfg.Settings.SessionIdle = 0
+	config.Set(srvcfg)
+	defer config.Set(original)
+	if err := LoadTokenKeys(); err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(srvcfg.Dir.Tokens, "by-sessionid"), 0755)
+	saveToken(store.UserToken{Username: "valid-user", Token: "token"})
+
+	ts, _ := tokenStore()
+	now := time.Now()
+	ts.SaveSession(store.Session{ID: "active", Username: "valid-user", Created: now.Add(-23 * time.Hour), LastSeen: now.Add(-20 * time.Hour)})
+	ts.SaveSession(store.Session{ID: "expired", Username: "valid-user", Created: now.Add(-25 * time.Hour), LastSeen: now})
+	// sessions stored before their timestamps were recorded
+	ts.SaveSession(store.Session{ID: "unknown", Username: "valid-user"})
+
+	if removed := sweepSessions(); removed != 2 {
+		t.Fatalf("expected 2 expired sessions, removed %d", removed)
+	}
+	if left, _ := getSessions(); len(left) != 1 || left[0].ID != "active" {
+		t.Fatalf("unexpected sessions after sweep %v", left)
+	}
+}
+
+func TestSessionCookie(t *testing.T) {
+	srvcfg := config.Read()
+	original := srvcfg
+	defer config.Set(original)
+
+	srvcfg.Settings.RootURL = "http://localhost:3033"
+	config.Set(srvcfg)
+	cookie := sessionCookie("id", cookieExp())
+	if cookie.Secure || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode || cookie.Path != "/" {
+		t.Fatalf("unexpected cookie attributes over http %+v", cookie)
+	}
+	srvcfg.Settings.RootURL = "https://valid.gin.g-node.org"
+	config.Set(srvcfg)
+	if cookie := sessionCookie("id", cookieExp()); !cookie.Secure {
+		t.Fatal("cookie not secure over https")
+	}
+
+	if csrfToken("a") == csrfToken("b") || strings.Contains(csrfToken("session"), "session") {
+		t.Fatal("CSRF tokens do not depend on the session")
+	}
+}
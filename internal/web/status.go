@@ -2,9 +2,9 @@ package web
 
 import (
 	"bytes"
-	"io/ioutil"
+	"fmt"
 	"net/http"
-	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/G-Node/gin-valid/internal/config"
@@ -14,27 +14,159 @@ import (
 	"github.com/gorilla/mux"
 )
 
-// Status returns the status of the latest BIDS validation for
-// a provided gin user repository.
+// combinedbadge is the validator name of the badge summarising all enabled
+// validators of a repository.
+const combinedbadge = "all"
+
+// statusBadge is the content of a status badge. It is also the response of
+// the shields.io endpoint API, which requires SchemaVersion to be 1.
+type statusBadge struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
+}
+
+// Status returns a badge with the status of the latest validation of a
+// provided gin user repository. The validator "all" returns a badge combining
+// the status of all enabled validators. The optional 'label' query parameter
+// replaces the label of the badge and 'style' selects either the "flat" or
+// the "flat-square" style. Requests for a single validator that only accept
+// JSON are served the same response as the JSON API.
 func Status(w http.ResponseWriter, r *http.Request) {
-	validator := mux.Vars(r)["validator"]
-	if !helpers.SupportedValidator(validator) {
-		log.Write("[Error] unsupported validator '%s'\n", validator)
+	vars := mux.Vars(r)
+	if strings.ToLower(vars["validator"]) != combinedbadge && wantsJSON(r) {
+		StatusAPI(w, r)
+		return
+	}
+	badge, err := readBadge(vars)
+	if err != nil {
+		log.Write("[Error] %s\n", err.Error())
 		http.ServeContent(w, r, "unavailable", time.Now(), bytes.NewReader([]byte("404 Nothing to see here...")))
 		return
 	}
-	user := mux.Vars(r)["user"]
-	repo := mux.Vars(r)["repo"]
-	log.Write("[Info] '%s' status for repo '%s/%s'\n", validator, user, repo)
-
-	srvcfg := config.Read()
+	if label := r.URL.Query().Get("label"); label != "" {
+		badge.Label = label
+	}
+	svg := resources.Badge(badge.Label, badge.Message, badge.Color, r.URL.Query().Get("style"))
+	// badges are embedded in pages that should always show the current status
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "status.svg", time.Now(), strings.NewReader(svg))
+}
 
-	fp := filepath.Join(srvcfg.Dir.Result, "bids", user, repo, srvcfg.Label.ResultsFolder, srvcfg.Label.ResultsBadge)
-	content, err := ioutil.ReadFile(fp)
+// ShieldsAPI returns the status of the latest validation of a repository in
+// the format of the shields.io endpoint badge, see
+// https://shields.io/endpoint. Like Status, it supports the validator "all"
+// and the 'label' query parameter.
+func ShieldsAPI(w http.ResponseWriter, r *http.Request) {
+	badge, err := readBadge(mux.Vars(r))
 	if err != nil {
-		log.Write("[Error] serving '%s/%s' status: %s\n", user, repo, err.Error())
-		http.ServeContent(w, r, "unavailable.svg", time.Now(), bytes.NewReader([]byte(resources.UnavailableBadge)))
+		failJSON(w, http.StatusNotFound, err.Error())
 		return
 	}
-	http.ServeContent(w, r, srvcfg.Label.ResultsBadge, time.Now(), bytes.NewReader(content))
+	if label := r.URL.Query().Get("label"); label != "" {
+		badge.Label = label
+	}
+	// shields.io expects hex colours without the leading '#'
+	badge.Color = strings.TrimPrefix(badge.Color, "#")
+	w.Header().Set("Cache-Control", "no-cache")
+	writeJSON(w, http.StatusOK, badge)
+}
+
+// readBadge returns the badge for the validator, user and repo route
+// variables. It fails only if the validator is not supported; repositories
+// without results get an "unavailable" badge.
+func readBadge(vars map[string]string) (*statusBadge, error) {
+	validator := strings.ToLower(vars["validator"])
+	user := vars["user"]
+	repo := vars["repo"]
+	log.Write("[Info] '%s' status for repo '%s/%s'\n", validator, user, repo)
+	if validator == combinedbadge {
+		return combinedBadge(user, repo), nil
+	}
+	if !helpers.SupportedValidator(validator) {
+		return nil, fmt.Errorf("unsupported validator '%s'", validator)
+	}
+	badge := &statusBadge{SchemaVersion: 1, Label: validator}
+	res, _, err := readResults(vars)
+	if err != nil {
+		badge.Message, badge.Color = "unavailable", resources.BadgeInactive
+		return badge, nil
+	}
+	badge.Message, badge.Color = resultsBadge(res)
+	return badge, nil
+}
+
+// resultsBadge returns the message and colour of the badge for validation
+// results.
+func resultsBadge(res *apiResults) (string, string) {
+	switch res.State {
+	case statequeued, staterunning:
+		return res.State, resources.BadgeProgressing
+	case statewaiting:
+		return "waiting for data", resources.BadgeProgressing
+	case statefailed:
+		return "failed to run", resources.BadgeInactive
+	case statetimedout:
+		return "timed out", resources.BadgeTimeout
+	}
+	switch {
+	case res.Errors > 0:
+		return countIssues(res.Errors, "error"), resources.BadgeError
+	case res.Warnings > 0:
+		return countIssues(res.Warnings, "warning"), resources.BadgeWarning
+	default:
+		return "passed", resources.BadgeSuccess
+	}
+}
+
+// combinedBadge returns a badge summarising the latest results of all
+// enabled validators of a repository. Errors take precedence over validators
+// that failed to run, which take precedence over running validations and
+// warnings. Validators that timed out count as having failed to run.
+// Validators that never ran on the repository are ignored.
+func combinedBadge(user, repo string) *statusBadge {
+	badge := &statusBadge{SchemaVersion: 1, Label: "validation"}
+	var found, failed, inprogress bool
+	var errors, warnings int
+	for _, validator := range config.Read().Settings.Validators {
+		res, _, err := readResults(map[string]string{"validator": validator, "user": user, "repo": repo})
+		if err != nil {
+			continue
+		}
+		found = true
+		switch res.State {
+		case statedone:
+			errors += res.Errors
+			warnings += res.Warnings
+		case statefailed, statetimedout:
+			failed = true
+		default:
+			inprogress = true
+		}
+	}
+	switch {
+	case !found:
+		badge.Message, badge.Color = "unavailable", resources.BadgeInactive
+	case errors > 0:
+		badge.Message, badge.Color = countIssues(errors, "error"), resources.BadgeError
+	case failed:
+		badge.Message, badge.Color = "failed to run", resources.BadgeInactive
+	case inprogress:
+		badge.Message, badge.Color = "running", resources.BadgeProgressing
+	case warnings > 0:
+		badge.Message, badge.Color = countIssues(warnings, "warning"), resources.BadgeWarning
+	default:
+		badge.Message, badge.Color = "passed", resources.BadgeSuccess
+	}
+	return badge
+}
+
+// countIssues returns the number of issues with the pluralised kind, e.g.
+// "1 error" or "3 errors".
+func countIssues(count int, kind string) string {
+	if count == 1 {
+		return fmt.Sprintf("1 %s", kind)
+	}
+	return fmt.Sprintf("%d %ss", count, kind)
 }
@@ -5,13 +5,19 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
-	"github.com/G-Node/gin-valid/internal/config"
-	"github.com/gorilla/mux"
+	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/resources"
+	"github.com/G-Node/gin-valid/internal/validators"
+	"github.com/gorilla/mux"
 )
 
 func TestStatusOK(t *testing.T) {
@@ -56,3 +62,69 @@ func TestStatusUnsupportedValidator(t *testing.T) {
 	r.Header.Add("X-Gogs-Signature", hex.EncodeToString(sig.Sum(nil)))
 	router.ServeHTTP(w, r)
 }
+
+func TestStatusBadges(t *testing.T) {
+	srvcfg := config.Read()
+	repodir := filepath.Join(srvcfg.Dir.Result, "nix", username, "badge-testing")
+	defer os.RemoveAll(repodir)
+	resdir := filepath.Join(repodir, "abc123")
+	os.MkdirAll(resdir, 0755)
+	rep := validators.NewReport("nix", []validators.Issue{
+		{Severity: validators.SeverityError, Message: "broken"},
+		{Severity: validators.SeverityError, Message: "also broken"},
+	})
+	validators.WriteReport(resdir, rep)
+	ioutil.WriteFile(filepath.Join(resdir, srvcfg.Label.ResultsFile), []byte(""), 0644)
+	ioutil.WriteFile(filepath.Join(resdir, srvcfg.Label.ResultsBadge), []byte(rep.Badge()), 0644)
+	os.Symlink("abc123", filepath.Join(repodir, srvcfg.Label.ResultsFolder))
+
+	bidsdir := filepath.Join(srvcfg.Dir.Result, "bids", username, "badge-testing")
+	defer os.RemoveAll(bidsdir)
+	os.MkdirAll(filepath.Join(bidsdir, "def456"), 0755)
+	ioutil.WriteFile(filepath.Join(bidsdir, "def456", srvcfg.Label.ResultsFile), []byte(progressmsg), 0644)
+	os.Symlink("def456", filepath.Join(bidsdir, srvcfg.Label.ResultsFolder))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/status/{validator}/{user}/{repo}", Status).Methods("GET")
+	router.HandleFunc("/api/v1/shields/{validator}/{user}/{repo}", ShieldsAPI).Methods("GET")
+	badges := []struct {
+		path     string
+		expected []string
+	}{
+		{"/status/nix/" + username + "/badge-testing", []string{">nix<", ">2 errors<", resources.BadgeError, `rx="4"`}},
+		{"/status/nix/" + username + "/badge-testing?label=NIX&style=flat-square", []string{">NIX<", ">2 errors<", `rx="0"`}},
+		{"/status/bids/" + username + "/badge-testing", []string{">bids<", ">running<", resources.BadgeProgressing}},
+		{"/status/odml/" + username + "/badge-testing", []string{">odml<", ">unavailable<", resources.BadgeInactive}},
+		{"/status/all/" + username + "/badge-testing", []string{">validation<", ">2 errors<", resources.BadgeError}},
+	}
+	for _, badge := range badges {
+		r, _ := http.NewRequest("GET", badge.path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/svg+xml" || w.Header().Get("Cache-Control") != "no-cache" {
+			t.Fatalf("%s: unexpected response %d %v", badge.path, w.Code, w.Header())
+		}
+		for _, exp := range badge.expected {
+			if !strings.Contains(w.Body.String(), exp) {
+				t.Fatalf("%s: badge does not contain %q: %s", badge.path, exp, w.Body.String())
+			}
+		}
+	}
+
+	r, _ := http.NewRequest("GET", "/api/v1/shields/all/"+username+"/badge-testing", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	shield := statusBadge{}
+	if err := json.Unmarshal(w.Body.Bytes(), &shield); err != nil {
+		t.Fatalf("invalid JSON response: %s", err.Error())
+	}
+	if shield != (statusBadge{1, "validation", "2 errors", strings.TrimPrefix(resources.BadgeError, "#")}) {
+		t.Fatalf("unexpected shields response %+v", shield)
+	}
+	r, _ = http.NewRequest("GET", "/api/v1/shields/whatever/"+username+"/badge-testing", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unsupported validator returned %d", w.Code)
+	}
+}
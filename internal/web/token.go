@@ -1,109 +1,259 @@
 package web
 
 import (
-	"encoding/base32"
-	"encoding/gob"
-	"os"
-	"path/filepath"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
 
 	gweb "github.com/G-Node/gin-cli/web"
 	"github.com/G-Node/gin-valid/internal/config"
 	"github.com/G-Node/gin-valid/internal/log"
+	"github.com/G-Node/gin-valid/internal/store"
 )
 
-// saveToken writes a token to disk using the username as filename.
-// The location is defined by config.Dir.Tokens.
-func saveToken(ut gweb.UserToken) error {
-	cfg := config.Read()
-	tokendir, _ := filepath.Abs(cfg.Dir.Tokens)
-	filename := filepath.Join(tokendir, ut.Username)
-	tokenfile, err := os.Create(filename)
-	defer tokenfile.Close()
+// tokenKeys holds the keyrings loaded by LoadTokenKeys by key file. Keys are
+// only read once, so a key file that disappears while the server runs is
+// never replaced by a new key that cannot decrypt the stored tokens.
+var tokenKeys = struct {
+	sync.Mutex
+	keyrings map[string]*store.Keyring
+}{keyrings: make(map[string]*store.Keyring)}
+
+// LoadTokenKeys loads the token keys configured in config.TokenStore,
+// generating the current key if it does not exist. It must be called before
+// the token store is used.
+func LoadTokenKeys() error {
+	tscfg := config.Read().TokenStore
+	if tscfg.KeyFile == "" {
+		return nil
+	}
+	keys, err := store.LoadKeyring(tscfg.KeyFile, tscfg.OldKeyFiles, true)
 	if err != nil {
 		return err
 	}
-	encoder := gob.NewEncoder(tokenfile)
-	return encoder.Encode(ut)
+	tokenKeys.Lock()
+	defer tokenKeys.Unlock()
+	tokenKeys.keyrings[tscfg.KeyFile] = keys
+	return nil
 }
 
-// getTokenByUsername reads a token from disk using the username as filename.
-// The location is defined by config.Dir.Tokens.
-func getTokenByUsername(username string) (gweb.UserToken, error) {
-	cfg := config.Read()
-	tokendir, _ := filepath.Abs(cfg.Dir.Tokens)
-	filename := filepath.Join(tokendir, username)
-	return loadToken(filename)
+// tokenStore returns the token store selected in the server configuration
+// with the keys loaded by LoadTokenKeys.
+func tokenStore() (store.TokenStore, error) {
+	return storeFor(config.Read())
+}
+
+// storeFor returns the token store selected in 'srvcfg' with the keys loaded
+// by LoadTokenKeys.
+func storeFor(srvcfg config.ServerCfg) (store.TokenStore, error) {
+	keys, err := loadedKeys(srvcfg)
+	if err != nil {
+		return nil, err
+	}
+	return store.New(srvcfg, keys)
+}
+
+// loadedKeys returns the keyring of the token key configured in 'srvcfg',
+// which LoadTokenKeys must have loaded. Without a configured key it returns
+// nil, which leaves secrets unencrypted.
+func loadedKeys(srvcfg config.ServerCfg) (*store.Keyring, error) {
+	keyfile := srvcfg.TokenStore.KeyFile
+	if keyfile == "" {
+		return nil, nil
+	}
+	tokenKeys.Lock()
+	keys := tokenKeys.keyrings[keyfile]
+	tokenKeys.Unlock()
+	if keys == nil {
+		return nil, fmt.Errorf("token key %q not loaded", keyfile)
+	}
+	return keys, nil
 }
 
-// loadToken loads a token from the provided path
-func loadToken(path string) (gweb.UserToken, error) {
-	ut := gweb.UserToken{}
-	tokenfile, err := os.Open(path)
+// saveToken stores a user's token in the token store.
+func saveToken(ut store.UserToken) error {
+	ts, err := tokenStore()
 	if err != nil {
-		log.Write("[Error] Failed to load token from %s", path)
-		return ut, err
+		return err
 	}
-	defer tokenfile.Close()
+	return ts.SaveToken(ut)
+}
 
-	decoder := gob.NewDecoder(tokenfile)
-	err = decoder.Decode(&ut)
-	return ut, err
+// getTokenByUsername reads a user's token from the token store, refreshing
+// it if it has expired.
+func getTokenByUsername(username string) (gweb.UserToken, error) {
+	ts, err := tokenStore()
+	if err != nil {
+		return gweb.UserToken{}, err
+	}
+	ut, err := ts.Token(username)
+	if err != nil {
+		return gweb.UserToken{}, err
+	}
+	return freshToken(ts, ut)
 }
 
-// linkToSession links a sessionID to a user's token.
+// linkToSession links a new sessionID to a user's token, recording the
+// session as created now.
 func linkToSession(username string, sessionid string) error {
-	cfg := config.Read()
-	tokendir, _ := filepath.Abs(cfg.Dir.Tokens)
-	utfile := filepath.Join(tokendir, username)
-	sidfile := filepath.Join(tokendir, "by-sessionid", b32(sessionid))
-	// if it's already linked, this will fail; remove existing and relink
-	// this will also fix outdated tokens
-	os.Remove(sidfile)
-	return os.Symlink(utfile, sidfile)
+	ts, err := tokenStore()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	return ts.SaveSession(store.Session{ID: sessionid, Username: username, Created: now, LastSeen: now})
+}
+
+// getSession loads the record of a session.
+func getSession(sessionid string) (store.Session, error) {
+	ts, err := tokenStore()
+	if err != nil {
+		return store.Session{}, err
+	}
+	return ts.Session(sessionid)
+}
+
+// touchSession records that a session was seen now.
+func touchSession(s store.Session) error {
+	ts, err := tokenStore()
+	if err != nil {
+		return err
+	}
+	s.LastSeen = time.Now()
+	return ts.SaveSession(s)
+}
+
+// rmSession deletes a session and its link to the user's token.
+func rmSession(sessionid string) error {
+	ts, err := tokenStore()
+	if err != nil {
+		return err
+	}
+	return ts.RemoveSession(sessionid)
+}
+
+// getSessions loads the records of all sessions.
+func getSessions() ([]store.Session, error) {
+	ts, err := tokenStore()
+	if err != nil {
+		return nil, err
+	}
+	return ts.Sessions()
 }
 
 // getTokenBySession loads a user's access token using the session ID found in
-// the user's cookie store.
+// the user's cookie store, refreshing it if it has expired.
 func getTokenBySession(sessionid string) (gweb.UserToken, error) {
-	cfg := config.Read()
-	tokendir, _ := filepath.Abs(cfg.Dir.Tokens)
-	filename := filepath.Join(tokendir, "by-sessionid", b32(sessionid))
-	return loadToken(filename)
+	ts, err := tokenStore()
+	if err != nil {
+		return gweb.UserToken{}, err
+	}
+	ut, err := ts.SessionToken(sessionid)
+	if err != nil {
+		return gweb.UserToken{}, err
+	}
+	return freshToken(ts, ut)
 }
 
 // linkToRepo links a repository name to a user's token.
 // This token will be used for cloning a repository to run a validator when a
 // web hook is triggered.
 func linkToRepo(username string, repopath string) error {
-	cfg := config.Read()
-	tokendir, _ := filepath.Abs(cfg.Dir.Tokens)
-	utfile := filepath.Join(tokendir, username)
-	sidfile := filepath.Join(tokendir, "by-repo", b32(repopath))
-	// if it's already linked, this will fail; remove existing and relink
-	// this will also fix outdated tokens
-	os.Remove(sidfile)
-	return os.Symlink(utfile, sidfile)
+	ts, err := tokenStore()
+	if err != nil {
+		return err
+	}
+	return ts.LinkRepo(username, repopath)
 }
 
-// getTokenByRepo loads a user's access token using a repository path.
+// getTokenByRepo loads a user's access token using a repository path,
+// refreshing it if it has expired.
 func getTokenByRepo(repopath string) (gweb.UserToken, error) {
-	cfg := config.Read()
-	tokendir, _ := filepath.Abs(cfg.Dir.Tokens)
-	filename := filepath.Join(tokendir, "by-repo", b32(repopath))
-	return loadToken(filename)
+	ts, err := tokenStore()
+	if err != nil {
+		return gweb.UserToken{}, err
+	}
+	ut, err := ts.RepoToken(repopath)
+	if err != nil {
+		return gweb.UserToken{}, err
+	}
+	return freshToken(ts, ut)
 }
 
 // rmTokenRepoLink deletes a repository -> token link, removing our ability to
 // clone the repository.
 func rmTokenRepoLink(repopath string) error {
-	cfg := config.Read()
-	tokendir, _ := filepath.Abs(cfg.Dir.Tokens)
-	filename := filepath.Join(tokendir, "by-repo", b32(repopath))
-	return os.Remove(filename)
+	ts, err := tokenStore()
+	if err != nil {
+		return err
+	}
+	return ts.UnlinkRepo(repopath)
+}
+
+// hookedRepos returns the sorted paths of all repositories linked to a token,
+// which are the repositories with active validation hooks.
+func hookedRepos() ([]string, error) {
+	ts, err := tokenStore()
+	if err != nil {
+		return nil, err
+	}
+	links, err := ts.Repos()
+	if err != nil {
+		return nil, err
+	}
+	repos := make([]string, 0, len(links))
+	for repopath := range links {
+		repos = append(repos, repopath)
+	}
+	sort.Strings(repos)
+	return repos, nil
 }
 
-// b32 encodes a string to base 32. Use this to make strings such as IDs or
-// repopaths filename friendly.
-func b32(s string) string {
-	return base32.StdEncoding.EncodeToString([]byte(s))
+// MigrateTokens copies the tokens and their links from the token files in
+// config.Dir.Tokens to the configured token store.
+func MigrateTokens() error {
+	if err := LoadTokenKeys(); err != nil {
+		return err
+	}
+	to, err := tokenStore()
+	if err != nil {
+		return err
+	}
+	srvcfg := config.Read()
+	srvcfg.TokenStore.Backend = "files"
+	from, err := storeFor(srvcfg)
+	if err != nil {
+		return err
+	}
+	if _, ok := to.(store.Files); ok {
+		return fmt.Errorf("the configured token store already uses the token files")
+	}
+	tokens, sessions, repos, err := store.Migrate(from, to)
+	log.ShowWrite("[Info] migrated %d tokens, %d sessions and %d repositories", tokens, sessions, repos)
+	return err
+}
+
+// ReencryptTokens stores all tokens of the configured token store and all
+// webhook secrets again, encrypting them with the current token key, e.g.
+// after the key was rotated.
+func ReencryptTokens() error {
+	if err := LoadTokenKeys(); err != nil {
+		return err
+	}
+	ts, err := tokenStore()
+	if err != nil {
+		return err
+	}
+	if config.Read().TokenStore.KeyFile == "" {
+		return fmt.Errorf("no token key configured")
+	}
+	count, err := store.Reencrypt(ts)
+	log.ShowWrite("[Info] re-encrypted %d tokens", count)
+	if err != nil {
+		return err
+	}
+	count, err = reencryptWebhooks()
+	log.ShowWrite("[Info] re-encrypted the webhook secrets of %d repositories", count)
+	return err
 }
@@ -5,11 +5,16 @@ import (
 	//"crypto/hmac"
 	//"crypto/sha256"
 	//"encoding/hex"
-	//"github.com/G-Node/gin-valid/internal/config"
 	//"github.com/gorilla/mux"
 	//"net/http"
 	//"net/http/httptest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/store"
 )
 
 func TestTokenLinkToSessionWrong(t *testing.T) {
@@ -24,3 +29,38 @@ func TestTokenRmTokenRepoLinkWrong(t *testing.T) {
 func TestTokenGetTokenByUsernameWrong(t *testing.T) {
 	getTokenByUsername("wtf")
 }
+
+func TestTokenKeyLoadedOnce(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir("", "tokenkey")
+	defer os.RemoveAll(tmpdir)
+	srvcfg := config.Read()
+	original := srvcfg
+	srvcfg.Dir.Tokens = filepath.Join(tmpdir, "tokens")
+	srvcfg.TokenStore.KeyFile = filepath.Join(tmpdir, "tokens.key")
+	config.Set(srvcfg)
+	defer config.Set(original)
+
+	// the store is unusable until the key is loaded and no key is generated
+	if err := saveToken(store.UserToken{Username: "valid-user", Token: "token"}); err == nil {
+		t.Fatal("token saved without a loaded key")
+	}
+	if _, err := os.Stat(srvcfg.TokenStore.KeyFile); !os.IsNotExist(err) {
+		t.Fatal("token key generated outside of LoadTokenKeys")
+	}
+	if err := LoadTokenKeys(); err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(srvcfg.Dir.Tokens, 0755)
+	if err := saveToken(store.UserToken{Username: "valid-user", Token: "token"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the key is not read again, so a lost key file does not lose the tokens
+	os.Remove(srvcfg.TokenStore.KeyFile)
+	if ut, err := getTokenByUsername("valid-user"); err != nil || ut.Token != "token" {
+		t.Fatalf("token unreadable after the key file was removed %+v: %v", ut, err)
+	}
+	if _, err := os.Stat(srvcfg.TokenStore.KeyFile); !os.IsNotExist(err) {
+		t.Fatal("removed token key was generated again")
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"html/template"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -26,6 +25,7 @@ import (
 	"github.com/G-Node/gin-valid/internal/helpers"
 	"github.com/G-Node/gin-valid/internal/log"
 	"github.com/G-Node/gin-valid/internal/resources/templates"
+	"github.com/G-Node/gin-valid/internal/store"
 	gogs "github.com/gogits/go-gogs-client"
 	"github.com/gorilla/mux"
 )
@@ -51,8 +51,10 @@ const (
 	hooknone
 )
 
+// cookieExp returns the expiry of the cookie of a new session, which is the
+// end of its lifetime.
 func cookieExp() time.Time {
-	return time.Now().Add(7 * 24 * time.Hour)
+	return time.Now().Add(time.Duration(config.Read().Settings.SessionLifetime) * time.Hour)
 }
 
 func makeSessionKey(gcl *ginclient.Client, keyname string) error {
@@ -136,7 +138,13 @@ func doLogin(username, password string) (string, error) {
 		log.Write("Login successful. Username: %s", username)
 	}
 
-	err = saveToken(gincl.UserToken)
+	return newSession(store.UserToken{Username: gincl.UserToken.Username, Token: gincl.UserToken.Token})
+}
+
+// newSession stores a user's token and links it to a new session ID, which
+// it returns.
+func newSession(ut store.UserToken) (string, error) {
+	err := saveToken(ut)
 	if err != nil {
 		return "", err
 	}
@@ -147,14 +155,14 @@ func doLogin(username, password string) (string, error) {
 	}
 
 	// link session ID to usertoken
-	err = linkToSession(username, sessionid)
+	err = linkToSession(ut.Username, sessionid)
 	return sessionid, err
 }
 
 // LoginGet renders the login form
 func LoginGet(w http.ResponseWriter, r *http.Request) {
 	log.Write("Login page")
-	tmpl := template.New("layout")
+	tmpl := layoutTemplate(r)
 	tmpl, err := tmpl.Parse(templates.Layout)
 	if err != nil {
 		log.Write("[Error] failed to parse html layout page")
@@ -167,12 +175,19 @@ func LoginGet(w http.ResponseWriter, r *http.Request) {
 		fail(w, http.StatusInternalServerError, "something went wrong")
 		return
 	}
-	tmpl.Execute(w, nil)
+	info := struct {
+		OAuth bool
+	}{oauthLogin()}
+	tmpl.Execute(w, info)
 }
 
 // LoginPost logs in the user to the GIN server, storing a session token.
 func LoginPost(w http.ResponseWriter, r *http.Request) {
 	log.Write("Doing login")
+	if oauthLogin() {
+		fail(w, http.StatusForbidden, "log in with GIN instead")
+		return
+	}
 	r.ParseForm()
 	username := r.FormValue("username")
 	password := r.FormValue("password")
@@ -188,14 +203,7 @@ func LoginPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cfg := config.Read()
-	cookie := http.Cookie{
-		Name:    cfg.Settings.CookieName,
-		Value:   sessionid,
-		Expires: cookieExp(),
-		Secure:  false, // TODO: Switch when we go live
-	}
-	http.SetCookie(w, &cookie)
+	http.SetCookie(w, sessionCookie(sessionid, cookieExp()))
 	// Redirect to repo listing
 	http.Redirect(w, r, fmt.Sprintf("/repos/%s", username), http.StatusFound)
 }
@@ -207,6 +215,31 @@ func getSessionOrRedirect(w http.ResponseWriter, r *http.Request) (gweb.UserToke
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return gweb.UserToken{}, fmt.Errorf("No session cookie found")
 	}
+	session, err := getSession(cookie.Value)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		log.Write("[Error] Loading session failed: %s", err.Error())
+		return gweb.UserToken{}, fmt.Errorf("Invalid session found in cookie")
+	}
+	now := time.Now()
+	if !now.Before(sessionExpiry(session)) {
+		if err := rmSession(session.ID); err != nil {
+			log.Write("[Error] Removing expired session failed: %s", err.Error())
+		}
+		clearSessionCookie(w)
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return gweb.UserToken{}, fmt.Errorf("Session expired")
+	}
+	if now.Sub(session.LastSeen) > sessionTouchInterval {
+		if err := touchSession(session); err != nil {
+			log.Write("[Warning] Recording session activity failed: %s", err.Error())
+		}
+	}
+	// forms of other sites must not act on behalf of the user
+	if r.Method == http.MethodPost && !validCSRF(r, session.ID) {
+		fail(w, http.StatusForbidden, "invalid form token")
+		return gweb.UserToken{}, fmt.Errorf("Invalid CSRF token in form")
+	}
 	usertoken, err := getTokenBySession(cookie.Value)
 	if err != nil {
 		http.Redirect(w, r, "/login", http.StatusFound)
@@ -246,7 +279,7 @@ func ListRepos(w http.ResponseWriter, r *http.Request) {
 	}
 
 	fmt.Printf("Got %d repos\n", len(userrepos))
-	tmpl := template.New("layout")
+	tmpl := layoutTemplate(r)
 	funcmap := map[string]interface{}{
 		"ToLower": strings.ToLower,
 		"ToUpper": strings.ToUpper,
@@ -423,7 +456,7 @@ func ShowRepo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tmpl := template.New("layout")
+	tmpl := layoutTemplate(r)
 	funcmap := map[string]interface{}{
 		"ToLower": strings.ToLower,
 		"ToUpper": strings.ToUpper,
@@ -446,6 +479,27 @@ func ShowRepo(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		hooks = make(map[string]ginhook)
 	}
-	repohi := repoHooksInfo{repoinfo, hooks}
-	tmpl.Execute(w, &repohi)
+	// Webhook URLs and delivery logs may carry credentials, so only the
+	// owner of the repository gets to see them
+	owner := isRepoOwner(repoinfo, ut.Username)
+	var webhooks []webhook
+	var deliveries []webhookDelivery
+	if owner {
+		webhooks, err = readWebhooks(repopath)
+		if err != nil {
+			log.ShowWrite("[Error] reading webhooks of %q: %s", repopath, err.Error())
+		}
+		deliveries, err = readDeliveries(repopath, maxDeliveries)
+		if err != nil {
+			log.ShowWrite("[Error] reading webhook deliveries of %q: %s", repopath, err.Error())
+		}
+	}
+	repopage := struct {
+		repoHooksInfo
+		IsOwner    bool
+		Webhooks   []webhook
+		Deliveries []webhookDelivery
+		CSRFToken  string
+	}{repoHooksInfo{repoinfo, hooks}, owner, webhooks, deliveries, requestCSRFToken(r)}
+	tmpl.Execute(w, &repopage)
 }
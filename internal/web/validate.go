@@ -2,466 +2,302 @@ package web
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"html/template"
 	"io/ioutil"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
-	"gopkg.in/yaml.v2"
-
 	"github.com/G-Node/gin-cli/ginclient"
 	glog "github.com/G-Node/gin-cli/ginclient/log"
 	"github.com/G-Node/gin-cli/git"
+	"github.com/G-Node/gin-cli/git/shell"
 	"github.com/G-Node/gin-valid/internal/config"
 	"github.com/G-Node/gin-valid/internal/helpers"
 	"github.com/G-Node/gin-valid/internal/log"
 	"github.com/G-Node/gin-valid/internal/resources"
 	"github.com/G-Node/gin-valid/internal/resources/templates"
+	"github.com/G-Node/gin-valid/internal/validators"
 	gogs "github.com/gogits/go-gogs-client"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
-// BidsMessages contains Errors, Warnings and Ignored messages.
-// Currently its just the number of individual messages
-// we are interested in. If this changes, the messages
-// will be expanded into proper structs of their own.
-type BidsMessages struct {
-	Errors   []interface{} `json:"errors"`
-	Warnings []interface{} `json:"warnings"`
-	Ignored  []interface{} `json:"ignored"`
+func runValidatorBoth(validator, repopath, commit, commitname string, gcl *ginclient.Client, automatic bool) string {
+	j := &job{
+		ID:         uuid.New().String(),
+		Validator:  validator,
+		Repopath:   repopath,
+		Commit:     commit,
+		Commitname: commitname,
+		Automatic:  automatic,
+		gcl:        gcl,
+	}
+	return queueJob(j)
 }
 
-// BidsRoot contains only the root issues element.
-type BidsRoot struct {
-	Issues BidsMessages `json:"issues"`
+// queueJob adds a job to the queue and returns the path of its results
+// relative to config.Dir.Result. Hook triggered jobs for a commit that is
+// already being validated or that has completed results are not queued; the
+// path of the existing results is returned instead.
+func queueJob(j *job) string {
+	newJobContext(j)
+	if !j.Automatic {
+		// One-time validations always get a new commit ID and never
+		// supersede hook triggered jobs.
+		prepareJob(j)
+		enqueue(j)
+		return j.respath()
+	}
+
+	if regjob, ok := repoJobs.register(j, func() bool { return prepareJob(j) }); !ok {
+		return regjob.respath()
+	}
+	reportStatus(j, j.gcl, statuspending, "Validation queued")
+	enqueue(j)
+	return j.respath()
 }
 
-// Validationcfg is used to unmarshall a config file
-// holding information specific for running the
-// various validations. e.g. where the root
-// folder of a bids directory can be found or
-// whether the NiftiHeaders should be ignored.
-type Validationcfg struct {
-	Bidscfg struct {
-		BidsRoot      string `yaml:"bidsroot"`
-		ValidateNifti bool   `yaml:"validatenifti"`
-	} `yaml:"bidsconfig"`
-}
+// prepareJob creates the results directory of a job and fills it with the
+// processing badge and message to display while the job is queued and while
+// the validator runs. For hook triggered jobs, it also links 'latest' to the
+// new results directory.
+// If a hook triggered job that is not forced finds completed results for its
+// commit, it only relinks 'latest' and returns false; the job should then
+// not be run.
+func prepareJob(j *job) bool {
+	srvcfg := config.Read()
+	resdir := filepath.Join(srvcfg.Dir.Result, j.respath())
 
-// handleValidationConfig unmarshalles a yaml config file
-// from file and returns the resulting Validationcfg struct.
-func handleValidationConfig(cfgpath string) (Validationcfg, error) {
-	valcfg := Validationcfg{}
+	if j.Automatic && !j.Force && hasResults(resdir) {
+		log.ShowWrite("[Info] reusing existing %s results for %q (%s)", j.Validator, j.Repopath, j.Commitname)
+		linkLatest(resdir)
+		return false
+	}
 
-	content, err := ioutil.ReadFile(cfgpath)
+	log.ShowWrite("[Info] Queueing %s validation on repository %q (%s)", j.Validator, j.Repopath, j.Commitname)
+	// Results of an earlier run are restored if the job is cancelled
+	err := keepPrevious(resdir)
 	if err != nil {
-		return valcfg, err
+		log.ShowWrite("[Error] keeping previous results in %q: %s", resdir, err.Error())
 	}
-
-	err = yaml.Unmarshal(content, &valcfg)
+	// Create results folder if necessary
+	err = os.MkdirAll(resdir, os.ModePerm)
 	if err != nil {
-		return valcfg, err
+		log.ShowWrite("[Error] creating %q results folder: %s", resdir, err.Error())
+		return true
 	}
 
-	return valcfg, nil
-}
-
-// validateBIDS runs the BIDS validator on the specified repository in 'path'
-// and saves the results to the appropriate document for later viewing.
-func validateBIDS(valroot, resdir string) error {
-	srvcfg := config.Read()
-	// Use validation config file if available
-	var validateNifti bool
-
-	cfgpath := filepath.Join(valroot, srvcfg.Label.ValidationConfigFile)
-	log.ShowWrite("[Info] looking for config file at '%s'", cfgpath)
-	if fi, err := os.Stat(cfgpath); err == nil && !fi.IsDir() {
-		valcfg, err := handleValidationConfig(cfgpath)
-		if err == nil {
-			checkdir := filepath.Join(valroot, valcfg.Bidscfg.BidsRoot)
-			if fi, err = os.Stat(checkdir); err == nil && fi.IsDir() {
-				valroot = checkdir
-				log.ShowWrite("[Info] using validation root directory: %s\n%s", valroot, checkdir)
-			} else {
-				log.ShowWrite("[Error] reading validation root directory: %s", err.Error())
-			}
-			validateNifti = valcfg.Bidscfg.ValidateNifti
-		} else {
-			log.ShowWrite("[Error] unmarshalling validation config file: %s", err.Error())
-		}
-	} else {
-		log.ShowWrite("[Info] no validation config file found or processed, running from repo root (%s)", err.Error())
-	}
-
-	// Ignoring NiftiHeaders for now, since it seems to be a common error
-	outBadge := filepath.Join(resdir, srvcfg.Label.ResultsBadge)
-	log.ShowWrite("[Info] Running bids validation: '%s %t --json %s'", srvcfg.Exec.BIDS, validateNifti, valroot)
-
-	// Make sure the validator arguments are in the right order
-	var args []string
-	if !validateNifti {
-		args = append(args, "--ignoreNiftiHeaders")
-	}
-	args = append(args, "--json")
-	args = append(args, valroot)
-
-	// cmd = exec.Command(srvcfg.Exec.BIDS, validateNifti, "--json", valroot)
-	var out, serr bytes.Buffer
-	cmd := exec.Command(srvcfg.Exec.BIDS, args...)
-	out.Reset()
-	serr.Reset()
-	cmd.Stdout = &out
-	cmd.Stderr = &serr
-	// cmd.Dir = tmpdir
-	if err := cmd.Run(); err != nil {
-		err = fmt.Errorf("[Error] running bids validation (%s): '%s', '%s'", valroot, err.Error(), serr.String())
-		log.ShowWrite(err.Error())
-		return err
+	procBadge := filepath.Join(resdir, srvcfg.Label.ResultsBadge)
+	err = ioutil.WriteFile(procBadge, []byte(resources.ProcessingBadge), os.ModePerm)
+	if err != nil {
+		log.ShowWrite("[Error] writing results badge for %q", resdir)
 	}
 
-	// We need this for both the writing of the result and the badge
-	output := out.Bytes()
-
-	// CHECK: can this lead to a race condition, if a job for the same user/repo combination is started twice in short succession?
 	outFile := filepath.Join(resdir, srvcfg.Label.ResultsFile)
-	err := ioutil.WriteFile(outFile, []byte(output), os.ModePerm)
+	err = ioutil.WriteFile(outFile, []byte(progressmsg), os.ModePerm)
 	if err != nil {
-		err = fmt.Errorf("[Error] writing results file for %q", valroot)
-		log.ShowWrite(err.Error())
-		return err
+		log.ShowWrite("[Error] writing results file for %q", resdir)
 	}
 
-	// Write proper badge according to result
-	content := resources.SuccessBadge
-	var parseBIDS BidsRoot
-	err = json.Unmarshal(output, &parseBIDS)
-	if err != nil {
-		err = fmt.Errorf("[Error] unmarshalling results json: %s", err.Error())
-		log.ShowWrite(err.Error())
-		return err
+	if j.Automatic {
+		// Link 'latest' to new res dir to show processing
+		linkLatest(resdir)
 	}
+	return true
+}
 
-	if len(parseBIDS.Issues.Errors) > 0 {
-		content = resources.ErrorBadge
-	} else if len(parseBIDS.Issues.Warnings) > 0 {
-		content = resources.WarningBadge
+// hasResults returns whether 'resdir' holds the results of a completed
+// validation. Results of validations that are still in progress or that
+// failed to run do not count.
+func hasResults(resdir string) bool {
+	srvcfg := config.Read()
+	badge, err := ioutil.ReadFile(filepath.Join(resdir, srvcfg.Label.ResultsBadge))
+	if err != nil {
+		return false
 	}
-
-	err = ioutil.WriteFile(outBadge, []byte(content), os.ModePerm)
+	if string(badge) == resources.ProcessingBadge || string(badge) == resources.FailureBadge {
+		return false
+	}
+	content, err := ioutil.ReadFile(filepath.Join(resdir, srvcfg.Label.ResultsFile))
 	if err != nil {
-		err = fmt.Errorf("[Error] writing results badge for %q", valroot)
-		log.ShowWrite(err.Error())
-		return err
+		return false
 	}
-
-	log.ShowWrite("[Info] finished validating repo at %q", valroot)
-	return nil
+	return string(content) != progressmsg && string(content) != waitingmsg
 }
 
-// validateNIX runs the NIX validator on the specified repository in 'path'
-// and saves the results to the appropriate document for later viewing.
-func validateNIX(valroot, resdir string) error {
-	srvcfg := config.Read()
+// linkLatest links the 'latest' results of a repository to 'resdir'.
+func linkLatest(resdir string) {
+	latestdir := filepath.Join(filepath.Dir(resdir), config.Read().Label.ResultsFolder)
+	os.Remove(latestdir) // ignore error
+	err := os.Symlink(resdir, latestdir)
+	if err != nil {
+		log.ShowWrite("[Error] failed to link %q to %q: %s", resdir, latestdir, err.Error())
+	}
+}
 
-	// TODO: Allow validator config that specifies file paths to validate
-	// For now we validate everything
-	nixfiles := make([]string, 0)
-	// Find all NIX files (.nix) in the repository
-	nixfinder := func(path string, info os.FileInfo, err error) error {
+// runCommand runs a gin-cli git or git-annex command in 'dir' and returns its
+// standard output. The command is killed when 'ctx' is done, so a hung clone
+// or download only blocks the job it belongs to.
+func runCommand(ctx context.Context, dir string, cmd shell.Cmd) ([]byte, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		return nil, ctx.Err()
+	case err := <-done:
 		if err != nil {
-			// something went wrong; log this and continue
-			log.ShowWrite("[Error] NIXFinder directory walk caused error at %q: %s", path, err.Error())
-			return nil
-		}
-		if info.IsDir() {
-			// nothing to do; continue
-			return nil
-		}
-
-		if strings.ToLower(filepath.Ext(path)) == ".nix" {
-			nixfiles = append(nixfiles, path)
+			return stdout.Bytes(), fmt.Errorf("%s %s: %s", strings.Join(cmd.Args, " "), err.Error(), strings.TrimSpace(stderr.String()))
 		}
-		return nil
+		return stdout.Bytes(), nil
 	}
+}
 
-	err := filepath.Walk(valroot, nixfinder)
+// cloneRepo clones the repository at 'repopath' on the GIN server into
+// 'valroot' and initialises annex in the clone.
+func cloneRepo(ctx context.Context, gcl *ginclient.Client, repopath, valroot string) error {
+	remote := fmt.Sprintf("%s/%s", gcl.GitAddress(), repopath)
+	_, err := runCommand(ctx, filepath.Dir(valroot), git.Command("clone", remote, valroot))
 	if err != nil {
-		err = fmt.Errorf("[Error] while looking for NIX files in repository at %q: %s", valroot, err.Error())
-		log.ShowWrite(err.Error())
 		return err
 	}
-
-	outBadge := filepath.Join(resdir, srvcfg.Label.ResultsBadge)
-
-	var out, serr bytes.Buffer
-	cmd := exec.Command(srvcfg.Exec.NIX, nixfiles...)
-	out.Reset()
-	serr.Reset()
-	cmd.Stdout = &out
-	cmd.Stderr = &serr
-	// cmd.Dir = tmpdir
-	if err = cmd.Run(); err != nil {
-		err = fmt.Errorf("[Error] running NIX validation (%s): '%s', '%s'", valroot, err.Error(), serr.String())
-		log.ShowWrite(err.Error())
+	_, err = runCommand(ctx, valroot, git.Command("config", "core.quotepath", "false"))
+	if err != nil {
 		return err
 	}
+	_, err = runCommand(ctx, valroot, git.AnnexCommand("init", "--version=7", "gin-valid"))
+	return err
+}
 
-	// We need this for both the writing of the result and the badge
-	errtag := []byte("with errors")
-	warntag := []byte("with warnings")
-	var badge []byte
-	output := out.Bytes()
-	switch {
-	case bytes.Contains(output, errtag):
-		badge = []byte(resources.ErrorBadge)
-	case bytes.Contains(output, warntag):
-		badge = []byte(resources.WarningBadge)
-	default:
-		badge = []byte(resources.SuccessBadge)
-	}
+// checkoutCommit checks out the files of 'commit' in the clone at 'valroot'.
+func checkoutCommit(ctx context.Context, valroot, commit string) error {
+	_, err := runCommand(ctx, valroot, git.Command("checkout", commit, "--", "."))
+	return err
+}
 
-	// CHECK: can this lead to a race condition, if a job for the same user/repo combination is started twice in short succession?
-	outFile := filepath.Join(resdir, srvcfg.Label.ResultsFile)
-	err = ioutil.WriteFile(outFile, output, os.ModePerm)
-	if err != nil {
-		err = fmt.Errorf("[Error] writing results file for %q", valroot)
-		log.ShowWrite(err.Error())
-		return err
+// runJob clones the repository of a queued job, downloads its content and
+// runs the validator on it. Any failure is written to the results directory
+// and returned. If the job is cancelled, runJob stops after the current step
+// and returns the context error without writing a failure.
+func runJob(j *job, gcl *ginclient.Client) error {
+	validator, repopath, commit := j.Validator, j.Repopath, j.Commit
+	log.ShowWrite("[Info] Running %s validation on repository %q (%s)", validator, repopath, j.Commitname)
+
+	srvcfg := config.Read()
+	resdir := filepath.Join(srvcfg.Dir.Result, j.respath())
+	if j.ctx.Err() != nil {
+		return j.ctx.Err()
 	}
 
-	err = ioutil.WriteFile(outBadge, badge, os.ModePerm)
+	tmpdir, err := ioutil.TempDir(srvcfg.Dir.Temp, validator)
 	if err != nil {
-		err = fmt.Errorf("[Error] writing results badge for %q", valroot)
-		log.ShowWrite(err.Error())
+		log.ShowWrite("[Error] Internal error: Couldn't create temporary gin directory: %s", err.Error())
+		writeValFailure(resdir)
 		return err
 	}
 
-	log.ShowWrite("[Info] finished validating repo at %q", valroot)
-	return nil
-}
+	repopathparts := strings.SplitN(repopath, "/", 2)
+	_, repo := repopathparts[0], repopathparts[1]
+	valroot := filepath.Join(tmpdir, repo)
 
-func validateODML(valroot, resdir string) error {
-	srvcfg := config.Read()
+	// Enable cleanup once tried and tested
+	defer os.RemoveAll(tmpdir)
 
-	// TODO: Allow validator config that specifies file paths to validate
-	// For now we validate everything
-	odmlfiles := make([]string, 0)
-	// Find all NIX files (.nix) in the repository
-	odmlfinder := func(path string, info os.FileInfo, err error) error {
+	if j.Automatic {
+		err = makeSessionKey(gcl, commit)
 		if err != nil {
-			// something went wrong; log this and continue
-			log.ShowWrite("[Error] ODMLFinder directory walk caused error at %q: %s", path, err.Error())
-			return nil
-		}
-		if info.IsDir() {
-			// nothing to do; continue
-			return nil
-		}
-
-		extension := strings.ToLower(filepath.Ext(path))
-		if extension == ".odml" || extension == ".xml" {
-			odmlfiles = append(odmlfiles, path)
+			log.ShowWrite("[error] failed to create session key: %s", err.Error())
+			writeValFailure(resdir)
+			return err
 		}
-		return nil
+		defer deleteSessionKey(gcl, commit)
 	}
-
-	err := filepath.Walk(valroot, odmlfinder)
+	glog.Init()
+	err = cloneRepo(j.ctx, gcl, repopath, valroot)
 	if err != nil {
-		err = fmt.Errorf("[Error] while looking for odML files in repository at %q: %s", valroot, err.Error())
-		log.ShowWrite(err.Error())
+		if j.ctx.Err() != nil {
+			return j.ctx.Err()
+		}
+		log.ShowWrite("[Error] Failed to fetch repository data for %q: %s", repopath, err.Error())
+		writeValFailure(resdir)
 		return err
 	}
-
-	outBadge := filepath.Join(resdir, srvcfg.Label.ResultsBadge)
-
-	var out, serr bytes.Buffer
-	cmd := exec.Command(srvcfg.Exec.ODML, odmlfiles...)
-	out.Reset()
-	serr.Reset()
-	cmd.Stdout = &out
-	cmd.Stderr = &serr
-	if err = cmd.Run(); err != nil {
-		err = fmt.Errorf("[Error] running odML validation (%s): '%s', '%s'", valroot, err.Error(), serr.String())
-		log.ShowWrite(err.Error())
-		return err
+	log.ShowWrite("[Info] clone complete for '%s'", repopath)
+	if j.ctx.Err() != nil {
+		return j.ctx.Err()
 	}
 
-	// We need this for both the writing of the result and the badge
-	errtag := []byte("[error]")
-	warntag := []byte("[warning]")
-	fataltag := []byte("[fatal]")
-	var badge []byte
-	output := out.Bytes()
-	switch {
-	case bytes.Contains(output, errtag) || bytes.Contains(output, fataltag):
-		badge = []byte(resources.ErrorBadge)
-	case bytes.Contains(output, warntag):
-		badge = []byte(resources.WarningBadge)
-	default:
-		badge = []byte(resources.SuccessBadge)
+	if j.Automatic {
+		// checkout specific commit then download the required content
+		log.ShowWrite("[Info] git checkout %s", commit)
+		err = checkoutCommit(j.ctx, valroot, commit)
+		if err != nil {
+			if j.ctx.Err() != nil {
+				return j.ctx.Err()
+			}
+			log.ShowWrite("[Error] failed to checkout commit %q: %s", commit, err.Error())
+			writeValFailure(resdir)
+			return err
+		}
 	}
-
-	// CHECK: can this lead to a race condition, if a job for the same user/repo combination is started twice in short succession?
-	outFile := filepath.Join(resdir, srvcfg.Label.ResultsFile)
-	err = ioutil.WriteFile(outFile, output, os.ModePerm)
-	if err != nil {
-		err = fmt.Errorf("[Error] writing results file for %q", valroot)
+	v, ok := validators.Get(validator)
+	if !ok {
+		err = fmt.Errorf("[Error] invalid validator name: %s", validator)
 		log.ShowWrite(err.Error())
+		writeValFailure(resdir)
 		return err
 	}
 
-	err = ioutil.WriteFile(outBadge, badge, os.ModePerm)
+	// Only download the content that is needed to run the validator
+	valcfg := validators.ReadValidationConfig(valroot)
+	paths, err := validators.ContentPaths(v, valroot, valcfg)
 	if err != nil {
-		err = fmt.Errorf("[Error] writing results badge for %q", valroot)
-		log.ShowWrite(err.Error())
+		log.ShowWrite("[Error] failed to find content to download for %q: %s", repopath, err.Error())
+		writeValFailure(resdir)
 		return err
 	}
-
-	log.ShowWrite("[Info] finished validating repo at %q", valroot)
-	return nil
-}
-
-func runValidatorBoth(validator, repopath, commit, commitname string, gcl *ginclient.Client, automatic bool) string {
-	respath := filepath.Join(validator, repopath, commit)
-	go func() {
-		log.ShowWrite("[Info] Running %s validation on repository %q (%s)", validator, repopath, commitname)
-
-		// TODO add check if a repo is currently being validated. Since the cloning
-		// can potentially take quite some time prohibit running the same
-		// validation at the same time. Could also move this to a mapped go
-		// routine and if the same repo is validated twice, the first occurrence is
-		// stopped and cleaned up while the second starts anew - to make sure its
-		// always the latest state of the repository that is being validated.
-
-		// TODO: Use the payload data to check if the specific commit has already
-		// been validated
-		srvcfg := config.Read()
-		resdir := filepath.Join(srvcfg.Dir.Result, respath)
-
-		// Create results folder if necessary
-		// CHECK: can this lead to a race condition, if a job for the same user/repo combination is started twice in short succession?
-		err := os.MkdirAll(resdir, os.ModePerm)
-		if err != nil {
-			log.ShowWrite("[Error] creating %q results folder: %s", resdir, err.Error())
-			return
-		}
-
-		tmpdir, err := ioutil.TempDir(srvcfg.Dir.Temp, validator)
+	if len(paths) > 0 {
+		log.ShowWrite("[Info] Downloading content for %d paths", len(paths))
+		err = fetchContent(j, valroot, resdir, paths)
 		if err != nil {
-			log.ShowWrite("[Error] Internal error: Couldn't create temporary gin directory: %s", err.Error())
-			writeValFailure(resdir)
-			return
-		}
-
-		repopathparts := strings.SplitN(repopath, "/", 2)
-		_, repo := repopathparts[0], repopathparts[1]
-		valroot := filepath.Join(tmpdir, repo)
-
-		// Enable cleanup once tried and tested
-		defer os.RemoveAll(tmpdir)
-
-		// Add the processing badge and message to display while the validator runs
-		procBadge := filepath.Join(resdir, srvcfg.Label.ResultsBadge)
-		err = ioutil.WriteFile(procBadge, []byte(resources.ProcessingBadge), os.ModePerm)
-		if err != nil {
-			log.ShowWrite("[Error] writing results badge for %q", valroot)
-		}
-
-		outFile := filepath.Join(resdir, srvcfg.Label.ResultsFile)
-		err = ioutil.WriteFile(outFile, []byte(progressmsg), os.ModePerm)
-		if err != nil {
-			log.ShowWrite("[Error] writing results file for %q", valroot)
-		}
-
-		if automatic { //TODO should be automatic or not?
-			// Link 'latest' to new res dir to show processing
-			latestdir := filepath.Join(filepath.Dir(resdir), "latest")
-			os.Remove(latestdir) // ignore error
-			err = os.Symlink(resdir, latestdir)
-			if err != nil {
-				log.ShowWrite("[Error] failed to link %q to %q: %s", resdir, latestdir, err.Error())
-				// Don't return if processing badge write fails
-			}
-			err = makeSessionKey(gcl, commit)
-			if err != nil {
-				log.ShowWrite("[error] failed to create session key: %s", err.Error())
-				writeValFailure(resdir)
-				return
-			}
-			defer deleteSessionKey(gcl, commit)
-		}
-		// TODO: if (annexed) content is not available yet, wait and retry.  We
-		// would have to set a max timeout for this.  The issue is that when a user
-		// does a 'gin upload' a push happens immediately and the hook is
-		// triggered, but annexed content is only transferred after the push and
-		// could take a while (hours?). The validation service should try to
-		// download content after the transfer is complete, or should keep retrying
-		// until it's available, with a timeout. We could also make it more
-		// efficient by only downloading the content in the directories which are
-		// specified in the validator config (if it exists).
-
-		glog.Init()
-		clonechan := make(chan git.RepoFileStatus)
-		os.Chdir(tmpdir)
-		go gcl.CloneRepo(repopath, clonechan)
-		for stat := range clonechan {
-			if stat.Err != nil {
-				log.ShowWrite("[Error] Failed to fetch repository data for %q: %s", repopath, stat.Err.Error())
-				writeValFailure(resdir)
-				return
-			}
-			log.ShowWrite("[Info] %s %s", stat.State, stat.Progress)
-		}
-		log.ShowWrite("[Info] clone complete for '%s'", repopath)
-
-		if automatic {
-			// checkout specific commit then download all content
-			log.ShowWrite("[Info] git checkout %s", commit)
-			err = git.Checkout(commit, nil)
-			if err != nil {
-				log.ShowWrite("[Error] failed to checkout commit %q: %s", commit, err.Error())
-				writeValFailure(resdir)
-				return
-			}
-		}
-		log.ShowWrite("[Info] Downloading content")
-		getcontentchan := make(chan git.RepoFileStatus)
-		// TODO: Get only the content for the files that will be validated
-		go gcl.GetContent([]string{"."}, getcontentchan)
-		for stat := range getcontentchan {
-			if stat.Err != nil {
-				log.ShowWrite("[Error] failed to get content for %q: %s", repopath, stat.Err.Error())
-				writeValFailure(resdir)
-				return
+			if j.ctx.Err() != nil {
+				return j.ctx.Err()
 			}
-			log.ShowWrite("[Info] %s %s %s", stat.State, stat.FileName, stat.Progress)
+			log.ShowWrite("[Error] failed to get content for %q: %s", repopath, err.Error())
+			writeValFailure(resdir)
+			return err
 		}
 		log.ShowWrite("[Info] get-content complete")
+	}
+	if j.ctx.Err() != nil {
+		return j.ctx.Err()
+	}
 
-		switch validator {
-		case "bids":
-			err = validateBIDS(valroot, resdir)
-		case "nix":
-			err = validateNIX(valroot, resdir)
-		case "odml":
-			err = validateODML(valroot, resdir)
-		default:
-			err = fmt.Errorf("[Error] invalid validator name: %s", validator)
-		}
-
-		if err != nil {
-			writeValFailure(resdir)
-		}
-	}()
-	return respath
+	err = validators.Run(j.ctx, v, valroot, resdir)
+	if errors.Is(err, validators.ErrTimedOut) {
+		writeValTimeout(resdir)
+	} else if err != nil && j.ctx.Err() == nil {
+		writeValFailure(resdir)
+	}
+	return err
 }
+
 func runValidator(validator, repopath, commit string, gcl *ginclient.Client) {
 	automatic := true
 	runValidatorBoth(validator, repopath, commit, commit, gcl, automatic)
@@ -491,6 +327,24 @@ func writeValFailure(resdir string) {
 	}
 }
 
+// writeValTimeout writes a badge and page content for when the validator was
+// stopped after exceeding its time limit. This function does not return
+// anything, but logs all errors.
+func writeValTimeout(resdir string) {
+	srvcfg := config.Read()
+	procBadge := filepath.Join(resdir, srvcfg.Label.ResultsBadge)
+	err := ioutil.WriteFile(procBadge, []byte(resources.TimeoutBadge), os.ModePerm)
+	if err != nil {
+		log.ShowWrite("[Error] writing timeout badge to %q: %s", resdir, err.Error())
+	}
+
+	outFile := filepath.Join(resdir, srvcfg.Label.ResultsFile)
+	err = ioutil.WriteFile(outFile, []byte(timedoutmsg), os.ModePerm)
+	if err != nil {
+		log.ShowWrite("[Error] writing timeout page to %q: %s", resdir, err.Error())
+	}
+}
+
 // Root handles the root path of the service. If the user is logged in, it
 // redirects to the user's repository listing. If the user is not logged in, it
 // redirects to the login form.
@@ -503,7 +357,7 @@ func Root(w http.ResponseWriter, r *http.Request) {
 // to manually run a validator on a publicly accessible repository, without
 // using a web hook.
 func PubValidateGet(w http.ResponseWriter, r *http.Request) {
-	tmpl := template.New("layout")
+	tmpl := layoutTemplate(r)
 	tmpl, err := tmpl.Parse(templates.Layout)
 	if err != nil {
 		log.ShowWrite("[Error] failed to parse html layout page")
@@ -589,6 +443,13 @@ func Validate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	commithash := hookdata.After
+	if commithash == deletedcommit {
+		// A branch or tag was deleted; there is nothing to validate
+		log.ShowWrite("[Info] Ignoring push deleting %s", hookdata.Ref)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+		return
+	}
 
 	log.ShowWrite("[Info] Hook secret: %s", secret)
 	log.ShowWrite("[Info] Commit hash: %s", commithash)
@@ -605,16 +466,6 @@ func Validate(w http.ResponseWriter, r *http.Request) {
 	repopath := fmt.Sprintf("%s/%s", user, repo)
 	log.ShowWrite("[Info] '%s' validation for repo '%s'", validator, repopath)
 
-	// TODO add check if a repo is currently being validated. Since the cloning
-	// can potentially take quite some time prohibit running the same
-	// validation at the same time. Could also move this to a mapped go
-	// routine and if the same repo is validated twice, the first occurrence is
-	// stopped and cleaned up while the second starts anew - to make sure its
-	// always the latest state of the repository that is being validated.
-
-	// TODO: Use the payload data to check if the specific commit has already
-	// been validated
-
 	// get the token for this repository
 	ut, err := getTokenByRepo(repopath)
 	if err != nil {
@@ -642,3 +493,79 @@ func Validate(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
+
+// isRepoOwner returns whether the user owns the repository or has admin
+// access to it.
+func isRepoOwner(repoinfo gogs.Repository, username string) bool {
+	if repoinfo.Permissions != nil && repoinfo.Permissions.Admin {
+		return true
+	}
+	return repoinfo.Owner != nil && repoinfo.Owner.UserName == username
+}
+
+// Revalidate runs a validator again on the latest validated commit of a
+// repository, even if results for the commit already exist. Only the owner
+// of the repository can force a revalidation.
+func Revalidate(w http.ResponseWriter, r *http.Request) {
+	ut, err := getSessionOrRedirect(w, r)
+	if err != nil {
+		log.Write("[Info] %s: Redirecting to login", err.Error())
+		return
+	}
+	vars := mux.Vars(r)
+	user := vars["user"]
+	repo := vars["repo"]
+	validator := strings.ToLower(vars["validator"])
+	if !helpers.SupportedValidator(validator) {
+		fail(w, http.StatusNotFound, "unsupported validator")
+		return
+	}
+	repopath := fmt.Sprintf("%s/%s", user, repo)
+	gcl := ginclient.New(serveralias)
+	gcl.UserToken = ut
+	repoinfo, err := gcl.GetRepo(repopath)
+	if err != nil {
+		fail(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if !isRepoOwner(repoinfo, ut.Username) {
+		fail(w, http.StatusForbidden, "only the repository owner can revalidate a repository")
+		return
+	}
+
+	commit, err := latestCommit(validator, repopath)
+	if err != nil {
+		fail(w, http.StatusNotFound, fmt.Sprintf("no %s validation of '%s' to repeat", validator, repopath))
+		return
+	}
+	log.ShowWrite("[Info] %s forces %s revalidation of %q (%s)", ut.Username, validator, repopath, commit)
+	revalidate(validator, repopath, commit, gcl)
+	http.Redirect(w, r, fmt.Sprintf("/results/%s/%s", validator, repopath), http.StatusFound)
+}
+
+// latestCommit returns the commit of the latest hook triggered validation of
+// a repository.
+func latestCommit(validator, repopath string) (string, error) {
+	srvcfg := config.Read()
+	latestdir := filepath.Join(srvcfg.Dir.Result, validator, repopath, srvcfg.Label.ResultsFolder)
+	target, err := os.Readlink(latestdir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(target), nil
+}
+
+// revalidate queues a hook triggered validation of a commit that runs even if
+// results for the commit already exist.
+func revalidate(validator, repopath, commit string, gcl *ginclient.Client) {
+	queueJob(&job{
+		ID:         uuid.New().String(),
+		Validator:  validator,
+		Repopath:   repopath,
+		Commit:     commit,
+		Commitname: commit,
+		Automatic:  true,
+		Force:      true,
+		gcl:        gcl,
+	})
+}
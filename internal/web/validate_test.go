@@ -2,17 +2,22 @@ package web
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
-	gweb "github.com/G-Node/gin-cli/web"
+	"fmt"
 	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/resources"
 	"github.com/G-Node/gin-valid/internal/resources/templates"
+	"github.com/G-Node/gin-valid/internal/store"
 	"github.com/gorilla/mux"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -23,44 +28,19 @@ var username = "valid-testing"
 var reponame = "Testing"
 var token = "4c82d07cccf103e071ad9ee8aec82c34d7003c6c"
 
-func TestValiateBadConfig(t *testing.T) {
-	handleValidationConfig("wtf")
-}
-func TestValidateNotYAML(t *testing.T) {
-	f, _ := os.Create("testing-config.json")
-	f.WriteString("foo: somebody said I should put a colon here: so I did")
-	f.Close()
-	handleValidationConfig("testing-config.json")
-	os.RemoveAll("testing-config.json")
-}
-func TestValidateGoodConfig(t *testing.T) {
-	f, _ := os.Create("testing-config.json")
-	f.WriteString("empty: \"true\"")
-	f.Close()
-	handleValidationConfig("testing-config.json")
-	os.RemoveAll("testing-config.json")
-}
-func TestValidateBIDSNoData(t *testing.T) {
-	validateBIDS("wtf", "wtf")
-}
-func TestValidateNIXNoData(t *testing.T) {
-	validateNIX("wtf", "wtf")
-}
-func TestValidateODMLNoData(t *testing.T) {
-	validateODML("wtf", "wtf")
-}
 func TestValidateBadgeFail(t *testing.T) { //TODO
 	body := []byte("{}")
 	router := mux.NewRouter()
 	router.HandleFunc("/validate/{validator}/{user}/{repo}", Validate).Methods("POST")
 	srvcfg := config.Read()
 	srvcfg.Dir.Tokens = "."
+	srvcfg.TokenStore.KeyFile = ""
 	os.Mkdir("tmp", 0755)
 	srvcfg.Dir.Temp = "./tmp"
 	srvcfg.GINAddresses.WebURL = "https://gin.dev.g-node.org:443"
 	srvcfg.GINAddresses.GitURL = "git@gin.dev.g-node.org:22"
 	config.Set(srvcfg)
-	var tok gweb.UserToken
+	var tok store.UserToken
 	tok.Username = username
 	tok.Token = token
 	saveToken(tok)
@@ -124,8 +104,9 @@ func TestValidateTMPFail(t *testing.T) {
 	router.HandleFunc("/validate/{validator}/{user}/{repo}", Validate).Methods("POST")
 	srvcfg := config.Read()
 	srvcfg.Dir.Tokens = "."
+	srvcfg.TokenStore.KeyFile = ""
 	config.Set(srvcfg)
-	var tok gweb.UserToken
+	var tok store.UserToken
 	tok.Username = username
 	tok.Token = token
 	saveToken(tok)
@@ -146,8 +127,9 @@ func TestValidateRepoDoesNotExists(t *testing.T) {
 	router.HandleFunc("/validate/{validator}/{user}/{repo}", Validate).Methods("POST")
 	srvcfg := config.Read()
 	srvcfg.Dir.Tokens = "."
+	srvcfg.TokenStore.KeyFile = ""
 	config.Set(srvcfg)
-	var tok gweb.UserToken
+	var tok store.UserToken
 	tok.Username = username
 	tok.Token = token2
 	saveToken(tok)
@@ -210,3 +192,127 @@ func TestValidateBadBody(t *testing.T) {
 	w := httptest.NewRecorder()
 	Validate(w, testRequest)
 }
+func TestValidateCachedResults(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir("", "results")
+	defer os.RemoveAll(tmpdir)
+	srvcfg := config.Read()
+	original := srvcfg
+	srvcfg.Dir.Result = tmpdir
+	config.Set(srvcfg)
+	defer config.Set(original)
+	repopath := filepath.Join(username, "cache-testing")
+	j := &job{Validator: "bids", Repopath: repopath, Commit: "abc", Commitname: "abc", Automatic: true}
+	resdir := filepath.Join(srvcfg.Dir.Result, j.respath())
+	if hasResults(resdir) {
+		t.Fatal("missing results reported as complete")
+	}
+	if !prepareJob(j) {
+		t.Fatal("job without results should run")
+	}
+	if hasResults(resdir) {
+		t.Fatal("results in progress reported as complete")
+	}
+	ioutil.WriteFile(filepath.Join(resdir, srvcfg.Label.ResultsBadge), []byte(resources.SuccessBadge), 0644)
+	ioutil.WriteFile(filepath.Join(resdir, srvcfg.Label.ResultsFile), []byte("{}"), 0644)
+	if prepareJob(j) {
+		t.Fatal("job with complete results should not run")
+	}
+	j.Force = true
+	if !prepareJob(j) {
+		t.Fatal("forced job should run")
+	}
+	latest, err := os.Readlink(filepath.Join(filepath.Dir(resdir), srvcfg.Label.ResultsFolder))
+	if err != nil || latest != resdir {
+		t.Fatalf("latest results not linked to %q: %q", resdir, latest)
+	}
+}
+func TestValidateDeletedBranch(t *testing.T) {
+	body := []byte(`{"ref": "refs/heads/wtf", "after": "0000000000000000000000000000000000000000"}`)
+	router := mux.NewRouter()
+	router.HandleFunc("/validate/{validator}/{user}/{repo}", Validate).Methods("POST")
+	r, _ := http.NewRequest("POST", "/validate/bids/whatever/whatever", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	srvcfg := config.Read()
+	sig := hmac.New(sha256.New, []byte(srvcfg.Settings.HookSecret))
+	sig.Write(body)
+	r.Header.Add("X-Gogs-Signature", hex.EncodeToString(sig.Sum(nil)))
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("push deleting a branch should be ignored, got %d", w.Code)
+	}
+}
+func TestValidateRevalidateNoSession(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/repos/{user}/{repo}/{validator}/revalidate", Revalidate).Methods("POST")
+	r, _ := http.NewRequest("POST", "/repos/whatever/whatever/bids/revalidate", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusFound {
+		t.Fatalf("request without session should be redirected, got %d", w.Code)
+	}
+}
+
+func TestValidateConcurrentCheckouts(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir("", "checkouts")
+	defer os.RemoveAll(tmpdir)
+	// two repositories with one commit each
+	commits := make(map[string]string)
+	for _, name := range []string{"first", "second"} {
+		repodir := filepath.Join(tmpdir, name)
+		os.MkdirAll(repodir, 0755)
+		ioutil.WriteFile(filepath.Join(repodir, "data.txt"), []byte(name), 0644)
+		for _, args := range [][]string{
+			{"init", "-q"},
+			{"add", "data.txt"},
+			{"-c", "user.name=valid", "-c", "user.email=valid@example.org", "commit", "-q", "-m", name},
+		} {
+			if out, err := exec.Command("git", append([]string{"-C", repodir}, args...)...).CombinedOutput(); err != nil {
+				t.Skipf("git unavailable: %s: %s", err.Error(), out)
+			}
+		}
+		out, _ := exec.Command("git", "-C", repodir, "rev-parse", "HEAD").Output()
+		commits[repodir] = strings.TrimSpace(string(out))
+	}
+
+	// every job works in its own clone, from which the checked out file is
+	// removed first
+	clones := make(map[string]string)
+	for repodir, commit := range commits {
+		for idx := 0; idx < 10; idx++ {
+			clonedir := fmt.Sprintf("%s-%d", repodir, idx)
+			if out, err := exec.Command("git", "clone", "-q", repodir, clonedir).CombinedOutput(); err != nil {
+				t.Fatalf("failed to clone %q: %s: %s", repodir, err.Error(), out)
+			}
+			os.Remove(filepath.Join(clonedir, "data.txt"))
+			clones[clonedir] = commit
+		}
+	}
+
+	// the jobs of all workers check out their commits at the same time
+	errs := make(chan error, len(clones))
+	for clonedir, commit := range clones {
+		go func(clonedir, commit string) {
+			errs <- checkoutCommit(context.Background(), clonedir, commit)
+		}(clonedir, commit)
+	}
+	for range clones {
+		if err := <-errs; err != nil {
+			t.Fatalf("concurrent checkout failed: %s", err.Error())
+		}
+	}
+	for clonedir := range clones {
+		data, _ := ioutil.ReadFile(filepath.Join(clonedir, "data.txt"))
+		if !strings.HasPrefix(filepath.Base(clonedir), string(data)+"-") {
+			t.Fatalf("clone %q has the data of %q", clonedir, data)
+		}
+	}
+
+	// a cancelled job does not run its commands
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for repodir, commit := range commits {
+		if err := checkoutCommit(ctx, repodir, commit); err != context.Canceled {
+			t.Fatalf("checkout of a cancelled job should fail with %v, got %v", context.Canceled, err)
+		}
+	}
+}
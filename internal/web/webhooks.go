@@ -0,0 +1,571 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/G-Node/gin-cli/ginclient"
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/log"
+	"github.com/G-Node/gin-valid/internal/validators"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// webhooksFile lists the webhooks of a repository.
+	webhooksFile = "webhooks.json"
+	// deliveriesFile logs the webhook deliveries of a repository, one JSON
+	// object per line.
+	deliveriesFile = "deliveries.log"
+	// maxDeliveries is the number of deliveries kept in the delivery log and
+	// shown on the repository page.
+	maxDeliveries = 20
+	// webhookTimeout limits the duration of a single delivery attempt.
+	webhookTimeout = 30 * time.Second
+)
+
+// blockedWebhookNets are the networks webhooks are not delivered to, so that
+// they cannot be used to reach services that are only available to the
+// server, like an internal GIN address or a cloud metadata endpoint: private,
+// shared, loopback, link-local and unspecified addresses.
+var blockedWebhookNets = parseNets(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.168.0.0/16", "::/128", "::1/128", "fc00::/7", "fe80::/10",
+)
+
+// webhook is a subscription of a URL to the validations of a repository.
+// Payloads are signed with Secret.
+type webhook struct {
+	ID      string
+	URL     string
+	Secret  string
+	Created time.Time
+}
+
+// storedWebhook is a webhook as it is stored in the webhooks file, with the
+// secret encrypted with the token key. Webhooks added before secrets were
+// encrypted have a plain text Secret, which is encrypted when they are read.
+type storedWebhook struct {
+	ID           string    `json:"id"`
+	URL          string    `json:"url"`
+	Secret       string    `json:"secret,omitempty"`
+	SealedSecret []byte    `json:"sealedsecret,omitempty"`
+	Created      time.Time `json:"created"`
+}
+
+// webhookPayload is the JSON body posted to webhooks when a validation
+// finishes. State is one of "done", "failed" or "timedout".
+type webhookPayload struct {
+	Validator  string `json:"validator"`
+	Repository string `json:"repository"`
+	Commit     string `json:"commit"`
+	State      string `json:"state"`
+	Outcome    string `json:"outcome,omitempty"`
+	Errors     int    `json:"errors"`
+	Warnings   int    `json:"warnings"`
+	URL        string `json:"url"`
+}
+
+// webhookDelivery is an entry in the delivery log of a repository. Status is
+// the HTTP status code of the last attempt, if the request was sent.
+type webhookDelivery struct {
+	ID        string    `json:"id"`
+	Webhook   string    `json:"webhook"`
+	URL       string    `json:"url"`
+	Validator string    `json:"validator"`
+	Commit    string    `json:"commit"`
+	Date      time.Time `json:"date"`
+	Attempts  int       `json:"attempts"`
+	Status    int       `json:"status,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Delivered bool      `json:"delivered"`
+}
+
+// webhooksLock serialises access to the webhook and delivery files.
+var webhooksLock sync.Mutex
+
+// webhooksDir returns the directory holding the webhooks and the delivery
+// log of a repository.
+func webhooksDir(repopath string) string {
+	return filepath.Join(config.Read().Dir.Webhooks, repopath)
+}
+
+// readWebhooks returns the webhooks of a repository. A repository without
+// webhooks returns an empty list.
+func readWebhooks(repopath string) ([]webhook, error) {
+	webhooksLock.Lock()
+	defer webhooksLock.Unlock()
+	return loadWebhooks(repopath)
+}
+
+// loadWebhooks reads the webhooks of a repository and decrypts their
+// secrets. Secrets that are not encrypted with the current token key are
+// saved again. The caller must hold webhooksLock.
+func loadWebhooks(repopath string) ([]webhook, error) {
+	hooks := []webhook{}
+	content, err := ioutil.ReadFile(filepath.Join(webhooksDir(repopath), webhooksFile))
+	if os.IsNotExist(err) {
+		return hooks, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var stored []storedWebhook
+	if err = json.Unmarshal(content, &stored); err != nil {
+		return nil, err
+	}
+	keys, err := loadedKeys(config.Read())
+	if err != nil {
+		return nil, err
+	}
+	resave := false
+	for _, sh := range stored {
+		hook := webhook{ID: sh.ID, URL: sh.URL, Secret: sh.Secret, Created: sh.Created}
+		if sh.SealedSecret != nil {
+			secret, stale, err := keys.Open(sh.SealedSecret, webhookRecord(repopath, sh.ID))
+			if err != nil {
+				return nil, fmt.Errorf("decrypting secret of webhook %s: %s", sh.ID, err.Error())
+			}
+			hook.Secret = string(secret)
+			resave = resave || stale
+		} else if sh.Secret != "" && keys != nil {
+			resave = true
+		}
+		hooks = append(hooks, hook)
+	}
+	if resave {
+		if err := saveWebhooks(repopath, hooks); err != nil {
+			log.ShowWrite("[Error] re-encrypting webhook secrets of %q: %s", repopath, err.Error())
+		}
+	}
+	return hooks, nil
+}
+
+// webhookRecord returns the record key the secret of a webhook is encrypted
+// for.
+func webhookRecord(repopath, id string) string {
+	return path.Join("webhooks", repopath, id)
+}
+
+// saveWebhooks stores the webhooks of a repository with their secrets
+// encrypted with the current token key. The caller must hold webhooksLock.
+func saveWebhooks(repopath string, hooks []webhook) error {
+	keys, err := loadedKeys(config.Read())
+	if err != nil {
+		return err
+	}
+	stored := make([]storedWebhook, 0, len(hooks))
+	for _, hook := range hooks {
+		sh := storedWebhook{ID: hook.ID, URL: hook.URL, Created: hook.Created}
+		if hook.Secret != "" {
+			if sh.SealedSecret, err = keys.Seal([]byte(hook.Secret), webhookRecord(repopath, hook.ID)); err != nil {
+				return err
+			}
+		}
+		stored = append(stored, sh)
+	}
+	dir := webhooksDir(repopath)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, webhooksFile), content, 0600)
+}
+
+// reencryptWebhooks stores the webhooks of all repositories again, encrypting
+// their secrets with the current token key. It returns the number of
+// repositories with webhooks.
+func reencryptWebhooks() (int, error) {
+	root := config.Read().Dir.Webhooks
+	count := 0
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == root {
+			return filepath.SkipDir
+		} else if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Name() != webhooksFile {
+			return nil
+		}
+		repodir, err := filepath.Rel(root, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		repopath := filepath.ToSlash(repodir)
+		webhooksLock.Lock()
+		defer webhooksLock.Unlock()
+		hooks, err := loadWebhooks(repopath)
+		if err != nil {
+			return fmt.Errorf("reading webhooks of %q: %s", repopath, err.Error())
+		}
+		if err = saveWebhooks(repopath, hooks); err != nil {
+			return fmt.Errorf("saving webhooks of %q: %s", repopath, err.Error())
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// parseNets parses networks in CIDR notation, skipping invalid ones.
+func parseNets(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.ShowWrite("[Warning] ignoring invalid network %q: %s", cidr, err.Error())
+			continue
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets
+}
+
+// checkWebhookIP returns an error if webhooks must not be delivered to an
+// address, because it is in blockedWebhookNets and not in one of the networks
+// listed in Settings.WebhookAllowedNets.
+func checkWebhookIP(ip net.IP) error {
+	if ip == nil {
+		return fmt.Errorf("invalid webhook address")
+	}
+	for _, allowed := range parseNets(config.Read().Settings.WebhookAllowedNets...) {
+		if allowed.Contains(ip) {
+			return nil
+		}
+	}
+	for _, blocked := range blockedWebhookNets {
+		if blocked.Contains(ip) {
+			return fmt.Errorf("webhook address %s is not public", ip)
+		}
+	}
+	return nil
+}
+
+// checkWebhookHost resolves the host of a webhook URL and returns an error if
+// any of its addresses is not allowed as a webhook target.
+func checkWebhookHost(host string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("resolving webhook host %q: %s", host, err.Error())
+	}
+	for _, addr := range addrs {
+		if err := checkWebhookIP(addr.IP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webhookClient returns the client for delivering webhooks. It checks the
+// address of every connection when it is made, so that a host that resolves
+// to an address that is not allowed after the webhook was added, or a
+// redirect to such a host, is not reached either. Proxies are not used, since
+// they would connect on behalf of the server without the check.
+func webhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			return checkWebhookIP(net.ParseIP(host))
+		},
+	}
+	return &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+	}
+}
+
+// addWebhook subscribes 'hookurl' to the validations of a repository.
+func addWebhook(repopath, hookurl, secret string) (webhook, error) {
+	u, err := url.Parse(hookurl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return webhook{}, fmt.Errorf("invalid webhook URL %q", hookurl)
+	}
+	if err = checkWebhookHost(u.Hostname()); err != nil {
+		return webhook{}, err
+	}
+	hook := webhook{ID: uuid.New().String(), URL: u.String(), Secret: secret, Created: time.Now()}
+	webhooksLock.Lock()
+	defer webhooksLock.Unlock()
+	hooks, err := loadWebhooks(repopath)
+	if err != nil {
+		return webhook{}, err
+	}
+	return hook, saveWebhooks(repopath, append(hooks, hook))
+}
+
+// removeWebhook removes the webhook with the given ID from a repository and
+// returns whether it existed.
+func removeWebhook(repopath, id string) (bool, error) {
+	webhooksLock.Lock()
+	defer webhooksLock.Unlock()
+	hooks, err := loadWebhooks(repopath)
+	if err != nil {
+		return false, err
+	}
+	for idx, hook := range hooks {
+		if hook.ID == id {
+			return true, saveWebhooks(repopath, append(hooks[:idx], hooks[idx+1:]...))
+		}
+	}
+	return false, nil
+}
+
+// logDelivery appends a delivery to the delivery log of a repository. Only the
+// last maxDeliveries deliveries are kept.
+func logDelivery(repopath string, delivery webhookDelivery) error {
+	content, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	webhooksLock.Lock()
+	defer webhooksLock.Unlock()
+	dir := webhooksDir(repopath)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	logfile := filepath.Join(dir, deliveriesFile)
+	previous, err := ioutil.ReadFile(logfile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lines := strings.Split(strings.TrimSpace(string(previous)), "\n")
+	if len(lines) >= maxDeliveries {
+		lines = lines[len(lines)-maxDeliveries+1:]
+	} else if lines[0] == "" {
+		lines = nil
+	}
+	lines = append(lines, string(content))
+	return ioutil.WriteFile(logfile, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// readDeliveries returns the last 'max' deliveries of a repository, most
+// recent first.
+func readDeliveries(repopath string, max int) ([]webhookDelivery, error) {
+	webhooksLock.Lock()
+	defer webhooksLock.Unlock()
+	deliveries := []webhookDelivery{}
+	fp, err := os.Open(filepath.Join(webhooksDir(repopath), deliveriesFile))
+	if os.IsNotExist(err) {
+		return deliveries, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		var delivery webhookDelivery
+		if err := json.Unmarshal(scanner.Bytes(), &delivery); err != nil {
+			log.ShowWrite("[Warning] skipping invalid webhook delivery of %q: %s", repopath, err.Error())
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if len(deliveries) > max {
+		deliveries = deliveries[len(deliveries)-max:]
+	}
+	for i, j := 0, len(deliveries)-1; i < j; i, j = i+1, j-1 {
+		deliveries[i], deliveries[j] = deliveries[j], deliveries[i]
+	}
+	return deliveries, scanner.Err()
+}
+
+// sendWebhooks posts the outcome of a finished hook triggered job to all
+// webhooks of the repository. 'state' is the final state of the job. Every
+// webhook is delivered in its own goroutine.
+func sendWebhooks(j *job, resdir string, state string) {
+	if !j.Automatic {
+		return
+	}
+	hooks, err := readWebhooks(j.Repopath)
+	if err != nil {
+		log.ShowWrite("[Error] reading webhooks of %q: %s", j.Repopath, err.Error())
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	payload := webhookPayload{
+		Validator:  j.Validator,
+		Repository: j.Repopath,
+		Commit:     j.Commit,
+		State:      state,
+	}
+	if state == statedone {
+		rep, err := validators.ReadReport(resdir)
+		if err != nil {
+			log.ShowWrite("[Error] reading report for webhooks of %q: %s", j.Repopath, err.Error())
+			return
+		}
+		payload.Errors = rep.Errors
+		payload.Warnings = rep.Warnings
+		payload.Outcome = reportOutcome(rep)
+	}
+	srvcfg := config.Read()
+	target, err := url.Parse(srvcfg.Settings.RootURL)
+	if err != nil {
+		log.ShowWrite("[Error] failed to parse url: %s", err.Error())
+		return
+	}
+	target.Path = path.Join(target.Path, "results", j.respath())
+	payload.URL = target.String()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.ShowWrite("[Error] encoding webhook payload: %s", err.Error())
+		return
+	}
+
+	for _, hook := range hooks {
+		go deliverWebhook(j, hook, body)
+	}
+}
+
+// deliverWebhook posts the payload to a webhook, retrying with increasing
+// intervals until it is accepted or Settings.WebhookAttempts attempts failed,
+// and logs the delivery.
+func deliverWebhook(j *job, hook webhook, body []byte) {
+	srvcfg := config.Read()
+	attempts := srvcfg.Settings.WebhookAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	interval := time.Duration(srvcfg.Settings.WebhookRetry) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	delivery := webhookDelivery{
+		ID:        uuid.New().String(),
+		Webhook:   hook.ID,
+		URL:       hook.URL,
+		Validator: j.Validator,
+		Commit:    j.Commit,
+		Date:      time.Now(),
+	}
+	client := webhookClient()
+	for delivery.Attempts < attempts {
+		if delivery.Attempts > 0 {
+			time.Sleep(interval)
+			interval *= 2
+		}
+		delivery.Attempts++
+		delivery.Status, delivery.Error = postWebhook(client, hook, delivery.ID, body)
+		if delivery.Error == "" {
+			delivery.Delivered = true
+			break
+		}
+		log.ShowWrite("[Warning] webhook delivery %d of %d to %q failed: %s", delivery.Attempts, attempts, hook.URL, delivery.Error)
+	}
+	err := logDelivery(j.Repopath, delivery)
+	if err != nil {
+		log.ShowWrite("[Error] logging webhook delivery for %q: %s", j.Repopath, err.Error())
+	}
+}
+
+// postWebhook makes a single delivery attempt. It returns the status code of
+// the response, if any, and a description of the error if the payload was not
+// accepted.
+func postWebhook(client *http.Client, hook webhook, id string, body []byte) (int, string) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err.Error()
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GinValid-Event", "validation")
+	req.Header.Set("X-GinValid-Delivery", id)
+	req.Header.Set("X-GinValid-Signature", hookSignature(body, hook.Secret))
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, res.Status
+	}
+	return res.StatusCode, ""
+}
+
+// ownedRepo checks that the logged in user owns the repository of the
+// request. It writes the error response and returns false otherwise.
+func ownedRepo(w http.ResponseWriter, r *http.Request) (string, bool) {
+	ut, err := getSessionOrRedirect(w, r)
+	if err != nil {
+		log.Write("[Info] %s: Redirecting to login", err.Error())
+		return "", false
+	}
+	vars := mux.Vars(r)
+	repopath := fmt.Sprintf("%s/%s", vars["user"], vars["repo"])
+	gcl := ginclient.New(serveralias)
+	gcl.UserToken = ut
+	repoinfo, err := gcl.GetRepo(repopath)
+	if err != nil {
+		fail(w, http.StatusNotFound, err.Error())
+		return "", false
+	}
+	if !isRepoOwner(repoinfo, ut.Username) {
+		fail(w, http.StatusForbidden, "only the repository owner can manage webhooks")
+		return "", false
+	}
+	return repopath, true
+}
+
+// AddWebhook subscribes the URL posted in the 'url' form field to the
+// validations of a repository. Payloads are signed with the optional 'secret'
+// form field. Only the owner of the repository can add webhooks.
+func AddWebhook(w http.ResponseWriter, r *http.Request) {
+	repopath, ok := ownedRepo(w, r)
+	if !ok {
+		return
+	}
+	hook, err := addWebhook(repopath, strings.TrimSpace(r.FormValue("url")), r.FormValue("secret"))
+	if err != nil {
+		fail(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	log.ShowWrite("[Info] added webhook %s to %q", hook.URL, repopath)
+	http.Redirect(w, r, fmt.Sprintf("/repos/%s/hooks", repopath), http.StatusFound)
+}
+
+// RemoveWebhook removes a webhook from a repository. Only the owner of the
+// repository can remove webhooks.
+func RemoveWebhook(w http.ResponseWriter, r *http.Request) {
+	repopath, ok := ownedRepo(w, r)
+	if !ok {
+		return
+	}
+	found, err := removeWebhook(repopath, mux.Vars(r)["hookid"])
+	if err != nil {
+		log.ShowWrite("[Error] removing webhook of %q: %s", repopath, err.Error())
+		fail(w, http.StatusInternalServerError, "something went wrong")
+		return
+	} else if !found {
+		fail(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+	log.ShowWrite("[Info] removed webhook from %q", repopath)
+	http.Redirect(w, r, fmt.Sprintf("/repos/%s/hooks", repopath), http.StatusFound)
+}
@@ -0,0 +1,285 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/G-Node/gin-valid/internal/config"
+	"github.com/G-Node/gin-valid/internal/validators"
+)
+
+// webhookRequest is a delivery received by the webhook test server.
+type webhookRequest struct {
+	signature string
+	payload   webhookPayload
+	body      []byte
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	received := make(chan webhookRequest, 10)
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			// the first attempt fails and is retried
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		req := webhookRequest{signature: r.Header.Get("X-GinValid-Signature")}
+		req.body, _ = ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(req.body, &req.payload); err != nil {
+			t.Errorf("invalid webhook payload: %s", err.Error())
+		}
+		received <- req
+	}))
+	defer srv.Close()
+
+	srvcfg := config.Read()
+	original := srvcfg
+	srvcfg.Settings.RootURL = "https://valid.example.org"
+	srvcfg.Settings.WebhookAttempts = 3
+	srvcfg.Settings.WebhookRetry = 1
+	srvcfg.Settings.WebhookAllowedNets = []string{"127.0.0.0/8"}
+	srvcfg.Dir.Webhooks = "webhooks-testing"
+	tmpdir, _ := ioutil.TempDir("", "webhooks")
+	defer os.RemoveAll(tmpdir)
+	srvcfg.TokenStore.KeyFile = filepath.Join(tmpdir, "tokens.key")
+	config.Set(srvcfg)
+	defer config.Set(original)
+	defer os.RemoveAll(srvcfg.Dir.Webhooks)
+	if err := LoadTokenKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	repopath := username + "/webhook-testing"
+	if _, err := addWebhook(repopath, "ftp://example.org", ""); err == nil {
+		t.Fatal("adding a webhook with an unsupported URL should fail")
+	}
+	hook, err := addWebhook(repopath, srv.URL, "webhooksecret")
+	if err != nil {
+		t.Fatalf("failed to add webhook: %s", err.Error())
+	}
+	// secrets are not stored in plain text
+	content, _ := ioutil.ReadFile(filepath.Join(webhooksDir(repopath), webhooksFile))
+	if bytes.Contains(content, []byte("webhooksecret")) {
+		t.Fatalf("webhook secret stored unencrypted: %s", content)
+	}
+	// an unreachable webhook does not affect the delivery to the others
+	unreachable, _ := addWebhook(repopath, "http://127.0.0.1:1/hook", "")
+
+	j := &job{Validator: "bids", Repopath: repopath, Commit: "abc123", Automatic: true}
+	resdir := filepath.Join(srvcfg.Dir.Result, j.respath())
+	os.MkdirAll(resdir, 0755)
+	defer os.RemoveAll(filepath.Join(srvcfg.Dir.Result, "bids", username, "webhook-testing"))
+	rep := validators.NewReport("bids", []validators.Issue{
+		{Severity: validators.SeverityError, Message: "broken"},
+		{Severity: validators.SeverityWarning, Message: "odd"},
+	})
+	validators.WriteReport(resdir, rep)
+
+	sendWebhooks(j, resdir, statedone)
+	var req webhookRequest
+	select {
+	case req = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not delivered")
+	}
+	if req.signature != hookSignature(req.body, "webhooksecret") {
+		t.Fatalf("invalid webhook signature %q", req.signature)
+	}
+	expected := webhookPayload{
+		Validator:  "bids",
+		Repository: repopath,
+		Commit:     "abc123",
+		State:      statedone,
+		Outcome:    "error",
+		Errors:     1,
+		Warnings:   1,
+		URL:        "https://valid.example.org/results/bids/" + repopath + "/abc123",
+	}
+	if req.payload != expected {
+		t.Fatalf("unexpected payload %+v", req.payload)
+	}
+
+	// wait for both deliveries to be logged
+	var deliveries []webhookDelivery
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(50 * time.Millisecond) {
+		deliveries, err = readDeliveries(repopath, maxDeliveries)
+		if err != nil {
+			t.Fatalf("failed to read deliveries: %s", err.Error())
+		}
+		if len(deliveries) == 2 {
+			break
+		}
+	}
+	if len(deliveries) != 2 {
+		t.Fatalf("unexpected deliveries %+v", deliveries)
+	}
+	for _, delivery := range deliveries {
+		switch delivery.Webhook {
+		case hook.ID:
+			if !delivery.Delivered || delivery.Attempts != 2 || delivery.Status != http.StatusOK {
+				t.Fatalf("unexpected delivery %+v", delivery)
+			}
+		case unreachable.ID:
+			if delivery.Delivered || delivery.Attempts != 3 || delivery.Error == "" {
+				t.Fatalf("unexpected delivery %+v", delivery)
+			}
+		default:
+			t.Fatalf("delivery for unknown webhook %+v", delivery)
+		}
+	}
+
+	// one-time validations and removed webhooks are not delivered
+	j.Automatic = false
+	sendWebhooks(j, resdir, statedone)
+	j.Automatic = true
+	if found, err := removeWebhook(repopath, hook.ID); !found || err != nil {
+		t.Fatalf("failed to remove webhook: %v", err)
+	}
+	removeWebhook(repopath, unreachable.ID)
+	sendWebhooks(j, resdir, statefailed)
+	select {
+	case req := <-received:
+		t.Fatalf("unexpected webhook delivery %+v", req.payload)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if hooks, _ := readWebhooks(repopath); len(hooks) != 0 {
+		t.Fatalf("unexpected webhooks after removal %+v", hooks)
+	}
+}
+
+func TestWebhookAddresses(t *testing.T) {
+	received := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+	}))
+	defer srv.Close()
+
+	srvcfg := config.Read()
+	original := srvcfg
+	srvcfg.Settings.WebhookAllowedNets = nil
+	srvcfg.Dir.Webhooks = "webhooks-testing"
+	srvcfg.TokenStore.KeyFile = ""
+	config.Set(srvcfg)
+	defer config.Set(original)
+	defer os.RemoveAll(srvcfg.Dir.Webhooks)
+
+	// webhooks cannot target the server itself or its internal network
+	repopath := username + "/webhook-addresses"
+	for _, hookurl := range []string{
+		"http://127.0.0.1:3000/hook",
+		"http://localhost/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.1.2.3/hook",
+		"http://172.20.0.2:3000/hook",
+		"http://192.168.1.1/hook",
+		"http://[::1]/hook",
+		"http://[fe80::1]/hook",
+		"http://0.0.0.0/hook",
+	} {
+		if _, err := addWebhook(repopath, hookurl, ""); err == nil {
+			t.Fatalf("adding webhook %q should fail", hookurl)
+		}
+	}
+	if _, err := addWebhook(repopath, "https://93.184.216.34/hook", ""); err != nil {
+		t.Fatalf("failed to add webhook with public address: %s", err.Error())
+	}
+
+	// addresses are checked again when connecting, e.g. if the host resolves
+	// to a different address than when the webhook was added
+	hook := webhook{ID: "rebound", URL: srv.URL, Secret: "secret"}
+	if status, errmsg := postWebhook(webhookClient(), hook, "delivery", []byte("{}")); status != 0 || !strings.Contains(errmsg, "not public") {
+		t.Fatalf("delivery to loopback address returned %d %q", status, errmsg)
+	}
+	select {
+	case <-received:
+		t.Fatal("webhook delivered to loopback address")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// configured networks are allowed
+	srvcfg.Settings.WebhookAllowedNets = []string{"127.0.0.0/8"}
+	config.Set(srvcfg)
+	if status, errmsg := postWebhook(webhookClient(), hook, "delivery", []byte("{}")); errmsg != "" {
+		t.Fatalf("delivery to allowed network returned %d %q", status, errmsg)
+	}
+}
+
+func TestWebhookSecretsEncrypted(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir("", "webhooks")
+	defer os.RemoveAll(tmpdir)
+	srvcfg := config.Read()
+	original := srvcfg
+	srvcfg.Dir.Webhooks = filepath.Join(tmpdir, "webhooks")
+	srvcfg.TokenStore.KeyFile = filepath.Join(tmpdir, "tokens.key")
+	config.Set(srvcfg)
+	defer config.Set(original)
+	if err := LoadTokenKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	// secrets of webhooks added before secrets were encrypted are encrypted
+	// when they are read
+	repopath := username + "/webhook-secrets"
+	hookfile := filepath.Join(webhooksDir(repopath), webhooksFile)
+	os.MkdirAll(filepath.Dir(hookfile), 0755)
+	legacy := `[{"id": "legacy", "url": "https://example.org/hook", "secret": "plainsecret", "created": "2020-03-01T12:00:00Z"}]`
+	ioutil.WriteFile(hookfile, []byte(legacy), 0600)
+	hooks, err := readWebhooks(repopath)
+	if err != nil || len(hooks) != 1 || hooks[0].Secret != "plainsecret" {
+		t.Fatalf("failed to read legacy webhook %+v: %v", hooks, err)
+	}
+	content, _ := ioutil.ReadFile(hookfile)
+	if bytes.Contains(content, []byte("plainsecret")) {
+		t.Fatalf("legacy webhook secret not encrypted on first read: %s", content)
+	}
+
+	// re-encrypting stores the secrets of all repositories encrypted, so
+	// they cannot be read without the key
+	ioutil.WriteFile(hookfile, []byte(legacy), 0600)
+	if count, err := reencryptWebhooks(); err != nil || count != 1 {
+		t.Fatalf("re-encrypting webhooks returned %d: %v", count, err)
+	}
+	srvcfg.TokenStore.KeyFile = ""
+	config.Set(srvcfg)
+	if hooks, err := readWebhooks(repopath); err == nil {
+		t.Fatalf("read encrypted webhook secret without key %+v", hooks)
+	}
+}
+
+func TestWebhookDeliveryLogTrimmed(t *testing.T) {
+	tmpdir, _ := ioutil.TempDir("", "webhooks")
+	defer os.RemoveAll(tmpdir)
+	srvcfg := config.Read()
+	original := srvcfg
+	srvcfg.Dir.Webhooks = tmpdir
+	config.Set(srvcfg)
+	defer config.Set(original)
+
+	repopath := username + "/webhook-deliveries"
+	for idx := 0; idx < maxDeliveries+5; idx++ {
+		if err := logDelivery(repopath, webhookDelivery{Webhook: "hook", Attempts: idx}); err != nil {
+			t.Fatalf("failed to log delivery: %s", err.Error())
+		}
+	}
+	content, _ := ioutil.ReadFile(filepath.Join(webhooksDir(repopath), deliveriesFile))
+	if lines := strings.Count(string(content), "\n"); lines != maxDeliveries {
+		t.Fatalf("delivery log holds %d deliveries, expected %d", lines, maxDeliveries)
+	}
+	deliveries, err := readDeliveries(repopath, maxDeliveries)
+	if err != nil || len(deliveries) != maxDeliveries {
+		t.Fatalf("unexpected deliveries %+v: %v", deliveries, err)
+	}
+	if deliveries[0].Attempts != maxDeliveries+4 || deliveries[maxDeliveries-1].Attempts != 5 {
+		t.Fatalf("delivery log does not hold the last deliveries: %+v", deliveries)
+	}
+}